@@ -0,0 +1,141 @@
+// Package agent keeps health signal of Mesos agents. Tasks lost, dropped or
+// gone because of infrastructure are not fault of application, so instead of
+// application score they count against agent they were running on.
+package agent
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+type health struct {
+	score     int
+	lastFault time.Time
+	// reasons counts faults per reason reported by Mesos
+	reasons map[string]int
+}
+
+// Registry keeps infrastructure faults score of every agent, score is
+// forgotten when agent has no faults for reset interval
+type Registry struct {
+	mutex  sync.Mutex
+	config Config
+	agents map[string]*health
+	now    func() time.Time
+}
+
+// New creates agents health registry
+func New(config Config) (*Registry, error) {
+	if config.UnhealthyScore <= 0 {
+		return nil, errors.New("agent unhealthy score should be positive")
+	}
+	if config.ResetInterval <= 0 {
+		return nil, errors.New("agent reset interval should be positive")
+	}
+	return &Registry{
+		config: config,
+		agents: make(map[string]*health),
+		now:    time.Now,
+	}, nil
+}
+
+// Fault adds weight to score of agent running on host
+func (r *Registry) Fault(host string, weight int, reason string) {
+	if host == "" {
+		metrics.Mark("agent.faults.unknown")
+		return
+	}
+	metrics.Mark("agent.faults")
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expire()
+
+	agent, ok := r.agents[host]
+	if !ok {
+		agent = &health{reasons: make(map[string]int)}
+		r.agents[host] = agent
+	}
+	wasUnhealthy := r.unhealthy(agent)
+	agent.score += weight
+	agent.lastFault = r.now()
+	if reason != "" {
+		agent.reasons[reason]++
+	}
+
+	if !wasUnhealthy && r.unhealthy(agent) {
+		log.WithFields(log.Fields{
+			"Host":    host,
+			"Score":   agent.score,
+			"Reasons": agent.reasons,
+		}).Warn("Agent unhealthy")
+	}
+	r.reportUnhealthy()
+}
+
+// Score returns current faults score of agent running on host
+func (r *Registry) Score(host string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expire()
+
+	if agent, ok := r.agents[host]; ok {
+		return agent.score
+	}
+	return 0
+}
+
+// Unhealthy returns sorted hosts of agents with score above unhealthy score
+func (r *Registry) Unhealthy() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.expire()
+
+	hosts := []string{}
+	for host, agent := range r.agents {
+		if r.unhealthy(agent) {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// unhealthy must be called with mutex held
+func (r *Registry) unhealthy(agent *health) bool {
+	return agent.score > r.config.UnhealthyScore
+}
+
+// reportUnhealthy updates unhealthy agents gauge, must be called with mutex
+// held
+func (r *Registry) reportUnhealthy() {
+	count := 0
+	for _, agent := range r.agents {
+		if r.unhealthy(agent) {
+			count++
+		}
+	}
+	metrics.UpdateGauge("agent.unhealthy", int64(count))
+}
+
+// expire forgets agents without faults for reset interval, must be called
+// with mutex held
+func (r *Registry) expire() {
+	now := r.now()
+	expired := false
+	for host, agent := range r.agents {
+		if now.Sub(agent.lastFault) > r.config.ResetInterval {
+			log.WithField("Host", host).Debug("Agent faults forgotten")
+			delete(r.agents, host)
+			expired = true
+		}
+	}
+	if expired {
+		r.reportUnhealthy()
+	}
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReturnsErrorForInvalidConfig(t *testing.T) {
+	t.Parallel()
+	// when
+	_, scoreErr := New(Config{UnhealthyScore: 0, ResetInterval: time.Hour})
+	_, intervalErr := New(Config{UnhealthyScore: 1, ResetInterval: 0})
+	// then
+	assert.Error(t, scoreErr)
+	assert.Error(t, intervalErr)
+}
+
+func TestFaultReportsAgentUnhealthyAboveUnhealthyScore(t *testing.T) {
+	t.Parallel()
+	// given
+	registry, err := New(Config{UnhealthyScore: 2, ResetInterval: time.Hour})
+	require.NoError(t, err)
+	// when
+	registry.Fault("a", 2, "REASON_AGENT_DISCONNECTED")
+	registry.Fault("b", 1, "")
+	registry.Fault("b", 2, "")
+	registry.Fault("", 5, "")
+	// then
+	assert.Equal(t, 2, registry.Score("a"))
+	assert.Equal(t, 3, registry.Score("b"))
+	assert.Equal(t, []string{"b"}, registry.Unhealthy())
+}
+
+func TestFaultsAreForgottenAfterResetInterval(t *testing.T) {
+	t.Parallel()
+	// given
+	now := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	registry, err := New(Config{UnhealthyScore: 1, ResetInterval: time.Hour})
+	require.NoError(t, err)
+	registry.now = func() time.Time { return now }
+	registry.Fault("a", 5, "")
+	registry.Fault("b", 5, "")
+	// when
+	now = now.Add(time.Hour - time.Minute)
+	registry.Fault("b", 1, "")
+	now = now.Add(2 * time.Minute)
+	// then
+	assert.Equal(t, 0, registry.Score("a"))
+	assert.Equal(t, 6, registry.Score("b"))
+	assert.Equal(t, []string{"b"}, registry.Unhealthy())
+}
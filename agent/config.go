@@ -0,0 +1,12 @@
+package agent
+
+import "time"
+
+// Config specific to agent package
+type Config struct {
+	// UnhealthyScore is infrastructure faults score above which agent is
+	// reported unhealthy
+	UnhealthyScore int
+	// ResetInterval after last fault when agent score is forgotten
+	ResetInterval time.Duration
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/metrics"
+	"github.com/allegro/marathon-appcop/score"
+)
+
+// Actions accepted by write endpoints, sent to /api/v1/apps/{id}/{action}
+const (
+	// ActionReset forgets application score
+	ActionReset = "reset"
+	// ActionPardon lowers application score by threshold
+	ActionPardon = "pardon"
+	// ActionImmunity grants (POST) or revokes (DELETE) immunity
+	ActionImmunity = "immunity"
+	// ActionPenalize takes penalty regardless of application score
+	ActionPenalize = "penalize"
+)
+
+const defaultReason = "requested by operator"
+
+// token is credential of caller allowed to use write endpoints
+type token struct {
+	caller string
+	secret []byte
+}
+
+func parseTokens(tokens string) ([]token, error) {
+	parsed := []token{}
+	separator := func(r rune) bool { return r == ',' || r == '\n' }
+	for _, pair := range strings.FieldsFunc(tokens, separator) {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			// token is not quoted, it may be caller's secret
+			return nil, errors.New("invalid API tokens, expected comma or newline separated caller=token pairs")
+		}
+		parsed = append(parsed, token{caller: parts[0], secret: []byte(parts[1])})
+	}
+	return parsed, nil
+}
+
+// authenticate returns caller identified by bearer token
+func (h *handler) authenticate(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	secret := []byte(strings.TrimPrefix(header, "Bearer "))
+	caller := ""
+	// every token is compared so response time does not reveal which one
+	// matched
+	for _, t := range h.tokens {
+		if subtle.ConstantTimeCompare(t.secret, secret) == 1 {
+			caller = t.caller
+		}
+	}
+	return caller, caller != ""
+}
+
+// serveAction takes action requested with POST or DELETE on
+// /api/v1/apps/{id}/{action}, responds with application state after action
+func (h *handler) serveAction(w http.ResponseWriter, r *http.Request, path string) {
+	if len(h.tokens) == 0 {
+		write(w, http.StatusForbidden, errorResponse{Error: "write API is disabled"})
+		return
+	}
+	caller, ok := h.authenticate(r)
+	if !ok {
+		metrics.Mark("api.unauthorized")
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		write(w, http.StatusUnauthorized, errorResponse{Error: "invalid or missing token"})
+		return
+	}
+
+	slash := strings.LastIndex(path, "/")
+	appID, action := marathon.AppID(path[:slash]), path[slash+1:]
+	if appID == "" {
+		write(w, http.StatusNotFound, errorResponse{Error: "no application id"})
+		return
+	}
+	// followers do not evaluate scores, actions taken there would be lost
+	if h.operator.Paused() {
+		write(w, http.StatusServiceUnavailable, errorResponse{Error: "replica is not leading"})
+		return
+	}
+
+	query := r.URL.Query()
+	reason := query.Get("reason")
+	if reason == "" {
+		reason = defaultReason
+	}
+
+	switch r.Method + " " + action {
+	case "POST " + ActionReset:
+		h.operator.Reset(appID, caller, reason)
+	case "POST " + ActionPardon:
+		if !h.operator.Pardon(appID, caller, reason) {
+			write(w, http.StatusNotFound, errorResponse{Error: "application has no score"})
+			return
+		}
+	case "POST " + ActionImmunity:
+		duration, err := time.ParseDuration(query.Get("duration"))
+		if err != nil || duration <= 0 {
+			write(w, http.StatusBadRequest, errorResponse{Error: "duration should be positive, e.g. 2h"})
+			return
+		}
+		h.operator.GrantImmunity(appID, duration, caller, reason)
+	case "DELETE " + ActionImmunity:
+		h.operator.RevokeImmunity(appID, caller, reason)
+	case "POST " + ActionPenalize:
+		dryRun := false
+		if value := query.Get("dryRun"); value != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(value); err != nil {
+				write(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid dryRun: %q", value)})
+				return
+			}
+		}
+		err := h.operator.Penalize(r.Context(), appID, dryRun, caller, reason)
+		if _, immune := err.(score.ImmunityError); immune {
+			write(w, http.StatusConflict, errorResponse{Error: err.Error()})
+			return
+		}
+		if err != nil {
+			write(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+			return
+		}
+	default:
+		write(w, http.StatusNotFound, errorResponse{Error: "unknown action " + r.Method + " " + action})
+		return
+	}
+
+	appScore, _ := h.operator.AppScore(appID)
+	write(w, http.StatusOK, newApp(appScore, h.operator.Threshold()))
+}
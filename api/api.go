@@ -0,0 +1,237 @@
+// Package api serves JSON view of Scorer state, so operators can check scores
+// without enabling debug logs, and lets authenticated operators adjust them.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/metrics"
+	"github.com/allegro/marathon-appcop/score"
+)
+
+// Prefix under which API is served
+const Prefix = "/api/v1/"
+
+// Sort keys accepted by scores endpoint
+const (
+	SortScore      = "score"
+	SortAppID      = "appId"
+	SortLastUpdate = "lastUpdate"
+)
+
+// Operator is source of application scores taking actions requested by
+// operators, implemented by score.Scorer
+type Operator interface {
+	Scores() []score.AppScore
+	AppScore(marathon.AppID) (score.AppScore, bool)
+	Threshold() int
+	Paused() bool
+	Reset(appID marathon.AppID, actor, reason string)
+	Pardon(appID marathon.AppID, actor, reason string) bool
+	GrantImmunity(appID marathon.AppID, duration time.Duration, actor, reason string)
+	RevokeImmunity(appID marathon.AppID, actor, reason string)
+	Penalize(ctx context.Context, appID marathon.AppID, dryRun bool, actor, reason string) error
+}
+
+// Penalty is last action taken against application
+type Penalty struct {
+	Action string `json:"action"`
+	Actor  string `json:"actor"`
+	At     string `json:"at"`
+	Score  int    `json:"score"`
+	DryRun bool   `json:"dryRun"`
+	Error  string `json:"error,omitempty"`
+}
+
+// App is current state of application kept by Scorer
+type App struct {
+	AppID      string `json:"appId"`
+	Score      int    `json:"score"`
+	LastUpdate string `json:"lastUpdate,omitempty"`
+	// Threshold is score above which application is penalized
+	Threshold int  `json:"threshold"`
+	Immune    bool `json:"immune"`
+	// ImmuneUntil is end of immunity granted through API
+	ImmuneUntil string   `json:"immuneUntil,omitempty"`
+	LastPenalty *Penalty `json:"lastPenalty,omitempty"`
+}
+
+// ScoresResponse lists applications tracked by Scorer
+type ScoresResponse struct {
+	Threshold int   `json:"threshold"`
+	Apps      []App `json:"apps"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	operator Operator
+	tokens   []token
+}
+
+// New creates handler serving scores and operator actions under Prefix
+func New(operator Operator, config Config) (http.Handler, error) {
+	tokens, err := parseTokens(config.Tokens)
+	if err != nil {
+		return nil, err
+	}
+	return &handler{operator: operator, tokens: tokens}, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, Prefix)
+	read := r.Method == "GET" || r.Method == "HEAD"
+	modify := r.Method == "POST" || r.Method == "DELETE"
+	switch {
+	case read && path == "scores":
+		metrics.Time("api.scores", func() { h.serveScores(w, r) })
+	case read && strings.HasPrefix(path, "apps/"):
+		metrics.Time("api.apps", func() { h.serveApp(w, strings.TrimPrefix(path, "apps")) })
+	case modify && strings.HasPrefix(path, "apps/"):
+		metrics.Time("api.actions", func() { h.serveAction(w, r, strings.TrimPrefix(path, "apps")) })
+	case !read && !modify:
+		write(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+	default:
+		write(w, http.StatusNotFound, errorResponse{Error: "not found"})
+	}
+}
+
+// serveScores lists applications, optional query parameters are group
+// (prefix of application id), sort (score, appId or lastUpdate) and
+// order (asc or desc)
+func (h *handler) serveScores(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	less, err := comparator(query.Get("sort"), query.Get("order"))
+	if err != nil {
+		write(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	group := strings.TrimSuffix(query.Get("group"), "/")
+
+	scores := []score.AppScore{}
+	for _, appScore := range h.operator.Scores() {
+		if inGroup(appScore.AppID, group) {
+			scores = append(scores, appScore)
+		}
+	}
+	sort.Slice(scores, func(i, j int) bool { return less(scores[i], scores[j]) })
+
+	threshold := h.operator.Threshold()
+	apps := make([]App, 0, len(scores))
+	for _, appScore := range scores {
+		apps = append(apps, newApp(appScore, threshold))
+	}
+
+	write(w, http.StatusOK, ScoresResponse{Threshold: threshold, Apps: apps})
+}
+
+func (h *handler) serveApp(w http.ResponseWriter, id string) {
+	if id == "/" {
+		write(w, http.StatusNotFound, errorResponse{Error: "no application id"})
+		return
+	}
+	appScore, ok := h.operator.AppScore(marathon.AppID(id))
+	if !ok {
+		write(w, http.StatusNotFound, errorResponse{Error: "application " + id + " is not scored"})
+		return
+	}
+	write(w, http.StatusOK, newApp(appScore, h.operator.Threshold()))
+}
+
+// inGroup checks whether application belongs to group or its subgroups,
+// group is matched on path segments so /foo does not match /foobar/app
+func inGroup(appID marathon.AppID, group string) bool {
+	if group == "" {
+		return true
+	}
+	id := appID.String()
+	return id == group || strings.HasPrefix(id, group+"/")
+}
+
+type lessFunc func(a, b score.AppScore) bool
+
+func comparator(sortBy, order string) (lessFunc, error) {
+	var less lessFunc
+	// scores and updates are most interesting when highest and most recent
+	descending := true
+	switch sortBy {
+	case "", SortScore:
+		less = func(a, b score.AppScore) bool { return a.Score < b.Score }
+	case SortAppID:
+		less = func(a, b score.AppScore) bool { return a.AppID < b.AppID }
+		descending = false
+	case SortLastUpdate:
+		less = func(a, b score.AppScore) bool { return a.LastUpdate.Before(b.LastUpdate) }
+	default:
+		return nil, fmt.Errorf("invalid sort: %q", sortBy)
+	}
+
+	switch order {
+	case "":
+	case "asc":
+		descending = false
+	case "desc":
+		descending = true
+	default:
+		return nil, fmt.Errorf("invalid order: %q", order)
+	}
+
+	// ties are ordered by application id, so output is stable between calls
+	return func(a, b score.AppScore) bool {
+		if less(a, b) == less(b, a) {
+			return a.AppID < b.AppID
+		}
+		if descending {
+			return less(b, a)
+		}
+		return less(a, b)
+	}, nil
+}
+
+func newApp(appScore score.AppScore, threshold int) App {
+	app := App{
+		AppID:     appScore.AppID.String(),
+		Score:     appScore.Score,
+		Threshold: threshold,
+		Immune:    appScore.Immune,
+	}
+	if !appScore.ImmuneUntil.IsZero() {
+		app.ImmuneUntil = formatTime(appScore.ImmuneUntil)
+	}
+	if !appScore.LastUpdate.IsZero() {
+		app.LastUpdate = formatTime(appScore.LastUpdate)
+	}
+	if penalty := appScore.LastPenalty; penalty != nil {
+		app.LastPenalty = &Penalty{
+			Action: penalty.Action,
+			Actor:  penalty.Actor,
+			At:     formatTime(penalty.At),
+			Score:  penalty.Score,
+			DryRun: penalty.DryRun,
+			Error:  penalty.Err,
+		}
+	}
+	return app
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func write(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.WithError(err).Error("Unable to write API response")
+	}
+}
@@ -0,0 +1,323 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/score"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubOperator serves fixed scores and records requested actions
+type stubOperator struct {
+	scores      []score.AppScore
+	paused      bool
+	penalizeErr error
+	actions     []string
+}
+
+func (s *stubOperator) Scores() []score.AppScore {
+	return s.scores
+}
+
+func (s *stubOperator) AppScore(appID marathon.AppID) (score.AppScore, bool) {
+	for _, appScore := range s.scores {
+		if appScore.AppID == appID {
+			return appScore, true
+		}
+	}
+	return score.AppScore{AppID: appID}, false
+}
+
+func (s *stubOperator) Threshold() int {
+	return 10
+}
+
+func (s *stubOperator) Paused() bool {
+	return s.paused
+}
+
+func (s *stubOperator) Reset(appID marathon.AppID, actor, reason string) {
+	s.record("reset", appID, actor, reason)
+}
+
+func (s *stubOperator) Pardon(appID marathon.AppID, actor, reason string) bool {
+	if _, ok := s.AppScore(appID); !ok {
+		return false
+	}
+	s.record("pardon", appID, actor, reason)
+	return true
+}
+
+func (s *stubOperator) GrantImmunity(appID marathon.AppID, duration time.Duration, actor, reason string) {
+	s.record("grant "+duration.String(), appID, actor, reason)
+}
+
+func (s *stubOperator) RevokeImmunity(appID marathon.AppID, actor, reason string) {
+	s.record("revoke", appID, actor, reason)
+}
+
+func (s *stubOperator) Penalize(_ context.Context, appID marathon.AppID, dryRun bool, actor, reason string) error {
+	s.record(fmt.Sprintf("penalize dryRun=%t", dryRun), appID, actor, reason)
+	return s.penalizeErr
+}
+
+func (s *stubOperator) record(action string, appID marathon.AppID, actor, reason string) {
+	s.actions = append(s.actions, fmt.Sprintf("%s %s by %s: %s", action, appID, actor, reason))
+}
+
+var now = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+var testScores = []score.AppScore{
+	{AppID: "/team/b", Score: 3, LastUpdate: now.Add(-time.Minute)},
+	{AppID: "/team/a", Score: 7, LastUpdate: now, Immune: true},
+	{AppID: "/teammate/c", Score: 5, LastUpdate: now.Add(-time.Hour)},
+	{AppID: "/other/d", Score: 0, LastPenalty: &score.Penalty{Action: "scaleDown", At: now, Score: 12, DryRun: true}},
+}
+
+func serve(t *testing.T, operator Operator, method, url, token string, response interface{}) int {
+	handler, err := New(operator, Config{Tokens: "alice=secret, bob=hunter2"})
+	require.NoError(t, err)
+	request := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), response))
+	return recorder.Code
+}
+
+func get(t *testing.T, url string, response interface{}) int {
+	return serve(t, &stubOperator{scores: testScores}, "GET", url, "", response)
+}
+
+func appIDs(response ScoresResponse) []string {
+	ids := []string{}
+	for _, app := range response.Apps {
+		ids = append(ids, app.AppID)
+	}
+	return ids
+}
+
+var scoresTestCases = []struct {
+	query    string
+	expected []string
+}{
+	{"", []string{"/team/a", "/teammate/c", "/team/b", "/other/d"}},
+	{"?order=asc", []string{"/other/d", "/team/b", "/teammate/c", "/team/a"}},
+	{"?sort=appId", []string{"/other/d", "/team/a", "/team/b", "/teammate/c"}},
+	{"?sort=appId&order=desc", []string{"/teammate/c", "/team/b", "/team/a", "/other/d"}},
+	{"?sort=lastUpdate", []string{"/team/a", "/team/b", "/teammate/c", "/other/d"}},
+	{"?group=/team", []string{"/team/a", "/team/b"}},
+	{"?group=/team/&sort=appId", []string{"/team/a", "/team/b"}},
+	{"?group=/team/a", []string{"/team/a"}},
+	{"?group=/missing", []string{}},
+}
+
+func TestScoresAreSortedAndFilteredByGroup(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range scoresTestCases {
+		// given
+		response := ScoresResponse{}
+		// when
+		status := get(t, "/api/v1/scores"+testCase.query, &response)
+		// then
+		assert.Equal(t, http.StatusOK, status, testCase.query)
+		assert.Equal(t, 10, response.Threshold)
+		assert.Equal(t, testCase.expected, appIDs(response), testCase.query)
+	}
+}
+
+func TestScoresRejectsInvalidSortAndOrder(t *testing.T) {
+	t.Parallel()
+	for _, query := range []string{"?sort=host", "?order=up"} {
+		// given
+		response := errorResponse{}
+		// when
+		status := get(t, "/api/v1/scores"+query, &response)
+		// then
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.NotEmpty(t, response.Error)
+	}
+}
+
+func TestAppReturnsScoreImmunityAndLastPenalty(t *testing.T) {
+	t.Parallel()
+	// given
+	immune := App{}
+	penalized := App{}
+	// when
+	immuneStatus := get(t, "/api/v1/apps/team/a", &immune)
+	penalizedStatus := get(t, "/api/v1/apps/other/d", &penalized)
+	// then
+	assert.Equal(t, http.StatusOK, immuneStatus)
+	assert.Equal(t, App{AppID: "/team/a", Score: 7, LastUpdate: "2017-03-01T12:00:00Z", Threshold: 10, Immune: true}, immune)
+	assert.Equal(t, http.StatusOK, penalizedStatus)
+	assert.Equal(t, App{AppID: "/other/d", Threshold: 10,
+		LastPenalty: &Penalty{Action: "scaleDown", At: "2017-03-01T12:00:00Z", Score: 12, DryRun: true}}, penalized)
+}
+
+func TestAppReturnsNotFoundForUnscoredApp(t *testing.T) {
+	t.Parallel()
+	for _, url := range []string{"/api/v1/apps/team/x", "/api/v1/apps/", "/api/v1/unknown"} {
+		// given
+		response := errorResponse{}
+		// when
+		status := get(t, url, &response)
+		// then
+		assert.Equal(t, http.StatusNotFound, status, url)
+		assert.NotEmpty(t, response.Error)
+	}
+}
+
+func TestUnsupportedMethodsAreNotAllowed(t *testing.T) {
+	t.Parallel()
+	// given
+	response := errorResponse{}
+	// when
+	status := serve(t, &stubOperator{}, "PUT", "/api/v1/scores", "secret", &response)
+	// then
+	assert.Equal(t, http.StatusMethodNotAllowed, status)
+}
+
+var actionTestCases = []struct {
+	method   string
+	url      string
+	status   int
+	expected string
+}{
+	{"POST", "/api/v1/apps/team/a/reset", http.StatusOK, "reset /team/a by alice: requested by operator"},
+	{"POST", "/api/v1/apps/team/a/pardon?reason=fixed", http.StatusOK, "pardon /team/a by alice: fixed"},
+	{"POST", "/api/v1/apps/team/unknown/pardon", http.StatusNotFound, ""},
+	{"POST", "/api/v1/apps/team/a/immunity?duration=2h", http.StatusOK, "grant 2h0m0s /team/a by alice: requested by operator"},
+	{"DELETE", "/api/v1/apps/team/a/immunity", http.StatusOK, "revoke /team/a by alice: requested by operator"},
+	{"POST", "/api/v1/apps/team/a/penalize", http.StatusOK, "penalize dryRun=false /team/a by alice: requested by operator"},
+	{"POST", "/api/v1/apps/team/a/penalize?dryRun=true", http.StatusOK, "penalize dryRun=true /team/a by alice: requested by operator"},
+	{"POST", "/api/v1/apps/team/a/penalize?dryRun=maybe", http.StatusBadRequest, ""},
+	{"POST", "/api/v1/apps/team/a/immunity", http.StatusBadRequest, ""},
+	{"POST", "/api/v1/apps/team/a/immunity?duration=-1h", http.StatusBadRequest, ""},
+	{"DELETE", "/api/v1/apps/team/a/reset", http.StatusNotFound, ""},
+	{"POST", "/api/v1/apps/reset", http.StatusNotFound, ""},
+	{"POST", "/api/v1/scores", http.StatusNotFound, ""},
+}
+
+func TestActionsAreTakenOnBehalfOfCaller(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range actionTestCases {
+		// given
+		operator := &stubOperator{scores: testScores}
+		response := map[string]interface{}{}
+		// when
+		status := serve(t, operator, testCase.method, testCase.url, "secret", &response)
+		// then
+		assert.Equal(t, testCase.status, status, testCase.url)
+		if testCase.expected == "" {
+			assert.Empty(t, operator.actions, testCase.url)
+			continue
+		}
+		assert.Equal(t, []string{testCase.expected}, operator.actions, testCase.url)
+		assert.Equal(t, "/team/a", response["appId"], "responds with application state")
+	}
+}
+
+func TestActionsRequireValidToken(t *testing.T) {
+	t.Parallel()
+	for _, token := range []string{"", "wrong", "alice"} {
+		// given
+		operator := &stubOperator{}
+		response := errorResponse{}
+		// when
+		status := serve(t, operator, "POST", "/api/v1/apps/team/a/reset", token, &response)
+		// then
+		assert.Equal(t, http.StatusUnauthorized, status, token)
+		assert.Empty(t, operator.actions)
+	}
+}
+
+func TestActionsIdentifyCallerByToken(t *testing.T) {
+	t.Parallel()
+	// given
+	operator := &stubOperator{}
+	response := App{}
+	// when
+	status := serve(t, operator, "POST", "/api/v1/apps/team/a/reset", "hunter2", &response)
+	// then
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"reset /team/a by bob: requested by operator"}, operator.actions)
+}
+
+func TestActionsAreDisabledWithoutTokens(t *testing.T) {
+	t.Parallel()
+	// given
+	operator := &stubOperator{}
+	handler, err := New(operator, Config{})
+	require.NoError(t, err)
+	request := httptest.NewRequest("POST", "/api/v1/apps/team/a/reset", nil)
+	request.Header.Set("Authorization", "Bearer ")
+	recorder := httptest.NewRecorder()
+	// when
+	handler.ServeHTTP(recorder, request)
+	// then
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Empty(t, operator.actions)
+}
+
+func TestActionsAreRejectedWhileFollowing(t *testing.T) {
+	t.Parallel()
+	// given
+	operator := &stubOperator{paused: true}
+	response := errorResponse{}
+	// when
+	status := serve(t, operator, "POST", "/api/v1/apps/team/a/reset", "secret", &response)
+	// then
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Empty(t, operator.actions)
+}
+
+func TestPenalizeReportsImmunityAsConflict(t *testing.T) {
+	t.Parallel()
+	// given
+	operator := &stubOperator{penalizeErr: score.ImmunityError{AppID: "/team/a"}}
+	response := errorResponse{}
+	// when
+	status := serve(t, operator, "POST", "/api/v1/apps/team/a/penalize", "secret", &response)
+	// then
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "app: /team/a has immunity", response.Error)
+}
+
+func TestNewRejectsMalformedTokens(t *testing.T) {
+	t.Parallel()
+	for _, tokens := range []string{"secret", "alice=", "=secret"} {
+		// when
+		_, err := New(&stubOperator{}, Config{Tokens: tokens})
+		// then
+		assert.Error(t, err, tokens)
+		assert.NotContains(t, err.Error(), "secret", "token is not leaked to logs")
+	}
+}
+
+func TestNewAcceptsNewlineSeparatedTokens(t *testing.T) {
+	t.Parallel()
+	// given
+	operator := &stubOperator{}
+	h, err := New(operator, Config{Tokens: "alice=secret\nbob=hunter2\n"})
+	require.NoError(t, err)
+	request := httptest.NewRequest("POST", "/api/v1/apps/team/a/reset", nil)
+	request.Header.Set("Authorization", "Bearer hunter2")
+	recorder := httptest.NewRecorder()
+	// when
+	h.ServeHTTP(recorder, request)
+	// then
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, []string{"reset /team/a by bob: requested by operator"}, operator.actions)
+}
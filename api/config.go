@@ -0,0 +1,8 @@
+package api
+
+// Config specific to api package
+type Config struct {
+	// Tokens is comma or newline separated list of caller=token pairs
+	// allowed to call write endpoints, empty disables them
+	Tokens string
+}
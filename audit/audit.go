@@ -0,0 +1,78 @@
+// Package audit records every action AppCop takes against Marathon
+// applications, so owners and operators can find out why application was
+// changed.
+package audit
+
+import (
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Actor used for actions taken by AppCop on its own
+const Actor = "appcop"
+
+// Entry describes single action
+type Entry struct {
+	// Action taken e.g. scaleDown, suspend, delete
+	Action string
+	// Target application or group id
+	Target string
+	// Actor who requested action
+	Actor string
+	// Reason why action was taken
+	Reason string
+	// DryRun is set when action was only simulated
+	DryRun bool
+	// Details contains action specific evidence
+	Details log.Fields
+	// Err is set when action failed
+	Err error
+}
+
+var logger = log.StandardLogger()
+
+// Init configures audit log destination
+func Init(cfg Config) error {
+	if cfg.File == "" {
+		logger = log.StandardLogger()
+		return nil
+	}
+
+	f, err := os.OpenFile(cfg.File, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.WithError(err).Errorf("error opening audit file: %s", cfg.File)
+		return err
+	}
+
+	logger = log.New()
+	logger.Out = f
+	logger.Formatter = &log.JSONFormatter{}
+	return nil
+}
+
+// Log records provided entry
+func Log(e Entry) {
+	actor := e.Actor
+	if actor == "" {
+		actor = Actor
+	}
+
+	fields := log.Fields{}
+	for k, v := range e.Details {
+		fields[k] = v
+	}
+	fields["audit"] = true
+	fields["action"] = e.Action
+	fields["target"] = e.Target
+	fields["actor"] = actor
+	fields["reason"] = e.Reason
+	fields["dryRun"] = e.DryRun
+
+	entry := logger.WithFields(fields)
+	if e.Err != nil {
+		entry.WithError(e.Err).Warn("Action failed")
+		return
+	}
+	entry.Info("Action taken")
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogAppendsJSONEntriesToConfiguredFile(t *testing.T) {
+	// given
+	dir, err := ioutil.TempDir("", "audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "audit.log")
+	require.NoError(t, Init(Config{File: file}))
+	defer Init(Config{})
+	// when
+	Log(Entry{
+		Action:  "suspend",
+		Target:  "/app",
+		Reason:  "stuck in launch queue",
+		Details: log.Fields{"reasons": map[string]int{"InsufficientCpus": 3}},
+	})
+	Log(Entry{Action: "delete", Target: "/other", Actor: "operator", Err: errors.New("boom")})
+	// then
+	content, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+
+	first := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "suspend", first["action"])
+	assert.Equal(t, "/app", first["target"])
+	assert.Equal(t, Actor, first["actor"])
+	assert.Equal(t, map[string]interface{}{"InsufficientCpus": float64(3)}, first["reasons"])
+
+	second := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, "operator", second["actor"])
+	assert.Equal(t, "boom", second["error"])
+}
+
+func TestInitReturnsErrorWhenFileCannotBeOpened(t *testing.T) {
+	// when
+	err := Init(Config{File: "/nonexistent/dir/audit.log"})
+	// then
+	assert.Error(t, err)
+}
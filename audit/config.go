@@ -0,0 +1,8 @@
+package audit
+
+// Config specific to audit package
+type Config struct {
+	// File where audit entries are appended as JSON lines, when empty
+	// entries are written to the main log.
+	File string
+}
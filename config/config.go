@@ -8,9 +8,15 @@ import (
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/agent"
+	"github.com/allegro/marathon-appcop/api"
+	"github.com/allegro/marathon-appcop/audit"
+	"github.com/allegro/marathon-appcop/leader"
 	"github.com/allegro/marathon-appcop/marathon"
 	"github.com/allegro/marathon-appcop/metrics"
 	"github.com/allegro/marathon-appcop/mgc"
+	"github.com/allegro/marathon-appcop/notify"
+	"github.com/allegro/marathon-appcop/queue"
 	"github.com/allegro/marathon-appcop/score"
 	"github.com/allegro/marathon-appcop/web"
 	flag "github.com/ogier/pflag"
@@ -22,6 +28,12 @@ type Config struct {
 	Marathon marathon.Config
 	Score    score.Config
 	MGC      mgc.Config
+	Queue    queue.Config
+	Agent    agent.Config
+	Leader   leader.Config
+	Audit    audit.Config
+	API      api.Config
+	Notify   notify.Config
 	Metrics  metrics.Config
 	Log      struct {
 		Level  string
@@ -29,6 +41,25 @@ type Config struct {
 		File   string
 	}
 	configFile string
+	// secretFiles hold secrets, which passed as flags would be visible in
+	// process list
+	secretFiles struct {
+		CallbackToken string
+		APITokens     string
+		NotifySecret  string
+	}
+}
+
+// deprecatedConfig holds options moved to other sections, they are still
+// accepted from config file
+type deprecatedConfig struct {
+	Web struct {
+		// MyLeader moved to Leader.MyLeader
+		MyLeader *string
+	}
+	Leader struct {
+		MyLeader *string
+	}
 }
 
 var config = &Config{}
@@ -46,6 +77,11 @@ func NewConfig() (*Config, error) {
 		return nil, err
 	}
 
+	err = config.readSecrets()
+	if err != nil {
+		return nil, err
+	}
+
 	err = config.setLogOutput()
 	if err != nil {
 		return nil, err
@@ -64,9 +100,64 @@ func (config *Config) parseFlags() {
 	// Web
 	flag.StringVar(&config.Web.Listen, "listen", ":4444", "Port to listen on, at this point only for health checking")
 	flag.StringVar(&config.Web.Location, "event-stream", "http://example.com:8080/v2/events", "Get events from this stream")
-	flag.IntVar(&config.Web.QueueSize, "events-queue-size", 1000, "Size of events queue")
+	flag.IntVar(&config.Web.QueueSize, "events-queue-size", 1000, "Size of events queue of every worker")
 	flag.IntVar(&config.Web.WorkersCount, "workers-pool-size", 10, "Number of concurrent workers processing events")
-	flag.StringVar(&config.Web.MyLeader, "my-leader", "example.com:8080", "My leader, when marathon /v2/leader endpoint return the same string as this one, make subscription to event stream")
+	flag.DurationVar(&config.Web.ReconnectBackoff, "events-reconnect-backoff", time.Second,
+		"Initial delay before resubscribing to broken event stream, doubled with every failed attempt")
+	flag.DurationVar(&config.Web.ReconnectMaxBackoff, "events-reconnect-max-backoff", time.Minute,
+		"Maximal delay before resubscribing to broken event stream, random jitter up to initial delay is added")
+	flag.BoolVar(&config.Web.FilterEvents, "events-filter", true,
+		"Subscribe only to event types AppCop handles (uses event_type parameter supported since Marathon 1.5)")
+	flag.DurationVar(&config.Web.StreamSilenceTimeout, "events-silence-timeout", 5*time.Minute,
+		"Resubscribe to event stream when nothing (including keepalive) was received for that long, 0 disables")
+	flag.StringVar(&config.Web.QueuePolicy, "events-queue-policy", "block",
+		"What happens when events queue is full: block, drop-oldest, drop-newest or drop-low-priority")
+	flag.DurationVar(&config.Web.QueueBlockTimeout, "events-queue-block-timeout", time.Second,
+		"How long block policy waits for free space in events queue before event is dropped, counted and logged")
+	flag.StringVar(&config.Web.EventSource, "events-source", "sse",
+		"Where events come from: sse (Marathon event stream), callback (Marathon http_callback POSTs), mesos (Mesos master operator API) or replay (files recorded with events-record-file)")
+	flag.StringVar(&config.Web.CallbackPath, "events-callback-path", web.DefaultCallbackPath,
+		"Path on listen address accepting Marathon http_callback events (used when events-source is set to callback)")
+	flag.StringVar(&config.Web.CallbackURL, "events-callback-url", "",
+		"URL of events-callback-path reachable from Marathon, when set AppCop registers it with /v2/eventSubscriptions")
+	flag.StringVar(&config.secretFiles.CallbackToken, "events-callback-token-file", "",
+		"File with shared secret Marathon has to send in token query parameter of callback events, it is added to events-callback-url (required when events-source is set to callback)")
+	flag.StringVar(&config.Web.MesosMaster, "events-mesos-master", "",
+		"Mesos master URL, e.g. http://mesos.example.com:5050, subscribed to operator API (used when events-source is set to mesos)")
+	flag.StringVar(&config.Web.MesosFramework, "events-mesos-framework", web.DefaultMesosFramework,
+		"Name of Marathon framework in Mesos, tasks of other frameworks are ignored (used when events-source is set to mesos)")
+	flag.StringVar(&config.Web.RecordFile, "events-record-file", "",
+		"Record every received event to this file as JSON lines. If empty events are not recorded")
+	flag.Int64Var(&config.Web.RecordMaxSize, "events-record-max-size", 100*1024*1024,
+		"Size in bytes after which events record file is rotated")
+	flag.IntVar(&config.Web.RecordMaxFiles, "events-record-max-files", 5,
+		"How many rotated events record files are kept")
+	flag.StringVar(&config.Web.ReplayFiles, "events-replay-files", "",
+		"Comma separated list of recorded files replayed in order (used when events-source is set to replay)")
+	flag.Float64Var(&config.Web.ReplaySpeed, "events-replay-speed", 1,
+		"Replay speed, 1 is real time, 2 is twice as fast, 0 replays events without pauses")
+	flag.StringVar(&config.Web.DrainFile, "events-drain-file", "",
+		"On shutdown persist events not processed before shutdown-timeout to this file (replayable like events-record-file). If empty such events are lost")
+	flag.DurationVar(&config.Web.ShutdownTimeout, "shutdown-timeout", 30*time.Second,
+		"How long AppCop waits for queued events and actions in progress on SIGTERM or SIGINT")
+
+	// Leader election
+	flag.StringVar(&config.Leader.Backend, "leader-backend", leader.BackendMarathon,
+		"How AppCop replicas elect leader: marathon (colocated Marathon leads), file (lock on leader-lock-file) or consul (lock on leader-consul-key)")
+	flag.DurationVar(&config.Leader.Interval, "leader-interval", 5*time.Second,
+		"How often leadership is checked or renewed")
+	flag.DurationVar(&config.Leader.TTL, "leader-ttl", 15*time.Second,
+		"Consul session TTL, other replica takes over that long after leader stopped renewing it (used when leader-backend is set to consul)")
+	flag.StringVar(&config.Leader.ID, "leader-id", "",
+		"Identifies replica in leader lease. If empty hostname is used")
+	flag.StringVar(&config.Leader.MyLeader, "my-leader", "example.com:8080",
+		"My leader, when marathon /v2/leader endpoint return the same string as this one, this replica leads (used when leader-backend is set to marathon)")
+	flag.StringVar(&config.Leader.LockFile, "leader-lock-file", "",
+		"File locked by leading replica (used when leader-backend is set to file)")
+	flag.StringVar(&config.Leader.ConsulAddress, "leader-consul-address", "http://localhost:8500",
+		"Consul HTTP API address (used when leader-backend is set to consul)")
+	flag.StringVar(&config.Leader.ConsulKey, "leader-consul-key", "service/appcop/leader",
+		"Consul key locked by leading replica (used when leader-backend is set to consul)")
 
 	// Marathon
 	flag.StringVar(&config.Marathon.Location,
@@ -104,6 +195,27 @@ func (config *Config) parseFlags() {
 	flag.DurationVar(&config.Score.EvaluateInterval,
 		"evaluate-interval", 2*time.Minute,
 		"Interval when apps are scored, after interval passes scores are reset.")
+	flag.StringVar(&config.Score.Enforcement,
+		"enforcement-mode", "scale",
+		"How penalty is applied: scale (lower instances) or kill-tasks (kill unhealthy or most often failing task).")
+
+	flag.IntVar(&config.Web.AppFaultWeight,
+		"weight-app-fault", 1,
+		"Score added to application when its task fails, finishes, is killed or cannot be launched (TASK_FAILED, TASK_FINISHED, TASK_KILLED, TASK_ERROR).")
+	flag.IntVar(&config.Web.InfraFaultWeight,
+		"weight-infra-fault", 1,
+		"Score added to agent health when task is lost because of infrastructure (TASK_LOST, TASK_DROPPED, TASK_GONE, TASK_UNREACHABLE or agent failure reason).")
+	flag.IntVar(&config.Web.OperatorWeight,
+		"weight-operator", 0,
+		"Score added to application when its task is removed by operator (TASK_GONE_BY_OPERATOR), 0 disables scoring.")
+
+	// Agents
+	flag.IntVar(&config.Agent.UnhealthyScore,
+		"agent-unhealthy-score", 10,
+		"Infrastructure faults score above which agent is reported unhealthy.")
+	flag.DurationVar(&config.Agent.ResetInterval,
+		"agent-reset-interval", 60*time.Minute,
+		"Agent faults score is forgotten when no new fault happened for that long.")
 
 	// Marathon GC
 	flag.BoolVar(&config.MGC.Enabled,
@@ -119,9 +231,48 @@ func (config *Config) parseFlags() {
 		"mgc-appcop-only", true,
 		"Delete only applications suspended by appcop.")
 
+	// Launch queue
+	flag.BoolVar(&config.Queue.Enabled,
+		"queue-enabled", false,
+		"Enable inspection of Marathon launch queue, apps waiting for matching offers are penalized.")
+	flag.DurationVar(&config.Queue.Interval,
+		"queue-interval", time.Minute,
+		"Launch queue inspection interval.")
+	flag.DurationVar(&config.Queue.MaxWaitTime,
+		"queue-max-wait-time", 30*time.Minute,
+		"How long application may be overdue or decline offers in launch queue before it is penalized.")
+	flag.StringVar(&config.Queue.Action,
+		"queue-action", "score",
+		"Action taken for application stuck in launch queue: score or suspend.")
+	flag.IntVar(&config.Queue.Penalty,
+		"queue-penalty", 50,
+		"Score added to application stuck in launch queue (used when queue-action is set to score).")
+
+	// Audit
+	flag.StringVar(&config.Audit.File, "audit-log-file", "",
+		"Append audit log of actions taken by AppCop to file as JSON lines. If empty entries are published to main log")
+
+	// API
+	flag.StringVar(&config.secretFiles.APITokens, "api-tokens-file", "",
+		"File with comma or newline separated caller=token pairs allowed to call write API endpoints with Authorization: Bearer <token> header, caller is recorded in audit log. If empty write endpoints are disabled")
+
+	// Notifications
+	flag.StringVar(&config.Notify.URL, "notify-url", "",
+		"URL receiving JSON POST before and after application is scaled down, suspended or deleted. If empty notifications are disabled")
+	flag.StringVar(&config.secretFiles.NotifySecret, "notify-secret-file", "",
+		"File with secret signing notifications with HMAC-SHA256 in X-AppCop-Signature header. If empty notifications are not signed")
+	flag.DurationVar(&config.Notify.Timeout, "notify-timeout", 5*time.Second,
+		"Timeout of single notification delivery")
+	flag.IntVar(&config.Notify.QueueSize, "notify-queue-size", 100,
+		"How many notifications may wait for delivery and for retry, above that they are dead-lettered to log")
+	flag.IntVar(&config.Notify.Retries, "notify-retries", 3,
+		"How many times failed notification is retried before it is dead-lettered to log")
+	flag.DurationVar(&config.Notify.RetryBackoff, "notify-retry-backoff", time.Second,
+		"Delay before first retry of failed notification, doubled with every attempt")
+
 	// Metrics
 	flag.StringVar(&config.Metrics.Target, "metrics-target", "stdout",
-		"Metrics destination stdout or graphite (empty string disables metrics)")
+		"Metrics destination stdout, graphite, statsd, influx or prometheus (empty string disables metrics)")
 	flag.StringVar(&config.Metrics.Prefix, "metrics-prefix", "default",
 		"Metrics prefix (default is resolved to <hostname>.<app_name>")
 	flag.StringVar(&config.Metrics.SystemSubPrefix, "metrics-system-sub-prefix", "appcop-internal",
@@ -130,8 +281,14 @@ func (config *Config) parseFlags() {
 		"Applications specific metrics. Appended to metric-prefix")
 	flag.DurationVar(&config.Metrics.Interval, "metrics-interval", 30*time.Second,
 		"Metrics reporting interval")
+	flag.DurationVar(&config.Metrics.AppMetricsTTL, "metrics-app-ttl", 24*time.Hour,
+		"Applications metric not updated for that long is removed, so deleted applications are not reported forever (0 keeps metrics forever)")
+	flag.IntVar(&config.Metrics.AppMetricsLimit, "metrics-app-limit", 10000,
+		"Maximal number of applications metrics, events of applications above limit are counted by overflow metric (0 means no limit)")
 	flag.StringVar(&config.Metrics.Addr, "metrics-location", "",
-		"Graphite URL (used when metrics-target is set to graphite)")
+		"Graphite or StatsD address, e.g. localhost:8125, or InfluxDB http://localhost:8086/write?db=appcop or udp://localhost:8089 URL (used when metrics-target is set to graphite, statsd or influx)")
+	flag.BoolVar(&config.Metrics.DogStatsD, "metrics-dogstatsd", false,
+		"Send DogStatsD tags, application id and task status become tags instead of metric name segments (used when metrics-target is set to statsd)")
 	flag.StringVar(&config.Metrics.Addr, "metrics-instance", "",
 		"Part of Graphite metric, used to distinguish between AppCop instances internal metrics.")
 
@@ -156,7 +313,53 @@ func (config *Config) loadConfigFromFile() error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(jsonBlob, config)
+	err = json.Unmarshal(jsonBlob, config)
+	if err != nil {
+		return err
+	}
+	return config.applyDeprecated(jsonBlob)
+}
+
+// applyDeprecated copies options from their old place in config file, unless
+// file sets them in the new place too
+func (config *Config) applyDeprecated(jsonBlob []byte) error {
+	deprecated := deprecatedConfig{}
+	err := json.Unmarshal(jsonBlob, &deprecated)
+	if err != nil {
+		return err
+	}
+	if deprecated.Web.MyLeader != nil {
+		if deprecated.Leader.MyLeader != nil {
+			log.Warn("Config file option Web.MyLeader is deprecated and ignored, Leader.MyLeader is used")
+			return nil
+		}
+		log.Warn("Config file option Web.MyLeader is deprecated, use Leader.MyLeader")
+		config.Leader.MyLeader = *deprecated.Web.MyLeader
+	}
+	return nil
+}
+
+// readSecrets reads secrets from files, surrounding whitespace is trimmed
+func (config *Config) readSecrets() error {
+	secrets := []struct {
+		file  string
+		value *string
+	}{
+		{config.secretFiles.CallbackToken, &config.Web.CallbackToken},
+		{config.secretFiles.APITokens, &config.API.Tokens},
+		{config.secretFiles.NotifySecret, &config.Notify.Secret},
+	}
+	for _, secret := range secrets {
+		if secret.file == "" {
+			continue
+		}
+		content, err := ioutil.ReadFile(secret.file)
+		if err != nil {
+			return err
+		}
+		*secret.value = strings.TrimSpace(string(content))
+	}
+	return nil
 }
 
 func (config *Config) setLogLevel() error {
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"io/ioutil"
 	"os"
 	"reflect"
 	"testing"
@@ -10,6 +11,7 @@ import (
 	"github.com/allegro/marathon-appcop/metrics"
 	"github.com/allegro/marathon-appcop/score"
 	"github.com/allegro/marathon-appcop/web"
+	flag "github.com/ogier/pflag"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -123,6 +125,113 @@ func TestConfig_ShouldBeMergedWithFileDefaultsAndFlags(t *testing.T) {
 	assert.Equal(t, expected, actual)
 }
 
+func TestConfig_ShouldReadLeaderFromFile(t *testing.T) {
+	clear()
+
+	// given
+	os.Args = []string{"./appcop", "--config-file=testdata/leader_config.json"}
+
+	// when
+	actual, err := NewConfig()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "marathon", actual.Leader.Backend)
+	assert.Equal(t, "leader.example.com:8080", actual.Leader.MyLeader)
+}
+
+func TestConfig_ShouldAcceptDeprecatedMyLeaderInWebSection(t *testing.T) {
+	for _, testCase := range []struct {
+		content  string
+		expected string
+	}{
+		{`{"Web": {"MyLeader": "old.example.com:8080"}, "Log": {"Level": "info"}}`, "old.example.com:8080"},
+		{`{"Web": {"MyLeader": "old.example.com:8080"}, "Leader": {"MyLeader": "new.example.com:8080"}, "Log": {"Level": "info"}}`, "new.example.com:8080"},
+	} {
+		clear()
+
+		// given
+		file, err := ioutil.TempFile("", "config")
+		assert.NoError(t, err)
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(testCase.content)
+		assert.NoError(t, err)
+		os.Args = []string{"./appcop", "--config-file=" + file.Name()}
+
+		// when
+		actual, err := NewConfig()
+
+		// then
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, actual.Leader.MyLeader)
+	}
+}
+
+func TestConfig_ShouldReadSecretsFromFiles(t *testing.T) {
+	clear()
+
+	// given
+	file, err := ioutil.TempFile("", "token")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("s3cret\n")
+	assert.NoError(t, err)
+	os.Args = []string{"./appcop", "--log-level=info", "--events-callback-token-file=" + file.Name(),
+		"--api-tokens-file=" + file.Name(), "--notify-secret-file=" + file.Name()}
+
+	// when
+	actual, err := NewConfig()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", actual.Web.CallbackToken)
+	assert.Equal(t, "s3cret", actual.API.Tokens)
+	assert.Equal(t, "s3cret", actual.Notify.Secret)
+}
+
+func TestConfig_ShouldParseWeights(t *testing.T) {
+	clear()
+
+	// given
+	os.Args = []string{"./appcop", "--log-level=info", "--weight-app-fault=3", "--weight-infra-fault=2", "--weight-operator=1"}
+
+	// when
+	actual, err := NewConfig()
+
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 3, actual.Web.AppFaultWeight)
+	assert.Equal(t, 2, actual.Web.InfraFaultWeight)
+	assert.Equal(t, 1, actual.Web.OperatorWeight)
+}
+
+func TestConfig_ShouldNotScoreOperatorRemovalsByDefault(t *testing.T) {
+	clear()
+
+	// given
+	os.Args = []string{"./appcop", "--log-level=info"}
+	_, err := NewConfig()
+	assert.NoError(t, err)
+
+	// expect
+	assert.Equal(t, "1", flag.Lookup("weight-app-fault").DefValue)
+	assert.Equal(t, "1", flag.Lookup("weight-infra-fault").DefValue)
+	assert.Equal(t, "0", flag.Lookup("weight-operator").DefValue)
+}
+
+func TestConfig_ShouldReturnErrorWhenSecretFileNotExist(t *testing.T) {
+	clear()
+
+	// given
+	os.Args = []string{"./appcop", "--events-callback-token-file=unknown"}
+
+	// when
+	_, err := NewConfig()
+
+	// then
+	assert.Error(t, err)
+}
+
 // http://stackoverflow.com/a/29169727/1387612
 func clear() {
 	p := reflect.ValueOf(config).Elem()
@@ -0,0 +1,25 @@
+package leader
+
+import "time"
+
+// Config specific to leader package
+type Config struct {
+	// Backend is BackendMarathon, BackendFile or BackendConsul
+	Backend string
+	// Interval between campaigns, leadership changes are noticed with this
+	// delay
+	Interval time.Duration
+	// TTL of consul session, leadership is taken over by other replica
+	// that long after this one stopped renewing it
+	TTL time.Duration
+	// ID identifies this replica in lease, hostname when empty
+	ID string
+	// MyLeader is address of Marathon colocated with this replica, which
+	// leads when /v2/leader returns it
+	MyLeader string
+	// LockFile is locked by leading replica
+	LockFile string
+	// ConsulAddress and ConsulKey point to key holding leader lease
+	ConsulAddress string
+	ConsulKey     string
+}
@@ -0,0 +1,149 @@
+package leader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const consulTimeout = 5 * time.Second
+
+// consulElector leads while holding lock on Consul key. Lock is bound to
+// session with TTL renewed on every campaign, when leader stops renewing
+// Consul invalidates session and releases lock for other replica.
+type consulElector struct {
+	mutex   sync.Mutex
+	address string
+	key     string
+	id      string
+	ttl     time.Duration
+	client  *http.Client
+	// session is empty until created or after Consul invalidated it
+	session string
+}
+
+func newConsulElector(config Config) *consulElector {
+	return &consulElector{
+		address: strings.TrimSuffix(config.ConsulAddress, "/"),
+		key:     strings.Trim(config.ConsulKey, "/"),
+		id:      config.ID,
+		ttl:     config.TTL,
+		client:  &http.Client{Timeout: consulTimeout},
+	}
+}
+
+func (e *consulElector) Campaign(ctx context.Context) (bool, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.session != "" {
+		renewed, err := e.renew(ctx)
+		if err != nil {
+			return false, err
+		}
+		if !renewed {
+			log.WithField("Session", e.session).Warn("Consul session invalidated")
+			e.session = ""
+		}
+	}
+	if e.session == "" {
+		if err := e.createSession(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	acquired := false
+	err := e.put(ctx, "/v1/kv/"+e.key+"?acquire="+url.QueryEscape(e.session), []byte(e.id), &acquired)
+	return acquired, err
+}
+
+func (e *consulElector) Resign(ctx context.Context) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.session == "" {
+		return nil
+	}
+	released := false
+	if err := e.put(ctx, "/v1/kv/"+e.key+"?release="+url.QueryEscape(e.session), nil, &released); err != nil {
+		return err
+	}
+	err := e.put(ctx, "/v1/session/destroy/"+url.PathEscape(e.session), nil, nil)
+	e.session = ""
+	return err
+}
+
+func (e *consulElector) createSession(ctx context.Context) error {
+	body, err := json.Marshal(map[string]string{
+		"Name": "appcop-" + e.id,
+		"TTL":  e.ttl.String(),
+		// lock is released, not deleted, when session is invalidated
+		"Behavior": "release",
+	})
+	if err != nil {
+		return err
+	}
+	session := struct {
+		ID string `json:"ID"`
+	}{}
+	if err := e.put(ctx, "/v1/session/create", body, &session); err != nil {
+		return err
+	}
+	if session.ID == "" {
+		return fmt.Errorf("consul returned no session id")
+	}
+	e.session = session.ID
+	return nil
+}
+
+// renew returns false when Consul does not know session anymore
+func (e *consulElector) renew(ctx context.Context) (bool, error) {
+	err := e.put(ctx, "/v1/session/renew/"+url.PathEscape(e.session), nil, nil)
+	if statusErr, ok := err.(consulStatusError); ok && statusErr.status == http.StatusNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type consulStatusError struct {
+	status int
+	path   string
+}
+
+func (e consulStatusError) Error() string {
+	return fmt.Sprintf("consul %s returned %d", e.path, e.status)
+}
+
+// put sends PUT request to Consul and decodes response to result, unless it
+// is nil
+func (e *consulElector) put(ctx context.Context, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequest("PUT", e.address+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		return consulStatusError{status: res.StatusCode, path: strings.SplitN(path, "?", 2)[0]}
+	}
+	if result == nil {
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
@@ -0,0 +1,207 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConsul implements session and KV lock endpoints used by consulElector
+type fakeConsul struct {
+	mutex    sync.Mutex
+	sessions map[string]bool
+	// holders maps key to session holding lock and values to stored value
+	holders map[string]string
+	values  map[string]string
+	next    int
+}
+
+func newFakeConsul() (*fakeConsul, *httptest.Server) {
+	consul := &fakeConsul{
+		sessions: make(map[string]bool),
+		holders:  make(map[string]string),
+		values:   make(map[string]string),
+	}
+	return consul, httptest.NewServer(consul)
+}
+
+func (c *fakeConsul) value(key string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.values[key]
+}
+
+func (c *fakeConsul) holder(key string) string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.holders[key]
+}
+
+func (c *fakeConsul) sessionsCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.sessions)
+}
+
+// invalidate simulates session TTL expiry
+func (c *fakeConsul) invalidate(session string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.release(session)
+	delete(c.sessions, session)
+}
+
+// release must be called with mutex held
+func (c *fakeConsul) release(session string) {
+	for key, holder := range c.holders {
+		if holder == session {
+			delete(c.holders, key)
+		}
+	}
+}
+
+func (c *fakeConsul) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if r.Method != "PUT" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+
+	switch path := r.URL.Path; {
+	case path == "/v1/session/create":
+		c.next++
+		id := fmt.Sprintf("session-%d", c.next)
+		c.sessions[id] = true
+		fmt.Fprintf(w, `{"ID": %q}`, id)
+	case strings.HasPrefix(path, "/v1/session/renew/"):
+		id := strings.TrimPrefix(path, "/v1/session/renew/")
+		if !c.sessions[id] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `[{"ID": %q}]`, id)
+	case strings.HasPrefix(path, "/v1/session/destroy/"):
+		id := strings.TrimPrefix(path, "/v1/session/destroy/")
+		c.release(id)
+		delete(c.sessions, id)
+		fmt.Fprint(w, "true")
+	case strings.HasPrefix(path, "/v1/kv/"):
+		key := strings.TrimPrefix(path, "/v1/kv/")
+		if session := r.URL.Query().Get("acquire"); session != "" {
+			if !c.sessions[session] {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			holder, held := c.holders[key]
+			acquired := !held || holder == session
+			if acquired {
+				c.holders[key] = session
+				c.values[key] = string(body)
+			}
+			_ = json.NewEncoder(w).Encode(acquired)
+			return
+		}
+		if session := r.URL.Query().Get("release"); session != "" {
+			released := c.holders[key] == session
+			if released {
+				delete(c.holders, key)
+			}
+			_ = json.NewEncoder(w).Encode(released)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestConsulElector(t *testing.T, address, id string) Elector {
+	elector, err := New(Config{
+		Backend:       BackendConsul,
+		ID:            id,
+		Interval:      time.Second,
+		TTL:           10 * time.Second,
+		ConsulAddress: address,
+		ConsulKey:     "service/appcop/leader",
+	}, nil)
+	require.NoError(t, err)
+	return elector
+}
+
+func TestConsulElectorLetsOnlyOneReplicaLeadAndHandsOverOnResign(t *testing.T) {
+	t.Parallel()
+	// given
+	consul, server := newFakeConsul()
+	defer server.Close()
+	first := newTestConsulElector(t, server.URL, "first")
+	second := newTestConsulElector(t, server.URL, "second")
+	ctx := context.Background()
+
+	// when
+	firstLeads, firstErr := first.Campaign(ctx)
+	secondLeads, secondErr := second.Campaign(ctx)
+	// then
+	require.NoError(t, firstErr)
+	require.NoError(t, secondErr)
+	assert.True(t, firstLeads)
+	assert.False(t, secondLeads)
+	assert.Equal(t, "first", consul.value("service/appcop/leader"))
+
+	// when
+	firstLeads, firstErr = first.Campaign(ctx)
+	// then
+	require.NoError(t, firstErr)
+	assert.True(t, firstLeads, "leader renews its lease")
+
+	// when
+	require.NoError(t, first.Resign(ctx))
+	secondLeads, secondErr = second.Campaign(ctx)
+	// then
+	require.NoError(t, secondErr)
+	assert.True(t, secondLeads)
+	assert.Equal(t, 1, consul.sessionsCount())
+}
+
+func TestConsulElectorRecreatesInvalidatedSession(t *testing.T) {
+	t.Parallel()
+	// given
+	consul, server := newFakeConsul()
+	defer server.Close()
+	elector := newTestConsulElector(t, server.URL, "first")
+	ctx := context.Background()
+	leads, err := elector.Campaign(ctx)
+	require.NoError(t, err)
+	require.True(t, leads)
+	// when
+	consul.invalidate("session-1")
+	leads, err = elector.Campaign(ctx)
+	// then
+	require.NoError(t, err)
+	assert.True(t, leads)
+	assert.Equal(t, "session-2", consul.holder("service/appcop/leader"))
+}
+
+func TestConsulElectorReturnsErrorWhenConsulIsDown(t *testing.T) {
+	t.Parallel()
+	// given
+	_, server := newFakeConsul()
+	elector := newTestConsulElector(t, server.URL, "first")
+	server.Close()
+	// when
+	leads, err := elector.Campaign(context.Background())
+	// then
+	assert.Error(t, err)
+	assert.False(t, leads)
+}
@@ -0,0 +1,62 @@
+package leader
+
+import (
+	"context"
+	"os"
+	"sync"
+)
+
+// fileElector leads while holding exclusive lock on file. Lock is released
+// by operating system when process dies, so no lease renewal is needed.
+type fileElector struct {
+	mutex sync.Mutex
+	path  string
+	id    string
+	// file is open while lock is held
+	file *os.File
+}
+
+func (e *fileElector) Campaign(_ context.Context) (bool, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, err
+	}
+	locked, err := tryLock(file)
+	if err != nil || !locked {
+		file.Close()
+		return false, err
+	}
+	// lock file tells which replica leads
+	if err := file.Truncate(0); err != nil {
+		unlock(file)
+		file.Close()
+		return false, err
+	}
+	if _, err := file.WriteAt([]byte(e.id+"\n"), 0); err != nil {
+		unlock(file)
+		file.Close()
+		return false, err
+	}
+	e.file = file
+	return true, nil
+}
+
+func (e *fileElector) Resign(_ context.Context) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.file == nil {
+		return nil
+	}
+	err := unlock(e.file)
+	if closeErr := e.file.Close(); err == nil {
+		err = closeErr
+	}
+	e.file = nil
+	return err
+}
@@ -0,0 +1,46 @@
+package leader
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileElectorLetsOnlyOneReplicaLead(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, err := ioutil.TempDir("", "leader")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "appcop.lock")
+	first, err := New(Config{Backend: BackendFile, LockFile: path, ID: "first"}, nil)
+	require.NoError(t, err)
+	second, err := New(Config{Backend: BackendFile, LockFile: path, ID: "second"}, nil)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// when
+	firstLeads, firstErr := first.Campaign(ctx)
+	secondLeads, secondErr := second.Campaign(ctx)
+	// then
+	require.NoError(t, firstErr)
+	require.NoError(t, secondErr)
+	assert.True(t, firstLeads)
+	assert.False(t, secondLeads)
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", string(content))
+
+	// when
+	require.NoError(t, first.Resign(ctx))
+	secondLeads, secondErr = second.Campaign(ctx)
+	// then
+	require.NoError(t, secondErr)
+	assert.True(t, secondLeads)
+	require.NoError(t, second.Resign(ctx))
+}
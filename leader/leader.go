@@ -0,0 +1,204 @@
+// Package leader elects single acting AppCop among replicas. Only leader
+// subscribes to events and takes actions against Marathon, other replicas
+// wait to take over.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+// Election backends
+const (
+	// BackendMarathon leads when colocated Marathon is Marathon leader
+	BackendMarathon = "marathon"
+	// BackendFile leads while holding lock on file, for replicas sharing
+	// single host
+	BackendFile = "file"
+	// BackendConsul leads while holding lock on Consul key with session
+	// lease
+	BackendConsul = "consul"
+)
+
+const (
+	defaultInterval = 5 * time.Second
+	resignTimeout   = 5 * time.Second
+)
+
+// Elector is leader election backend
+type Elector interface {
+	// Campaign acquires or renews leadership, returns true when this
+	// replica leads until next campaign
+	Campaign(ctx context.Context) (bool, error)
+	// Resign gives leadership up, so other replica can take over without
+	// waiting for lease to expire
+	Resign(ctx context.Context) error
+}
+
+// New creates Elector for configured backend
+func New(config Config, m marathon.Marathoner) (Elector, error) {
+	if config.ID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		config.ID = hostname
+	}
+
+	switch config.Backend {
+	case "", BackendMarathon:
+		return &marathonElector{marathon: m, myLeader: config.MyLeader}, nil
+	case BackendFile:
+		if config.LockFile == "" {
+			return nil, errors.New("no lock file for leader election")
+		}
+		return &fileElector{path: config.LockFile, id: config.ID}, nil
+	case BackendConsul:
+		if config.TTL <= config.Interval {
+			return nil, errors.New("leader TTL should be longer than interval")
+		}
+		return newConsulElector(config), nil
+	default:
+		return nil, fmt.Errorf("unknown leader election backend %q", config.Backend)
+	}
+}
+
+// Monitor campaigns periodically and reports leadership changes
+type Monitor struct {
+	elector  Elector
+	interval time.Duration
+	// leading is 1 when this replica leads, accessed atomically
+	leading int32
+	mutex   sync.Mutex
+	// since is time of last leadership change
+	since     time.Time
+	listeners []func(leading bool)
+}
+
+// NewMonitor creates Monitor campaigning with provided interval
+func NewMonitor(elector Elector, interval time.Duration) *Monitor {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return &Monitor{elector: elector, interval: interval}
+}
+
+// Leading tells whether this replica leads
+func (m *Monitor) Leading() bool {
+	return atomic.LoadInt32(&m.leading) == 1
+}
+
+// Since returns time of last leadership change, zero when leadership was
+// never acquired
+func (m *Monitor) Since() time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.since
+}
+
+// Notify registers listener called with new state whenever leadership is
+// acquired or lost. Listeners are called in order of registration.
+func (m *Monitor) Notify(listener func(leading bool)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.listeners = append(m.listeners, listener)
+}
+
+// Pausable is job which should act only while this replica leads
+type Pausable interface {
+	Pause()
+	Resume()
+}
+
+// PauseWhileFollowing pauses job now and registers listener resuming it when
+// leadership is acquired and pausing it again when leadership is lost
+func (m *Monitor) PauseWhileFollowing(job Pausable) {
+	job.Pause()
+	m.Notify(func(leading bool) {
+		if leading {
+			job.Resume()
+		} else {
+			job.Pause()
+		}
+	})
+}
+
+// Run campaigns until ctx is done, notifying listeners about leadership
+// changes. Failed campaign means loss of leadership, acting on stale state
+// could make two replicas act at once. Leadership is resigned when ctx is
+// done.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		leading, err := m.elector.Campaign(ctx)
+		if err != nil && ctx.Err() == nil {
+			metrics.Mark("leader.errors")
+			log.WithError(err).Error("Leader election failed")
+		}
+		if ctx.Err() != nil {
+			m.resign()
+			return
+		}
+		m.set(leading && err == nil)
+
+		select {
+		case <-ctx.Done():
+			m.resign()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) set(leading bool) {
+	metrics.UpdateGauge("leader", boolToInt(leading))
+	if leading == m.Leading() {
+		return
+	}
+	atomic.StoreInt32(&m.leading, int32(boolToInt(leading)))
+	if leading {
+		metrics.Mark("leader.acquired")
+		log.Info("Leadership acquired")
+	} else {
+		metrics.Mark("leader.lost")
+		log.Warn("Leadership lost")
+	}
+
+	m.mutex.Lock()
+	m.since = time.Now()
+	listeners := m.listeners
+	m.mutex.Unlock()
+	for _, listener := range listeners {
+		listener(leading)
+	}
+}
+
+func (m *Monitor) resign() {
+	if !m.Leading() {
+		return
+	}
+	m.set(false)
+	// ctx is already done
+	ctx, cancel := context.WithTimeout(context.Background(), resignTimeout)
+	defer cancel()
+	if err := m.elector.Resign(ctx); err != nil {
+		log.WithError(err).Error("Unable to resign leadership")
+	}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,157 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var newTestCases = []struct {
+	config        Config
+	expectedError bool
+}{
+	{Config{Backend: BackendMarathon}, false},
+	{Config{}, false},
+	{Config{Backend: BackendFile}, true},
+	{Config{Backend: BackendFile, LockFile: "/tmp/appcop.lock"}, false},
+	{Config{Backend: BackendConsul, Interval: time.Second, TTL: time.Second}, true},
+	{Config{Backend: BackendConsul, Interval: time.Second, TTL: 10 * time.Second}, false},
+	{Config{Backend: "zookeeper"}, true},
+}
+
+func TestNewTestCases(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range newTestCases {
+		// when
+		_, err := New(testCase.config, marathon.MStub{})
+		// then
+		assert.Equal(t, testCase.expectedError, err != nil, testCase.config.Backend)
+	}
+}
+
+func TestMarathonElectorLeadsWhenMarathonReportsMyLeader(t *testing.T) {
+	t.Parallel()
+	// given
+	elector, err := New(Config{MyLeader: "marathon-1:8080"}, marathon.MStub{Leader: "marathon-1:8080"})
+	require.NoError(t, err)
+	follower, err := New(Config{MyLeader: "marathon-2:8080"}, marathon.MStub{Leader: "marathon-1:8080"})
+	require.NoError(t, err)
+	// when
+	leading, leadingErr := elector.Campaign(context.Background())
+	following, followingErr := follower.Campaign(context.Background())
+	// then
+	assert.NoError(t, leadingErr)
+	assert.NoError(t, followingErr)
+	assert.True(t, leading)
+	assert.False(t, following)
+}
+
+// scriptedElector returns results in order, repeating last one
+type scriptedElector struct {
+	mutex    sync.Mutex
+	results  []error
+	resigned bool
+}
+
+var errNotLeader = errors.New("not leader")
+
+func (e *scriptedElector) Campaign(_ context.Context) (bool, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	result := e.results[0]
+	if len(e.results) > 1 {
+		e.results = e.results[1:]
+	}
+	if result == errNotLeader {
+		return false, nil
+	}
+	return result == nil, result
+}
+
+func (e *scriptedElector) Resign(_ context.Context) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.resigned = true
+	return nil
+}
+
+func TestMonitorReportsLeadershipChangesAndResignsWhenDone(t *testing.T) {
+	t.Parallel()
+	// given
+	elector := &scriptedElector{results: []error{errNotLeader, nil, nil, errors.New("timeout"), nil}}
+	monitor := NewMonitor(elector, time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := make(chan bool, 10)
+	// when
+	done := make(chan struct{}, 1)
+	monitor.Notify(func(leading bool) { changes <- leading })
+	go func() {
+		monitor.Run(ctx)
+		done <- struct{}{}
+	}()
+	// then
+	assert.True(t, <-changes)
+	assert.False(t, <-changes, "error means loss of leadership")
+	assert.True(t, <-changes)
+	assert.True(t, monitor.Leading())
+	assert.False(t, monitor.Since().IsZero())
+	// when
+	cancel()
+	<-done
+	// then
+	assert.False(t, <-changes)
+	assert.False(t, monitor.Leading())
+	elector.mutex.Lock()
+	defer elector.mutex.Unlock()
+	assert.True(t, elector.resigned)
+}
+
+type pausableJob struct {
+	mutex  sync.Mutex
+	paused bool
+}
+
+func (j *pausableJob) Pause() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.paused = true
+}
+
+func (j *pausableJob) Resume() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.paused = false
+}
+
+func (j *pausableJob) isPaused() bool {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.paused
+}
+
+func TestPauseWhileFollowingPausesJobUntilLeadershipIsAcquired(t *testing.T) {
+	t.Parallel()
+	// given
+	elector := &scriptedElector{results: []error{errNotLeader, nil, errNotLeader}}
+	monitor := NewMonitor(elector, time.Millisecond)
+	job := &pausableJob{}
+	changes := make(chan bool, 10)
+	// when
+	monitor.PauseWhileFollowing(job)
+	monitor.Notify(func(leading bool) { changes <- job.isPaused() })
+	// then
+	assert.True(t, job.isPaused())
+	// when
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go monitor.Run(ctx)
+	// then
+	assert.False(t, <-changes, "resumed when leading")
+	assert.True(t, <-changes, "paused when leadership is lost")
+}
@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package leader
+
+import (
+	"errors"
+	"os"
+)
+
+var errLockNotSupported = errors.New("file lock leader election is not supported on this platform")
+
+func tryLock(_ *os.File) (bool, error) {
+	return false, errLockNotSupported
+}
+
+func unlock(_ *os.File) error {
+	return errLockNotSupported
+}
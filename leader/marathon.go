@@ -0,0 +1,27 @@
+package leader
+
+import (
+	"context"
+
+	"github.com/allegro/marathon-appcop/marathon"
+)
+
+// marathonElector leads when Marathon reports configured address as its
+// leader, so AppCop replicas have to run next to Marathon instances
+type marathonElector struct {
+	marathon marathon.Marathoner
+	myLeader string
+}
+
+func (e *marathonElector) Campaign(ctx context.Context) (bool, error) {
+	leader, err := e.marathon.LeaderGet(ctx)
+	if err != nil {
+		return false, err
+	}
+	return leader == e.myLeader, nil
+}
+
+// Resign is noop, Marathon leadership is not controlled by AppCop
+func (e *marathonElector) Resign(_ context.Context) error {
+	return nil
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/agent"
+	"github.com/allegro/marathon-appcop/api"
+	"github.com/allegro/marathon-appcop/audit"
 	"github.com/allegro/marathon-appcop/config"
+	"github.com/allegro/marathon-appcop/leader"
 	"github.com/allegro/marathon-appcop/marathon"
 	"github.com/allegro/marathon-appcop/metrics"
 	"github.com/allegro/marathon-appcop/mgc"
+	"github.com/allegro/marathon-appcop/notify"
+	"github.com/allegro/marathon-appcop/queue"
 	"github.com/allegro/marathon-appcop/score"
 	"github.com/allegro/marathon-appcop/web"
 )
@@ -18,6 +28,12 @@ var Version string
 func main() {
 
 	log.Infof("Appcop Version: %s", Version)
+
+	// registered before any component starts, so signal received during
+	// startup is not lost and still triggers graceful shutdown
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
 	config, err := config.NewConfig()
 	if err != nil {
 		log.Fatal(err.Error())
@@ -28,28 +44,127 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
+	err = audit.Init(config.Audit)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	remote, err := marathon.New(config.Marathon)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
+	notifier, err := notify.New(config.Notify)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	scores, err := score.New(config.Score, remote)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	updates := scores.ScoreManager()
+	scores.NotifyWith(notifier)
+	operator, err := api.New(scores, config.API)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifier.Start(ctx)
+
+	updates := scores.ScoreManager(ctx)
 
 	gc, err := mgc.New(config.MGC, remote)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
-	stop := web.NewHandler(config.Web, remote, gc, updates)
-	defer stop()
+	gc.NotifyWith(notifier)
+	gc.CheckImmunityWith(scores)
+
+	// dry-run applies to every action taken against marathon
+	config.Queue.DryRun = config.Score.DryRun
+	inspector, err := queue.New(config.Queue, remote, updates)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	inspector.NotifyWith(notifier)
+	inspector.CheckImmunityWith(scores)
+	agents, err := agent.New(config.Agent)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	elector, err := leader.New(config.Leader, remote)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	leadership := leader.NewMonitor(elector, config.Leader.Interval)
+	leadership.PauseWhileFollowing(scores)
+	stop, err := web.NewHandler(ctx, config.Web, remote, gc, inspector, agents, leadership, updates)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 
 	// set up routes
 	http.HandleFunc("/health", web.HealthHandler)
+	http.Handle("/leader", web.LeaderHandler(leadership))
+	http.Handle(metrics.PrometheusPath, metrics.PrometheusHandler())
+	http.Handle(api.Prefix, operator)
+
+	server := &http.Server{Addr: config.Web.Listen}
+	go func() {
+		log.WithField("Port", config.Web.Listen).Info("Listening")
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 
-	log.WithField("Port", config.Web.Listen).Info("Listening")
-	log.Fatal(http.ListenAndServe(config.Web.Listen, nil))
+	log.WithField("Signal", <-signals).Info("Shutting down")
 
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), config.Web.ShutdownTimeout)
+	defer shutdownCancel()
+
+	// stop receiving events and process (or persist) queued ones, scorer
+	// is still running so workers can update scores
+	stop(shutdownCtx)
+
+	// stop scheduling new actions and let ones in progress finish
+	inspector.Stop()
+	gc.Stop()
+	scores.Stop()
+	if !wait(shutdownCtx, inspector.Wait, gc.Wait, scores.Wait) {
+		log.Warn("Actions in progress not finished before deadline, aborting them")
+	}
+	// actions notify when they finish, so notifier is stopped after them
+	notifier.Stop()
+	if !wait(shutdownCtx, notifier.Wait) {
+		log.Warn("Notifications not delivered before deadline, dropping them")
+	}
+	cancel()
+
+	// earlier steps may have used up their deadline, so server gets its own
+	serverCtx, serverCancel := context.WithTimeout(context.Background(), config.Web.ShutdownTimeout)
+	defer serverCancel()
+	if err := server.Shutdown(serverCtx); err != nil {
+		log.WithError(err).Error("Unable to shut down HTTP server")
+	}
+	log.Info("Stopped")
+}
+
+// wait calls all provided functions and returns true when they finished
+// before ctx is done
+func wait(ctx context.Context, waits ...func()) bool {
+	finished := make(chan struct{})
+	go func() {
+		for _, w := range waits {
+			w()
+		}
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
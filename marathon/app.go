@@ -26,6 +26,7 @@ type App struct {
 	ID          AppID             `json:"id"`
 	Tasks       []Task            `json:"tasks"`
 	Instances   int               `json:"instances"`
+	Version     string            `json:"version"`
 	VersionInfo VersionInfo       `json:"versionInfo"`
 }
 
@@ -37,6 +38,34 @@ func (app App) HasImmunity() bool {
 	return false
 }
 
+// Immunity tells if application is excused from penalties, suspension and
+// garbage collection
+type Immunity interface {
+	Immune(app *App) bool
+}
+
+// LabelImmunity excuses only applications with APP_IMMUNITY label
+type LabelImmunity struct{}
+
+// Immune check if application has APP_IMMUNITY label
+func (LabelImmunity) Immune(app *App) bool {
+	return app.HasImmunity()
+}
+
+// suspend scales application to zero instances and marks it as suspended
+// by appcop
+func (app *App) suspend() error {
+	if app.Instances == 0 {
+		return fmt.Errorf("unable to suspend, zero instance")
+	}
+	if app.Labels == nil {
+		app.Labels = make(map[string]string)
+	}
+	app.Instances = 0
+	app.Labels["appcop"] = "suspend"
+	return nil
+}
+
 func (app *App) penalize() error {
 
 	if app.Instances >= 1 {
@@ -45,6 +74,10 @@ func (app *App) penalize() error {
 		return fmt.Errorf("unable to scale down, zero instance")
 	}
 
+	if app.Labels == nil {
+		app.Labels = make(map[string]string)
+	}
+
 	if app.Instances == 0 {
 		app.Labels["appcop"] = "suspend"
 	} else {
@@ -96,6 +129,11 @@ type Task struct {
 	Host               string              `json:"host"`
 	Ports              []int               `json:"ports"`
 	HealthCheckResults []HealthCheckResult `json:"healthCheckResults"`
+	// Message, Reason and Source explain task status, Reason and Source
+	// are present only in events converted from Mesos
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Source  string `json:"source"`
 }
 
 // GetMetric returns a string indicating where this applications metric should be placed
@@ -103,23 +141,36 @@ type Task struct {
 // from application id and replacing appID separators with
 // metrics separators appropriate for graphite.
 func (t Task) GetMetric(prefix string) string {
-	taskAppID := string(t.AppID)
-
-	var appID string
-	if prefix == "" {
-		appID = taskAppID
-	} else {
-		appID = strings.Replace(taskAppID, prefix, "", 1)
-	}
-	noRootAppID := strings.TrimPrefix(appID, "/")
-	metricPath := strings.Replace(noRootAppID, metrics.PathSeparator, metrics.MetricSeparator, -1)
 	taskStatus := strings.ToLower(t.TaskStatus)
 
-	filteredPathParts := metrics.FilterOutEmptyStrings([]string{metricPath, taskStatus})
+	filteredPathParts := metrics.FilterOutEmptyStrings([]string{t.AppID.GetMetric(prefix), taskStatus})
 	return strings.Join(filteredPathParts, metrics.MetricSeparator)
 
 }
 
+// GetMetricApp returns application id without prefix, used to label
+// application metrics
+func (t Task) GetMetricApp(prefix string) string {
+	return t.AppID.GetMetricApp(prefix)
+}
+
+// GetMetric returns application id without prefix with appID separators
+// replaced with graphite metrics separators
+func (id AppID) GetMetric(prefix string) string {
+	noRootAppID := strings.TrimPrefix(id.GetMetricApp(prefix), "/")
+	return strings.Replace(noRootAppID, metrics.PathSeparator, metrics.MetricSeparator, -1)
+}
+
+// GetMetricApp returns application id without prefix, used to label
+// application metrics
+func (id AppID) GetMetricApp(prefix string) string {
+	appID := string(id)
+	if prefix != "" {
+		appID = strings.Replace(appID, prefix, "", 1)
+	}
+	return "/" + strings.TrimPrefix(appID, "/")
+}
+
 // TaskID from marathon
 // Usually in the form of AppID.uuid with '/' replaced with '_'
 type TaskID string
@@ -134,9 +185,47 @@ func (id TaskID) AppID() AppID {
 	return AppID("/" + strings.Replace(id.String()[0:index], "_", "/", -1))
 }
 
+// incarnationSeparator precedes task incarnation in task ids used since
+// Marathon 1.5 (e.g. app.instance-uuid._app.1)
+const incarnationSeparator = "._app."
+
+// InstanceID returns id of instance task belongs to, it does not change when
+// instance is restarted. Returns empty string for task ids without instance
+// (Marathon before 1.5), where every launch gets new id.
+func (id TaskID) InstanceID() string {
+	index := strings.LastIndex(id.String(), incarnationSeparator)
+	if index < 0 {
+		return ""
+	}
+	return id.String()[0:index]
+}
+
 // HealthCheckResult returned from marathon api
 type HealthCheckResult struct {
-	Alive bool `json:"alive"`
+	Alive               bool `json:"alive"`
+	ConsecutiveFailures int  `json:"consecutiveFailures"`
+}
+
+// IsUnhealthy checks if any of task health checks reports task is not alive
+func (t Task) IsUnhealthy() bool {
+	for _, result := range t.HealthCheckResults {
+		if !result.Alive {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsecutiveFailures returns highest number of consecutive health check
+// failures reported for task
+func (t Task) ConsecutiveFailures() int {
+	failures := 0
+	for _, result := range t.HealthCheckResults {
+		if result.ConsecutiveFailures > failures {
+			failures = result.ConsecutiveFailures
+		}
+	}
+	return failures
 }
 
 // TasksResponse response to TasksGet call
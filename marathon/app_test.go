@@ -204,6 +204,24 @@ func TestTaskGetMetricTestCases(t *testing.T) {
 	}
 }
 
+func TestTaskIDInstanceIDStripsIncarnation(t *testing.T) {
+	t.Parallel()
+	// expect
+	assert.Equal(t, "group_app.instance-6a1e5c4d-2c55-11e8-9c4d-0242ac110002",
+		TaskID("group_app.instance-6a1e5c4d-2c55-11e8-9c4d-0242ac110002._app.3").InstanceID())
+	assert.Equal(t, "", TaskID("group_app.6a1e5c4d-2c55-11e8-9c4d-0242ac110002").InstanceID())
+}
+
+func TestTaskGetMetricAppTrimsPrefixAndKeepsRoot(t *testing.T) {
+	t.Parallel()
+	// given
+	task := Task{AppID: "/com.example.domain/app-name"}
+	// expect
+	assert.Equal(t, "/com.example.domain/app-name", task.GetMetricApp(""))
+	assert.Equal(t, "/domain/app-name", task.GetMetricApp("/com.example."))
+	assert.Equal(t, "/", Task{}.GetMetricApp("com.example."))
+}
+
 var penalizeTestCases = []struct {
 	app         *App
 	expectedApp *App
@@ -275,3 +293,56 @@ func TestIsImmuneShouldReturnTrueWhenImmunityLabelIsSetTrue(t *testing.T) {
 	// then
 	assert.Equal(t, expectedExcused, actualExcused)
 }
+
+func TestParseQueueReturnsItemsWithOffersSummary(t *testing.T) {
+	t.Parallel()
+	// given
+	var queueJSON = []byte(`{"queue": [{
+		"count": 2,
+		"delay": {"overdue": true, "timeLeftSeconds": 0},
+		"since": "2017-03-01T11:00:00.000Z",
+		"app": {"id": "/app", "instances": 2},
+		"processedOffersSummary": {
+			"processedOffersCount": 10,
+			"unusedOffersCount": 10,
+			"rejectSummaryLastOffers": [
+				{"reason": "InsufficientMemory", "declined": 10, "processed": 10},
+				{"reason": "UnfulfilledRole", "declined": 0, "processed": 10}
+			]
+		}
+	}]}`)
+	// when
+	queue, err := ParseQueue(queueJSON)
+	// then
+	require.NoError(t, err)
+	require.Len(t, queue, 1)
+	assert.Equal(t, AppID("/app"), queue[0].App.ID)
+	assert.True(t, queue[0].IsStuck())
+	assert.Equal(t, map[string]int{"InsufficientMemory": 10}, queue[0].DeclineReasons())
+	since, err := queue[0].SinceTime()
+	require.NoError(t, err)
+	assert.Equal(t, 11, since.Hour())
+}
+
+func TestSuspendScalesAppToZeroAndLabelsIt(t *testing.T) {
+	t.Parallel()
+	// given
+	app := &App{ID: "/app", Instances: 3}
+	// when
+	err := app.suspend()
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, 0, app.Instances)
+	assert.Equal(t, "suspend", app.Labels["appcop"])
+	assert.Error(t, app.suspend())
+}
+
+func TestAppIDGetMetricTrimsPrefixAndReplacesSeparators(t *testing.T) {
+	t.Parallel()
+	// given
+	appID := AppID("/com.example.domain/app-name")
+	// expect
+	assert.Equal(t, "com.example.domain.app-name", appID.GetMetric(""))
+	assert.Equal(t, "domain.app-name", appID.GetMetric("/com.example."))
+	assert.Equal(t, "", AppID("/").GetMetric(""))
+}
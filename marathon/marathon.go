@@ -2,12 +2,16 @@ package marathon
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/sethgrid/pester"
 
@@ -15,21 +19,37 @@ import (
 	"github.com/allegro/marathon-appcop/metrics"
 )
 
-// Marathoner interfacing marathon
+// Marathoner interfacing marathon.
+// Every method talking to Marathon accepts context, cancelling it aborts
+// request in flight.
 type Marathoner interface {
-	AppGet(AppID) (*App, error)
-	AppsGet() ([]*App, error)
-	TasksGet(AppID) ([]*Task, error)
+	AppGet(context.Context, AppID) (*App, error)
+	AppsGet(context.Context) ([]*App, error)
+	TasksGet(context.Context, AppID) ([]*Task, error)
 	AuthGet() *url.Userinfo
 	LocationGet() string
-	LeaderGet() (string, error)
-	AppScaleDown(*App) error
-	AppDelete(AppID) error
-	GroupDelete(GroupID) error
-	GetEmptyLeafGroups() ([]*Group, error)
+	LeaderGet(context.Context) (string, error)
+	AppScaleDown(context.Context, *App) error
+	AppDelete(context.Context, AppID) error
+	GroupDelete(context.Context, GroupID) error
+	GetEmptyLeafGroups(context.Context) ([]*Group, error)
 	GetAppIDPrefix() string
+	QueueGet(context.Context) ([]*QueueItem, error)
+	AppSuspend(context.Context, *App) error
+	TasksKill(context.Context, []TaskID) error
+	EventSubscriptionRegister(context.Context, string) error
+	EventSubscriptionUnregister(context.Context, string) error
 }
 
+// maxUpdateAttempts limits how many times application update is retried
+// when application was changed by someone else between read and write.
+const maxUpdateAttempts = 3
+
+var (
+	errVersionConflict  = errors.New("application version changed during update")
+	errDeploymentLocked = errors.New("application is locked by deployment")
+)
+
 // Marathon reciever
 type Marathon struct {
 	Location    string
@@ -37,6 +57,8 @@ type Marathon struct {
 	appIDPrefix string
 	Auth        *url.Userinfo
 	client      *pester.Client
+	// timeout is a deadline applied to every single call to Marathon
+	timeout time.Duration
 }
 
 // ScaleData marathon scale json representation
@@ -57,6 +79,11 @@ type DeleteResponse struct {
 	DeploymentID string `json:"deploymentId"`
 }
 
+// KillTasksData marathon /v2/tasks/delete json representation
+type KillTasksData struct {
+	IDs []TaskID `json:"ids"`
+}
+
 // LeaderResponse represents marathon response from /v2/leader request
 type LeaderResponse struct {
 	Leader string `json:"leader"`
@@ -88,26 +115,31 @@ func New(config Config) (*Marathon, error) {
 		appIDPrefix: config.AppIDPrefix,
 		Auth:        auth,
 		client:      pClient,
+		timeout:     config.Timeout,
 	}, nil
 }
 
 // AppGet get marathons application from v2/apps/<AppID>
-func (m Marathon) AppGet(appID AppID) (*App, error) {
+func (m Marathon) AppGet(ctx context.Context, appID AppID) (*App, error) {
 	log.WithField("Location", m.Location).Debugf("Asking Marathon for %s", appID)
 
-	body, err := m.get(m.urlWithQuery(fmt.Sprintf("/v2/apps/%s", appID), urlParams{"embed": "apps.tasks"}))
+	body, err := m.get(ctx, m.urlWithQuery(appPath(appID), urlParams{"embed": "apps.tasks"}))
 	if err != nil {
 		return nil, err
 	}
 
-	return ParseApp(body)
+	app, err := ParseApp(body)
+	if err != nil {
+		return nil, err
+	}
+	return app, nil
 }
 
 // AppsGet get marathons application from v2/apps/<AppID>
-func (m Marathon) AppsGet() ([]*App, error) {
+func (m Marathon) AppsGet(ctx context.Context) ([]*App, error) {
 	log.Debug("Asking Marathon for list of applications")
 
-	body, err := m.get(m.url("/v2/apps/"))
+	body, err := m.get(ctx, m.url("/v2/apps/"))
 	if err != nil {
 		return nil, err
 	}
@@ -116,14 +148,13 @@ func (m Marathon) AppsGet() ([]*App, error) {
 }
 
 // TasksGet lists marathon tasks for specified AppID
-func (m Marathon) TasksGet(appID AppID) ([]*Task, error) {
+func (m Marathon) TasksGet(ctx context.Context, appID AppID) ([]*Task, error) {
 	log.WithFields(log.Fields{
 		"Location": m.Location,
 		"Id":       appID,
 	}).Debug("asking Marathon for tasks")
 
-	trimmedAppID := strings.Trim(appID.String(), "/")
-	body, err := m.get(m.url(fmt.Sprintf("/v2/apps/%s/tasks", trimmedAppID)))
+	body, err := m.get(ctx, m.url(appPath(appID)+"/tasks"))
 	if err != nil {
 		return nil, err
 	}
@@ -138,16 +169,28 @@ func close(r *http.Response) {
 	}
 }
 
-func (m Marathon) get(url string) ([]byte, error) {
+// withTimeout returns context limited by configured per call deadline
+func (m Marathon) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, m.timeout)
+}
+
+func (m Marathon) get(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
 	request, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
 	}
+	request = request.WithContext(ctx)
 	request.Header.Add("Accept", "application/json")
 
 	log.WithFields(log.Fields{
-		"Uri":      request.URL.RequestURI(),
+		"Uri":      redactToken(request.URL.RequestURI()),
 		"Location": m.Location,
 		"Protocol": m.Protocol,
 	}).Debug("Sending GET request to Marathon")
@@ -157,6 +200,7 @@ func (m Marathon) get(url string) ([]byte, error) {
 		response, err = m.client.Do(request)
 	})
 	if err != nil {
+		err = redactError(err)
 		metrics.Mark("marathon.get.error")
 		m.logHTTPError(response, err)
 		return nil, err
@@ -173,16 +217,26 @@ func (m Marathon) get(url string) ([]byte, error) {
 	return ioutil.ReadAll(response.Body)
 }
 
-func (m Marathon) update(url string, d []byte) ([]byte, error) {
+// update puts d to url, when version is set it is sent as If-Match
+// precondition. Conflicting write is reported as errVersionConflict, write to
+// application locked by deployment as errDeploymentLocked.
+func (m Marathon) update(ctx context.Context, url string, d []byte, version string) ([]byte, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
 	request, err := http.NewRequest("PUT", url, bytes.NewBuffer(d))
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
 	}
+	request = request.WithContext(ctx)
 	request.Header.Add("Accept", "application/json")
+	if version != "" {
+		request.Header.Set("If-Match", strconv.Quote(version))
+	}
 
 	log.WithFields(log.Fields{
-		"Uri":      request.URL.RequestURI(),
+		"Uri":      redactToken(request.URL.RequestURI()),
 		"Location": m.Location,
 		"Protocol": m.Protocol,
 	}).Debug("Sending PUT request to marathon")
@@ -192,6 +246,7 @@ func (m Marathon) update(url string, d []byte) ([]byte, error) {
 		response, err = m.client.Do(request)
 	})
 	if err != nil {
+		err = redactError(err)
 		log.Warn("Updating application failed.")
 		metrics.Mark("marathon.put.error")
 		m.logHTTPError(response, err)
@@ -199,6 +254,14 @@ func (m Marathon) update(url string, d []byte) ([]byte, error) {
 	}
 	defer close(response)
 
+	if response.StatusCode == http.StatusConflict || response.StatusCode == http.StatusPreconditionFailed {
+		metrics.Mark("marathon.put.error")
+		metrics.Mark(fmt.Sprintf("marathon.put.error.%d", response.StatusCode))
+		if response.StatusCode == http.StatusConflict && isDeploymentLock(response) {
+			return nil, errDeploymentLocked
+		}
+		return nil, errVersionConflict
+	}
 	if response.StatusCode != 200 {
 		metrics.Mark("marathon.put.error")
 		metrics.Mark(fmt.Sprintf("marathon.put.error.%d", response.StatusCode))
@@ -210,16 +273,62 @@ func (m Marathon) update(url string, d []byte) ([]byte, error) {
 	return ioutil.ReadAll(response.Body)
 }
 
-func (m Marathon) delete(url string) ([]byte, error) {
+func (m Marathon) post(ctx context.Context, url string, d []byte) ([]byte, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	request, err := http.NewRequest("POST", url, bytes.NewBuffer(d))
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Add("Accept", "application/json")
+	request.Header.Add("Content-Type", "application/json")
+
+	log.WithFields(log.Fields{
+		"Uri":      redactToken(request.URL.RequestURI()),
+		"Location": m.Location,
+		"Protocol": m.Protocol,
+	}).Debug("Sending POST request to marathon")
+
+	var response *http.Response
+	metrics.Time("marathon.post", func() {
+		response, err = m.client.Do(request)
+	})
+	if err != nil {
+		err = redactError(err)
+		metrics.Mark("marathon.post.error")
+		m.logHTTPError(response, err)
+		return nil, err
+	}
+	defer close(response)
+
+	if response.StatusCode != 200 {
+		metrics.Mark("marathon.post.error")
+		metrics.Mark(fmt.Sprintf("marathon.post.error.%d", response.StatusCode))
+		err = fmt.Errorf("expected 200 but got %d for %s", response.StatusCode, response.Request.URL.Path)
+		m.logHTTPError(response, err)
+		return nil, err
+	}
+
+	return ioutil.ReadAll(response.Body)
+}
+
+func (m Marathon) delete(ctx context.Context, url string) ([]byte, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
 	request, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		log.Error(err.Error())
 		return nil, err
 	}
+	request = request.WithContext(ctx)
 	request.Header.Add("Accept", "application/json")
 
 	log.WithFields(log.Fields{
-		"Uri":      request.URL.RequestURI(),
+		"Uri":      redactToken(request.URL.RequestURI()),
 		"Location": m.Location,
 		"Protocol": m.Protocol,
 	}).Debug("Sending DELETE request to marathon")
@@ -229,6 +338,7 @@ func (m Marathon) delete(url string) ([]byte, error) {
 		response, err = m.client.Do(request)
 	})
 	if err != nil {
+		err = redactError(err)
 		log.Warn("Deleting application failed.")
 		metrics.Mark("marathon.delete.error")
 		m.logHTTPError(response, err)
@@ -247,58 +357,151 @@ func (m Marathon) delete(url string) ([]byte, error) {
 	return ioutil.ReadAll(response.Body)
 }
 
-// AppScaleDown scales down app by provided AppID
-func (m Marathon) AppScaleDown(app *App) error {
+// AppScaleDown scales down app by provided AppID.
+// Provided app is used only for identification, penalty is always applied to
+// the current application definition fetched from Marathon, so changes made
+// by the owner in the meantime are preserved.
+func (m Marathon) AppScaleDown(ctx context.Context, app *App) error {
 
 	log.WithFields(log.Fields{
 		"AppID": app.ID,
 	}).Debug("Scaling Down application because of score.")
 
-	err := app.penalize()
+	return m.updateApp(ctx, app.ID, func(current *App) error {
+		return current.penalize()
+	})
+}
+
+// updateApp applies mutate to the freshly fetched application and writes back
+// only fields owned by AppCop (instances and labels). Version which was read
+// is sent as write precondition, when write conflicts whole read-modify-write
+// cycle is retried. Application locked by deployment (usually stuck deployment
+// of crash looping application) is updated with force.
+func (m Marathon) updateApp(ctx context.Context, appID AppID, mutate func(*App) error) error {
+	var err error
+	for attempt := 1; attempt <= maxUpdateAttempts; attempt++ {
+		err = m.tryUpdateApp(ctx, appID, mutate)
+		if err != errVersionConflict {
+			return err
+		}
+		metrics.Mark("marathon.update.conflict")
+		log.WithFields(log.Fields{
+			"AppID":   appID,
+			"Attempt": attempt,
+		}).Warn("Application changed during update, retrying with fresh read")
+	}
+	return err
+}
+
+func (m Marathon) tryUpdateApp(ctx context.Context, appID AppID, mutate func(*App) error) error {
+	current, err := m.AppGet(ctx, appID)
 	if err != nil {
 		return err
 	}
+	readVersion := current.Version
 
-	scaleData := &ScaleData{Instances: app.Instances, Labels: app.Labels}
-	u, err := json.Marshal(scaleData)
+	err = mutate(current)
 	if err != nil {
 		return err
 	}
 
-	trimmedAppID := strings.Trim(app.ID.String(), "/")
-	url := m.urlWithQuery(fmt.Sprintf("/v2/apps/%s", trimmedAppID),
-		urlParams{"force": "true"})
+	scaleData := &ScaleData{Instances: current.Instances, Labels: current.Labels}
+	u, err := json.Marshal(scaleData)
+	if err != nil {
+		return err
+	}
 
-	body, err := m.update(url, u)
+	url := m.url(appPath(appID))
+	body, err := m.update(ctx, url, u, readVersion)
+	if err == errDeploymentLocked {
+		metrics.Mark("marathon.update.forced")
+		log.WithField("AppID", appID).Warn("Application is locked by deployment, forcing update")
+		url = m.urlWithQuery(appPath(appID), urlParams{"force": "true"})
+		body, err = m.update(ctx, url, u, readVersion)
+	}
 	if err != nil {
 		return err
 	}
 
 	log.WithFields(log.Fields{
 		"URL":       url,
-		"Labels":    app.Labels,
-		"Instances": app.Instances,
+		"Labels":    current.Labels,
+		"Instances": current.Instances,
+		"Version":   readVersion,
 	}).Debug("Updated app")
 
 	scaleResponse := &ScaleResponse{}
 	return json.Unmarshal(body, scaleResponse)
 }
 
+// isDeploymentLock tells if 409 response lists deployments holding the
+// application, other conflicts are not resolved with force
+func isDeploymentLock(response *http.Response) bool {
+	conflict := struct {
+		Deployments []json.RawMessage `json:"deployments"`
+	}{}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil || json.Unmarshal(body, &conflict) != nil {
+		return false
+	}
+	return len(conflict.Deployments) > 0
+}
+
+// AppSuspend scales application to zero instances, like AppScaleDown it is
+// applied to the current application definition.
+func (m Marathon) AppSuspend(ctx context.Context, app *App) error {
+
+	log.WithFields(log.Fields{
+		"AppID": app.ID,
+	}).Debug("Suspending application.")
+
+	return m.updateApp(ctx, app.ID, func(current *App) error {
+		return current.suspend()
+	})
+}
+
+// TasksKill kills provided tasks and scales their applications down, so
+// killed tasks are not restarted.
+// Unlike AppScaleDown it does not put appcop label on the application,
+// Marathon treats label change as new application version and would restart
+// all tasks, which kill-tasks enforcement is meant to avoid. For the same
+// reason kill is not version checked, Marathon does not accept version on
+// tasks delete. Kills are recorded in audit log only.
+func (m Marathon) TasksKill(ctx context.Context, ids []TaskID) error {
+
+	log.WithFields(log.Fields{
+		"TaskIDs": ids,
+	}).Debug("Killing tasks.")
+
+	d, err := json.Marshal(&KillTasksData{IDs: ids})
+	if err != nil {
+		return err
+	}
+
+	url := m.urlWithQuery("/v2/tasks/delete", urlParams{"scale": "true", "force": "true"})
+	body, err := m.post(ctx, url, d)
+	if err != nil {
+		return err
+	}
+
+	scaleResponse := &ScaleResponse{}
+	return json.Unmarshal(body, scaleResponse)
+}
+
 // AppDelete scales down app by provided AppID
-func (m Marathon) AppDelete(app AppID) error {
+func (m Marathon) AppDelete(ctx context.Context, app AppID) error {
 
 	log.WithFields(log.Fields{
 		"AppID": app,
 	}).Info("Deleting application.")
 
-	trimmedAppID := strings.Trim(app.String(), "/")
-	url := m.url(fmt.Sprintf("/v2/apps/%s", trimmedAppID))
+	url := m.url(appPath(app))
 
 	log.WithFields(log.Fields{
 		"url": url,
 	}).Debug("Application url.")
 
-	body, err := m.delete(url)
+	body, err := m.delete(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -320,6 +523,48 @@ func (m Marathon) logHTTPError(resp *http.Response, err error) {
 	}).Error(err)
 }
 
+// callbackTokenParam is query parameter of callback URL carrying shared
+// secret, it is never logged
+const callbackTokenParam = "token"
+
+// redactToken replaces value of callbackTokenParam in provided URL and in URLs
+// passed in its query (e.g. callbackUrl of event subscription)
+func redactToken(rawURL string) string {
+	if !strings.Contains(rawURL, callbackTokenParam) {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	query := u.Query()
+	for key, values := range query {
+		for i, value := range values {
+			if key == callbackTokenParam {
+				values[i] = "***"
+			} else {
+				values[i] = redactToken(value)
+			}
+		}
+	}
+	u.RawQuery = strings.Replace(query.Encode(), "%2A%2A%2A", "***", -1)
+	return u.String()
+}
+
+// redactError removes token from URL quoted by request error
+func redactError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		urlErr.URL = redactToken(urlErr.URL)
+	}
+	return err
+}
+
+// appPath returns API path of application, application id is accepted with or
+// without leading slash
+func appPath(appID AppID) string {
+	return "/v2/apps/" + strings.Trim(appID.String(), "/")
+}
+
 func (m Marathon) url(path string) string {
 	return m.urlWithQuery(path, nil)
 }
@@ -339,10 +584,22 @@ func (m Marathon) urlWithQuery(path string, params urlParams) string {
 	return marathon.String()
 }
 
+// QueueGet lists applications waiting in marathon launch queue
+func (m Marathon) QueueGet(ctx context.Context) ([]*QueueItem, error) {
+	log.Debug("Asking Marathon for launch queue")
+
+	body, err := m.get(ctx, m.url("/v2/queue"))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseQueue(body)
+}
+
 // GetEmptyLeafGroups returns groups which are leafs of groups
 // directory and only if they are empty (no apps inside).
-func (m Marathon) GetEmptyLeafGroups() ([]*Group, error) {
-	groups, err := m.groupsGet()
+func (m Marathon) GetEmptyLeafGroups(ctx context.Context) ([]*Group, error) {
+	groups, err := m.groupsGet(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -358,10 +615,10 @@ func (m Marathon) GetEmptyLeafGroups() ([]*Group, error) {
 }
 
 // groupsGet get marathons application from v2/apps/<AppID>
-func (m Marathon) groupsGet() ([]*Group, error) {
+func (m Marathon) groupsGet(ctx context.Context) ([]*Group, error) {
 	log.Debug("Asking Marathon for list of groups")
 
-	body, err := m.get(m.url("/v2/groups/"))
+	body, err := m.get(ctx, m.url("/v2/groups/"))
 	if err != nil {
 		return nil, err
 	}
@@ -386,7 +643,7 @@ func (m Marathon) getLeafGroups(groups []*Group) []*Group {
 }
 
 // GroupDelete scales down app by provided AppID
-func (m Marathon) GroupDelete(group GroupID) error {
+func (m Marathon) GroupDelete(ctx context.Context, group GroupID) error {
 
 	log.WithFields(log.Fields{
 		"GroupID": group,
@@ -399,7 +656,7 @@ func (m Marathon) GroupDelete(group GroupID) error {
 		"url": url,
 	}).Debug("Group url.")
 
-	body, err := m.delete(url)
+	body, err := m.delete(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -408,6 +665,32 @@ func (m Marathon) GroupDelete(group GroupID) error {
 	return json.Unmarshal(body, deleteResponse)
 }
 
+// EventSubscriptionRegister makes marathon send events to provided callback
+// url, requires marathon started with http_callback event subscriber
+func (m Marathon) EventSubscriptionRegister(ctx context.Context, callbackURL string) error {
+
+	log.WithFields(log.Fields{
+		"CallbackURL": redactToken(callbackURL),
+	}).Info("Registering event subscription.")
+
+	url := m.urlWithQuery("/v2/eventSubscriptions", urlParams{"callbackUrl": callbackURL})
+	_, err := m.post(ctx, url, nil)
+	return err
+}
+
+// EventSubscriptionUnregister stops marathon from sending events to provided
+// callback url
+func (m Marathon) EventSubscriptionUnregister(ctx context.Context, callbackURL string) error {
+
+	log.WithFields(log.Fields{
+		"CallbackURL": redactToken(callbackURL),
+	}).Info("Unregistering event subscription.")
+
+	url := m.urlWithQuery("/v2/eventSubscriptions", urlParams{"callbackUrl": callbackURL})
+	_, err := m.delete(ctx, url)
+	return err
+}
+
 // AuthGet string from marathon configured instance
 func (m Marathon) AuthGet() *url.Userinfo {
 	return m.Auth
@@ -419,9 +702,9 @@ func (m Marathon) LocationGet() string {
 }
 
 // LeaderGet from marathon cluster
-func (m Marathon) LeaderGet() (string, error) {
+func (m Marathon) LeaderGet(ctx context.Context) (string, error) {
 	log.WithField("Location", m.Location).Debug("Asking Marathon for leader")
-	body, err := m.get(m.url("/v2/leader"))
+	body, err := m.get(ctx, m.url("/v2/leader"))
 	if err != nil {
 		return "", err
 	}
@@ -1,6 +1,7 @@
 package marathon
 
 import (
+	"context"
 	"errors"
 	"net/url"
 )
@@ -19,6 +20,24 @@ type MStub struct {
 	AppScaleDownFail bool
 	FailCounter      *FailCounter
 	ScaleCounter     *ScaleCounter
+	Queue            []*QueueItem
+	SuspendCounter   *SuspendCounter
+	KilledTasks      *KilledTasks
+	Subscriptions    *Subscriptions
+	// Leader returned by LeaderGet
+	Leader string
+}
+
+// NewMStub returns stub with initialised counters, so operations recorded
+// through them do not panic
+func NewMStub() MStub {
+	return MStub{
+		FailCounter:    &FailCounter{},
+		ScaleCounter:   &ScaleCounter{},
+		SuspendCounter: &SuspendCounter{},
+		KilledTasks:    &KilledTasks{},
+		Subscriptions:  &Subscriptions{},
+	}
 }
 
 // FailCounter is structure to hold state between failures
@@ -31,8 +50,23 @@ type ScaleCounter struct {
 	Counter int
 }
 
+// KilledTasks records tasks killed through stub
+type KilledTasks struct {
+	IDs []TaskID
+}
+
+// Subscriptions records callback urls registered through stub
+type Subscriptions struct {
+	CallbackURLs []string
+}
+
+// SuspendCounter is counting suspend operations
+type SuspendCounter struct {
+	Counter int
+}
+
 // AppsGet get stubbed apps
-func (m MStub) AppsGet() ([]*App, error) {
+func (m MStub) AppsGet(_ context.Context) ([]*App, error) {
 	if m.AppsGetFail {
 		return nil, errors.New("unable to get applications from marathon")
 	}
@@ -40,7 +74,7 @@ func (m MStub) AppsGet() ([]*App, error) {
 }
 
 // AppGet get stubbed app
-func (m MStub) AppGet(appID AppID) (*App, error) {
+func (m MStub) AppGet(_ context.Context, appID AppID) (*App, error) {
 	for _, app := range m.Apps {
 		if app.ID == appID {
 			return app, nil
@@ -50,12 +84,12 @@ func (m MStub) AppGet(appID AppID) (*App, error) {
 }
 
 // GroupsGet get stubbed groups
-func (m MStub) GroupsGet() ([]*Group, error) {
+func (m MStub) GroupsGet(_ context.Context) ([]*Group, error) {
 	return m.Groups, nil
 }
 
 // TasksGet get stubed Tasks
-func (m MStub) TasksGet(appID AppID) ([]*Task, error) {
+func (m MStub) TasksGet(_ context.Context, appID AppID) ([]*Task, error) {
 	return []*Task{
 		{AppID: appID},
 	}, nil
@@ -72,12 +106,12 @@ func (m MStub) LocationGet() string {
 }
 
 // LeaderGet get stubbed leader
-func (m MStub) LeaderGet() (string, error) {
-	return "", nil
+func (m MStub) LeaderGet(_ context.Context) (string, error) {
+	return m.Leader, nil
 }
 
 // AppScaleDown by one instance
-func (m MStub) AppScaleDown(app *App) error {
+func (m MStub) AppScaleDown(_ context.Context, app *App) error {
 	if m.AppScaleDownFail {
 		return errors.New("unable to scale down")
 	}
@@ -86,7 +120,7 @@ func (m MStub) AppScaleDown(app *App) error {
 }
 
 // AppDelete application by provided AppID
-func (m MStub) AppDelete(appID AppID) error {
+func (m MStub) AppDelete(_ context.Context, appID AppID) error {
 	if m.AppDelFail {
 		return errors.New("unable to delete app")
 	}
@@ -101,7 +135,7 @@ func (m MStub) AppDelete(appID AppID) error {
 }
 
 // GroupDelete by provided GroupID
-func (m MStub) GroupDelete(groupID GroupID) error {
+func (m MStub) GroupDelete(_ context.Context, groupID GroupID) error {
 	if m.GroupDelFail {
 		return errors.New("unable to delete group")
 	}
@@ -109,10 +143,51 @@ func (m MStub) GroupDelete(groupID GroupID) error {
 }
 
 // GetEmptyLeafGroups returns groups from marathon which are leafs in group tree
-func (m MStub) GetEmptyLeafGroups() ([]*Group, error) {
+func (m MStub) GetEmptyLeafGroups(_ context.Context) ([]*Group, error) {
 	return []*Group{}, nil
 }
 
 func (m MStub) GetAppIDPrefix() string {
 	return ""
 }
+
+// QueueGet get stubbed launch queue
+func (m MStub) QueueGet(_ context.Context) ([]*QueueItem, error) {
+	return m.Queue, nil
+}
+
+// AppSuspend application
+func (m MStub) AppSuspend(_ context.Context, app *App) error {
+	if m.AppScaleDownFail {
+		return errors.New("unable to suspend")
+	}
+	m.SuspendCounter.Counter++
+	return nil
+}
+
+// TasksKill records killed tasks
+func (m MStub) TasksKill(_ context.Context, ids []TaskID) error {
+	if m.AppScaleDownFail {
+		return errors.New("unable to kill tasks")
+	}
+	m.KilledTasks.IDs = append(m.KilledTasks.IDs, ids...)
+	return nil
+}
+
+// EventSubscriptionRegister records callback url
+func (m MStub) EventSubscriptionRegister(_ context.Context, callbackURL string) error {
+	m.Subscriptions.CallbackURLs = append(m.Subscriptions.CallbackURLs, callbackURL)
+	return nil
+}
+
+// EventSubscriptionUnregister removes recorded callback url
+func (m MStub) EventSubscriptionUnregister(_ context.Context, callbackURL string) error {
+	urls := m.Subscriptions.CallbackURLs[:0]
+	for _, url := range m.Subscriptions.CallbackURLs {
+		if url != callbackURL {
+			urls = append(urls, url)
+		}
+	}
+	m.Subscriptions.CallbackURLs = urls
+	return nil
+}
@@ -1,12 +1,14 @@
 package marathon
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -28,7 +30,7 @@ func TestMarathonTasksWhenMarathonConnectionFailedShouldNotRetry(t *testing.T) {
 	m.client.Concurrency = 1
 	m.client.MaxRetries = 1
 	// when
-	tasks, err := m.TasksGet("/app/id")
+	tasks, err := m.TasksGet(context.Background(), "/app/id")
 	//then
 	m.client.Concurrency = 1
 	m.client.MaxRetries = 1
@@ -53,13 +55,52 @@ func TestMarathonAppGetWhenMarathonConnectionFailedShouldNotRetry(t *testing.T)
 	m.client.Concurrency = 1
 	m.client.MaxRetries = 1
 	// when
-	app, err := m.AppGet("/app/id")
+	app, err := m.AppGet(context.Background(), "/app/id")
 	//then
 	assert.Error(t, err)
 	assert.Nil(t, app)
 	assert.Equal(t, 1, calls)
 }
 
+func TestMarathonAppGetWhenContextIsCancelledShouldReturnError(t *testing.T) {
+	t.Parallel()
+	// given
+	server, transport := stubServer("/v2/apps/test/app?embed=apps.tasks", `{"app": {}}`)
+	defer server.Close()
+
+	url, _ := url.Parse(server.URL)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
+	m.client.Transport = transport
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// when
+	app, err := m.AppGet(ctx, "/test/app")
+	//then
+	assert.Error(t, err)
+	assert.Nil(t, app)
+}
+
+func TestMarathonLeaderGetWhenMarathonDoesNotRespondInTimeShouldReturnError(t *testing.T) {
+	t.Parallel()
+	// given
+	server, transport := mockServer(func(w http.ResponseWriter, r *http.Request) {
+		// hang until client gives up
+		<-r.Context().Done()
+	})
+	defer server.Close()
+
+	url, _ := url.Parse(server.URL)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP", Timeout: 10 * time.Millisecond})
+	m.client.Transport = transport
+	m.client.Concurrency = 1
+	m.client.MaxRetries = 1
+	// when
+	leader, err := m.LeaderGet(context.Background())
+	//then
+	assert.Error(t, err)
+	assert.Equal(t, "", leader)
+}
+
 func TestMarathonAppsWhenMarathonReturnMalformedJSONResponse(t *testing.T) {
 	t.Parallel()
 	// given
@@ -70,7 +111,7 @@ func TestMarathonAppsWhenMarathonReturnMalformedJSONResponse(t *testing.T) {
 	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
 	m.client.Transport = transport
 	// when
-	app, err := m.AppGet("/testapp")
+	app, err := m.AppGet(context.Background(), "/testapp")
 	//then
 	assert.Nil(t, app)
 	assert.Error(t, err)
@@ -79,14 +120,14 @@ func TestMarathonAppsWhenMarathonReturnMalformedJSONResponse(t *testing.T) {
 func TestMarathonAppWhenMarathonReturnEmptyApp(t *testing.T) {
 	t.Parallel()
 	// given
-	server, transport := stubServer("/v2/apps//test/app?embed=apps.tasks", `{"app": {}}`)
+	server, transport := stubServer("/v2/apps/test/app?embed=apps.tasks", `{"app": {}}`)
 	defer server.Close()
 
 	url, _ := url.Parse(server.URL)
 	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
 	m.client.Transport = transport
 	// when
-	app, err := m.AppGet("/test/app")
+	app, err := m.AppGet(context.Background(), "/test/app")
 	//then
 	assert.NoError(t, err)
 	assert.NotNil(t, app)
@@ -95,16 +136,16 @@ func TestMarathonAppWhenMarathonReturnEmptyApp(t *testing.T) {
 func TestMarathonAppWhenMarathonReturnEmptyResponse(t *testing.T) {
 	t.Parallel()
 	// given
-	server, transport := stubServer("/v2/apps//test/app?embed=apps.tasks", ``)
+	server, transport := stubServer("/v2/apps/test/app?embed=apps.tasks", ``)
 	defer server.Close()
 
 	url, _ := url.Parse(server.URL)
 	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
 	m.client.Transport = transport
 	// when
-	app, err := m.AppGet("/test/app")
+	app, err := m.AppGet(context.Background(), "/test/app")
 	//then
-	assert.NotNil(t, app)
+	assert.Nil(t, app)
 	assert.Error(t, err)
 }
 
@@ -124,7 +165,7 @@ func TestMarathonTasksWhenMarathonReturnEmptyList(t *testing.T) {
 	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
 	m.client.Transport = transport
 	// when
-	tasks, err := m.TasksGet("/test/app")
+	tasks, err := m.TasksGet(context.Background(), "/test/app")
 	//then
 	assert.NoError(t, err)
 	assert.NotNil(t, tasks)
@@ -139,7 +180,7 @@ func TestMarathonTasksWhenMarathonReturnEmptyResponse(t *testing.T) {
 	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
 	m.client.Transport = transport
 	// when
-	tasks, err := m.TasksGet("/test/app")
+	tasks, err := m.TasksGet(context.Background(), "/test/app")
 	//then
 	assert.Nil(t, tasks)
 	assert.Error(t, err)
@@ -154,7 +195,7 @@ func TestMarathonTasksWhenMarathonReturnMalformedJSONResponse(t *testing.T) {
 	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
 	m.client.Transport = transport
 	// when
-	tasks, err := m.TasksGet("/test/app")
+	tasks, err := m.TasksGet(context.Background(), "/test/app")
 	//then
 	assert.Nil(t, tasks)
 	assert.Error(t, err)
@@ -194,7 +235,7 @@ func TestLeaderGetRetrunsNoLeader(t *testing.T) {
 	m.client.MaxRetries = 1
 
 	// when
-	leader, err := m.LeaderGet()
+	leader, err := m.LeaderGet(context.Background())
 	//then
 	assert.Equal(t, leader, "")
 	assert.Error(t, err)
@@ -213,7 +254,7 @@ func TestLeaderGetRetrunsCorrectLeader(t *testing.T) {
 	m.client.MaxRetries = 1
 
 	// when
-	leader, err := m.LeaderGet()
+	leader, err := m.LeaderGet(context.Background())
 	//then
 	require.NoError(t, err)
 	assert.Equal(t, leader, "marathon-leader")
@@ -232,7 +273,7 @@ func TestGetLeaderRetrunsMalformedJSON(t *testing.T) {
 	m.client.MaxRetries = 1
 
 	// when
-	leader, err := m.LeaderGet()
+	leader, err := m.LeaderGet(context.Background())
 	//then
 	require.Error(t, err)
 	assert.Equal(t, "", leader)
@@ -254,7 +295,7 @@ func TestMarathonGetLeaderWhenMarathonConnectionFailedShouldNotRetry(t *testing.
 	m.client.Concurrency = 1
 	m.client.MaxRetries = 1
 	// when
-	leader, err := m.LeaderGet()
+	leader, err := m.LeaderGet(context.Background())
 	//then
 	assert.Error(t, err)
 	assert.Equal(t, leader, "")
@@ -320,7 +361,7 @@ func TestMarathonAppsGetWhenMarathonConnectionFailedShouldNotRetry(t *testing.T)
 	m.client.Concurrency = 1
 	m.client.MaxRetries = 1
 	// when
-	app, err := m.AppsGet()
+	app, err := m.AppsGet(context.Background())
 	//then
 	assert.Error(t, err)
 	assert.Nil(t, app)
@@ -337,7 +378,7 @@ func TestMarathonAppsGetWhenMarathonReturnEmptyApp(t *testing.T) {
 	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
 	m.client.Transport = transport
 	// when
-	app, err := m.AppsGet()
+	app, err := m.AppsGet(context.Background())
 	//then
 	assert.Error(t, err)
 	assert.Nil(t, app)
@@ -353,7 +394,7 @@ func TestMarathonAppsGetWhenReturnsMalformedJSON(t *testing.T) {
 	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
 	m.client.Transport = transport
 	// when
-	app, err := m.AppsGet()
+	app, err := m.AppsGet(context.Background())
 	//then
 	assert.Error(t, err)
 	assert.Nil(t, app)
@@ -379,7 +420,7 @@ func TestMarathonAppScaleDownWhenMarathonConnectionFailedShouldNotRetry(t *testi
 		ID: "testapp", Instances: 1,
 		Labels: make(map[string]string),
 	}
-	err := m.AppScaleDown(app)
+	err := m.AppScaleDown(context.Background(), app)
 	//then
 	assert.Error(t, err)
 }
@@ -387,8 +428,19 @@ func TestMarathonAppScaleDownWhenMarathonConnectionFailedShouldNotRetry(t *testi
 func TestMarathonScaleDownAppsSuccess(t *testing.T) {
 	t.Parallel()
 	// given
-	server, transport := stubServer("/v2/apps/testapp0?force=true",
-		`{"version": "0", "deploymentId": "a"}`)
+	var putBody []byte
+	server, transport := mockServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.WriteHeader(200)
+			fmt.Fprintln(w, `{"app": {"id": "/testapp0", "instances": 2, "version": "v1",
+				"labels": {"owner": "team"}}}`)
+		case "PUT":
+			putBody, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(200)
+			fmt.Fprintln(w, `{"version": "v2", "deploymentId": "a"}`)
+		}
+	})
 	defer server.Close()
 	url, _ := url.Parse(server.URL)
 	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
@@ -400,18 +452,17 @@ func TestMarathonScaleDownAppsSuccess(t *testing.T) {
 	}
 
 	// when
-	err := m.AppScaleDown(app)
+	err := m.AppScaleDown(context.Background(), app)
 	//then
-	assert.Nil(t, err)
-
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"instances": 1, "labels": {"owner": "team", "appcop": "scaleDown"}}`, string(putBody))
 }
 
 func TestMarathonScaleDownAppsZeroInstances(t *testing.T) {
 	t.Parallel()
 	// given
-	server, transport := stubServer("/v2/apps/testapp0?force=true",
-		`{"version": "0",
-			"deploymentId": "a"}`,
+	server, transport := stubServer("/v2/apps/testapp0?embed=apps.tasks",
+		`{"app": {"id": "/testapp0", "instances": 0, "version": "v1"}}`,
 	)
 	defer server.Close()
 	url, _ := url.Parse(server.URL)
@@ -419,14 +470,142 @@ func TestMarathonScaleDownAppsZeroInstances(t *testing.T) {
 	m.client.Transport = transport
 
 	app := &App{
-		ID: "testapp0", Instances: 0,
+		ID: "/testapp0", Instances: 1,
 		Labels: make(map[string]string),
 	}
 
 	// when
-	err := m.AppScaleDown(app)
+	err := m.AppScaleDown(context.Background(), app)
 	//then
-	assert.Error(t, err)
+	assert.EqualError(t, err, "unable to scale down, zero instance")
+}
+
+func TestMarathonScaleDownSendsReadVersionAsPreconditionAndRetriesWhenItChanged(t *testing.T) {
+	t.Parallel()
+	// given
+	gets := 0
+	var preconditions []string
+	server, transport := mockServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			gets++
+			w.WriteHeader(200)
+			fmt.Fprintf(w, `{"app": {"id": "/testapp0", "instances": 2, "version": "v%d"}}`, gets)
+		case "PUT":
+			preconditions = append(preconditions, r.Header.Get("If-Match"))
+			// application changed after first read
+			if len(preconditions) == 1 {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			w.WriteHeader(200)
+			fmt.Fprintln(w, `{"version": "v3", "deploymentId": "a"}`)
+		}
+	})
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
+	m.client.Transport = transport
+
+	// when
+	err := m.AppScaleDown(context.Background(), &App{ID: "testapp0"})
+	//then
+	require.NoError(t, err)
+	assert.Equal(t, 2, gets)
+	assert.Equal(t, []string{`"v1"`, `"v2"`}, preconditions)
+}
+
+func TestMarathonScaleDownFailsWhenAppKeepsChanging(t *testing.T) {
+	t.Parallel()
+	// given
+	gets := 0
+	puts := 0
+	server, transport := mockServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			gets++
+			w.WriteHeader(200)
+			fmt.Fprintf(w, `{"app": {"id": "/testapp0", "instances": 2, "version": "v%d"}}`, gets)
+		case "PUT":
+			puts++
+			w.WriteHeader(http.StatusPreconditionFailed)
+		}
+	})
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
+	m.client.Transport = transport
+
+	// when
+	err := m.AppScaleDown(context.Background(), &App{ID: "testapp0"})
+	//then
+	assert.Equal(t, errVersionConflict, err)
+	assert.Equal(t, maxUpdateAttempts, gets)
+	assert.Equal(t, maxUpdateAttempts, puts)
+}
+
+func TestMarathonScaleDownRetriesWithoutForceWhenMarathonRejectsUpdate(t *testing.T) {
+	t.Parallel()
+	// given
+	var putURIs []string
+	server, transport := mockServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.WriteHeader(200)
+			fmt.Fprintln(w, `{"app": {"id": "/testapp0", "instances": 2, "version": "v1"}}`)
+		case "PUT":
+			putURIs = append(putURIs, r.URL.RequestURI())
+			if len(putURIs) == 1 {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprintln(w, `{"message": "Object is not valid"}`)
+				return
+			}
+			w.WriteHeader(200)
+			fmt.Fprintln(w, `{"version": "v2", "deploymentId": "a"}`)
+		}
+	})
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
+	m.client.Transport = transport
+
+	// when
+	err := m.AppScaleDown(context.Background(), &App{ID: "/testapp0"})
+	//then
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/v2/apps/testapp0", "/v2/apps/testapp0"}, putURIs)
+}
+
+func TestMarathonScaleDownForcesUpdateOfAppLockedByDeployment(t *testing.T) {
+	t.Parallel()
+	// given
+	var putURIs []string
+	server, transport := mockServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			w.WriteHeader(200)
+			fmt.Fprintln(w, `{"app": {"id": "/testapp0", "instances": 2, "version": "v1"}}`)
+		case "PUT":
+			putURIs = append(putURIs, r.URL.RequestURI())
+			if r.URL.Query().Get("force") != "true" {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprintln(w, `{"message": "App is locked by one or more deployments.", "deployments": [{"id": "d1"}]}`)
+				return
+			}
+			w.WriteHeader(200)
+			fmt.Fprintln(w, `{"version": "v2", "deploymentId": "a"}`)
+		}
+	})
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
+	m.client.Transport = transport
+
+	// when
+	err := m.AppScaleDown(context.Background(), &App{ID: "/testapp0"})
+	//then
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/v2/apps/testapp0", "/v2/apps/testapp0?force=true"}, putURIs)
 }
 
 func TestMarathonAppDeleteSuccess(t *testing.T) {
@@ -442,7 +621,7 @@ func TestMarathonAppDeleteSuccess(t *testing.T) {
 	m.client.Transport = transport
 
 	// when
-	err := m.AppDelete("testapp")
+	err := m.AppDelete(context.Background(), "testapp")
 	//then
 	assert.Nil(t, err)
 }
@@ -463,11 +642,107 @@ func TestMarathonAppDeleteWhenMarathonReturns500(t *testing.T) {
 	m.client.Concurrency = 1
 	m.client.MaxRetries = 1
 	// when
-	err := m.AppDelete("testapp")
+	err := m.AppDelete(context.Background(), "testapp")
 	//then
 	assert.Error(t, err)
 }
 
+func TestMarathonTasksKillSuccess(t *testing.T) {
+	t.Parallel()
+	// given
+	var body []byte
+	server, transport := mockServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.RequestURI() != "/v2/tasks/delete?force=true&scale=true" {
+			w.WriteHeader(404)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+		fmt.Fprintln(w, `{"version": "1", "deploymentId": "a"}`)
+	})
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
+	m.client.Transport = transport
+	// when
+	err := m.TasksKill(context.Background(), []TaskID{"testapp.1", "testapp.2"})
+	//then
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ids": ["testapp.1", "testapp.2"]}`, string(body))
+}
+
+func TestMarathonTasksKillWhenMarathonReturns500(t *testing.T) {
+	t.Parallel()
+	// given
+	server, transport := mockServer(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	})
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
+	m.client.Transport = transport
+	m.client.Concurrency = 1
+	m.client.MaxRetries = 1
+	// when
+	err := m.TasksKill(context.Background(), []TaskID{"testapp.1"})
+	//then
+	assert.Error(t, err)
+}
+
+var redactTokenTestCases = []struct {
+	url      string
+	expected string
+}{
+	{"/v2/apps/testapp?embed=apps.tasks", "/v2/apps/testapp?embed=apps.tasks"},
+	{"http://appcop:4444/v2/events/callback?token=s3cret", "http://appcop:4444/v2/events/callback?token=***"},
+	{"/v2/eventSubscriptions?callbackUrl=http%3A%2F%2Fappcop%3A4444%2Fv2%2Fevents%2Fcallback%3Ftoken%3Ds3cret",
+		"/v2/eventSubscriptions?callbackUrl=http%3A%2F%2Fappcop%3A4444%2Fv2%2Fevents%2Fcallback%3Ftoken%3D***"},
+}
+
+func TestRedactTokenTestCases(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range redactTokenTestCases {
+		assert.Equal(t, testCase.expected, redactToken(testCase.url))
+	}
+}
+
+func TestMarathonEventSubscriptionRegisterErrorDoesNotLeakToken(t *testing.T) {
+	t.Parallel()
+	// given
+	server := httptest.NewServer(http.NotFoundHandler())
+	address := server.URL
+	server.Close()
+	url, _ := url.Parse(address)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
+	m.client.Concurrency = 1
+	m.client.MaxRetries = 1
+	// when
+	err := m.EventSubscriptionRegister(context.Background(), "http://appcop:4444/v2/events/callback?token=s3cret")
+	//then
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "s3cret")
+}
+
+func TestMarathonEventSubscriptionRegisterSuccess(t *testing.T) {
+	t.Parallel()
+	// given
+	server, transport := mockServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v2/eventSubscriptions" ||
+			r.URL.Query().Get("callbackUrl") != "http://appcop:4444/v2/events/callback" {
+			w.WriteHeader(404)
+			return
+		}
+		fmt.Fprintln(w, `{"callbackUrl": "http://appcop:4444/v2/events/callback", "eventType": "subscribe_event"}`)
+	})
+	defer server.Close()
+	url, _ := url.Parse(server.URL)
+	m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
+	m.client.Transport = transport
+	// when
+	err := m.EventSubscriptionRegister(context.Background(), "http://appcop:4444/v2/events/callback")
+	//then
+	assert.NoError(t, err)
+}
+
 func TestMarathonGroupsGetSuccessMarathonReturnsOneGroup(t *testing.T) {
 	t.Parallel()
 	// given
@@ -482,7 +757,7 @@ func TestMarathonGroupsGetSuccessMarathonReturnsOneGroup(t *testing.T) {
 	m.client.Transport = transport
 
 	// when
-	groups, err := m.groupsGet()
+	groups, err := m.groupsGet(context.Background())
 	//then
 	require.NoError(t, err)
 	require.NotNil(t, groups)
@@ -506,7 +781,7 @@ func TestMarathonGroupsGetWhenMarathonReturns500(t *testing.T) {
 	m.client.Concurrency = 1
 	m.client.MaxRetries = 1
 	// when
-	_, err := m.groupsGet()
+	_, err := m.groupsGet(context.Background())
 	//then
 	assert.Error(t, err)
 }
@@ -524,7 +799,7 @@ func TestMarathonGroupDeleteSuccessOnExampleGroup(t *testing.T) {
 	m.client.Transport = transport
 
 	// when
-	err := m.GroupDelete("testgroup")
+	err := m.GroupDelete(context.Background(), "testgroup")
 	//then
 	require.NoError(t, err)
 }
@@ -545,7 +820,7 @@ func TestMarathonGroupDeleteWhenMarathonReturns500(t *testing.T) {
 	m.client.Concurrency = 1
 	m.client.MaxRetries = 1
 	// when
-	err := m.GroupDelete("testgroup")
+	err := m.GroupDelete(context.Background(), "testgroup")
 	//then
 	assert.Error(t, err)
 }
@@ -594,7 +869,7 @@ func TestMarathonEmptyGetLeafGroupsTestCases(t *testing.T) {
 		m, _ := New(Config{Location: url.Host, Protocol: "HTTP"})
 		m.client.Transport = transport
 
-		groups, err := m.GetEmptyLeafGroups()
+		groups, err := m.GetEmptyLeafGroups(context.Background())
 		require.NoError(t, err)
 
 		assert.Equal(t, len(testCase.expectedIDs), len(groups))
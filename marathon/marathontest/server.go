@@ -0,0 +1,755 @@
+// Package marathontest provides in-process fake Marathon server for end-to-end
+// tests. Server keeps applications, groups and deployments in memory, applies
+// PUT and DELETE calls made by AppCop to this state and exposes scriptable
+// /v2/events stream, so HTTP client, event subscription and event parsing can
+// be exercised together.
+package marathontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+)
+
+const versionFormat = "2006-01-02T15:04:05.000Z"
+
+// Deployment represents deployment created by Marathon after application
+// or group was changed
+type Deployment struct {
+	ID             string   `json:"id"`
+	Version        string   `json:"version"`
+	AffectedApps   []string `json:"affectedApps"`
+	AffectedGroups []string `json:"affectedGroups"`
+}
+
+// Request is a record of mutating call received by server
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// Server is a fake Marathon
+type Server struct {
+	server *httptest.Server
+
+	mutex       sync.Mutex
+	apps        map[marathon.AppID]*marathon.App
+	groups      map[marathon.GroupID]*marathon.Group
+	leader      string
+	deployments []Deployment
+	queue       []*marathon.QueueItem
+	requests    []Request
+	clock       time.Time
+
+	eventID      int
+	history      []event
+	retry        time.Duration
+	lastEventIDs []string
+	callbacks    []string
+	subscribers  map[chan string]eventFilter
+	closeStream  chan struct{}
+}
+
+type event struct {
+	eventType string
+	frame     string
+}
+
+// eventFilter holds event types requested with event_type query parameter,
+// empty filter accepts all events
+type eventFilter map[string]bool
+
+func (f eventFilter) accepts(eventType string) bool {
+	return len(f) == 0 || f[eventType]
+}
+
+// NewServer starts fake Marathon, caller should Close it when done
+func NewServer() *Server {
+	s := &Server{
+		apps:        make(map[marathon.AppID]*marathon.App),
+		groups:      make(map[marathon.GroupID]*marathon.Group),
+		clock:       time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		subscribers: make(map[chan string]eventFilter),
+		closeStream: make(chan struct{}),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.leader = s.Location()
+	return s
+}
+
+// Close shuts down server and all event streams
+func (s *Server) Close() {
+	s.CloseStreams()
+	s.server.Close()
+}
+
+// URL of fake Marathon e.g. http://127.0.0.1:1234
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Location of fake Marathon in the form expected by marathon.Config
+func (s *Server) Location() string {
+	u, _ := url.Parse(s.server.URL)
+	return u.Host
+}
+
+// Config returns marathon.Config pointing to this server
+func (s *Server) Config() marathon.Config {
+	return marathon.Config{Location: s.Location(), Protocol: "http"}
+}
+
+// SetLeader changes value returned by /v2/leader
+func (s *Server) SetLeader(leader string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.leader = leader
+}
+
+// AddApp puts copy of provided application into server state. When
+// application has no version, one is assigned.
+func (s *Server) AddApp(app *marathon.App) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stored := copyApp(app)
+	if stored.Version == "" {
+		stored.Version = s.tick()
+	}
+	if stored.Labels == nil {
+		stored.Labels = make(map[string]string)
+	}
+	s.apps[stored.ID] = stored
+}
+
+// AddGroup registers (possibly empty) group with provided version
+func (s *Server) AddGroup(id marathon.GroupID, version string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.groups[id] = &marathon.Group{ID: id, Version: version}
+}
+
+// SetQueue replaces content of launch queue served on /v2/queue
+func (s *Server) SetQueue(queue []*marathon.QueueItem) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.queue = queue
+}
+
+// App returns copy of application state
+func (s *Server) App(id marathon.AppID) (*marathon.App, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	app, ok := s.apps[id]
+	if !ok {
+		return nil, false
+	}
+	return copyApp(app), true
+}
+
+// Apps returns copy of all applications sorted by id
+func (s *Server) Apps() []*marathon.App {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sortedApps()
+}
+
+// HasGroup checks if group is known to server
+func (s *Server) HasGroup(id marathon.GroupID) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.groups[id]
+	return ok
+}
+
+// Deployments returns deployments created so far
+func (s *Server) Deployments() []Deployment {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Deployment(nil), s.deployments...)
+}
+
+// Requests returns mutating requests received so far
+func (s *Server) Requests() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Subscribers returns number of clients connected to /v2/events
+func (s *Server) Subscribers() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.subscribers)
+}
+
+// WaitForSubscribers blocks until at least n clients are subscribed to
+// /v2/events or timeout passes
+func (s *Server) WaitForSubscribers(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if s.Subscribers() >= n {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+// SendEvent publishes event to all subscribers of /v2/events
+func (s *Server) SendEvent(eventType string, data string) {
+	s.mutex.Lock()
+	defer func() {
+		callbacks := append([]string(nil), s.callbacks...)
+		s.mutex.Unlock()
+		s.postCallbacks(callbacks, data)
+	}()
+
+	s.eventID++
+	frame := fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", s.eventID, eventType, data)
+	s.history = append(s.history, event{eventType: eventType, frame: frame})
+	for subscriber, filter := range s.subscribers {
+		if !filter.accepts(eventType) {
+			continue
+		}
+		// like Marathon, slow subscribers miss events
+		select {
+		case subscriber <- frame:
+		default:
+		}
+	}
+}
+
+// SendKeepAlive sends comment line to all subscribers of /v2/events
+func (s *Server) SendKeepAlive() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- ":\n":
+		default:
+		}
+	}
+}
+
+// EventSubscriptions returns callback urls registered with
+// /v2/eventSubscriptions
+func (s *Server) EventSubscriptions() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.callbacks...)
+}
+
+// postCallbacks delivers event to registered callback urls, like Marathon
+// http_callback subscriber, failed deliveries are not retried
+func (s *Server) postCallbacks(callbacks []string, data string) {
+	for _, callback := range callbacks {
+		response, err := http.Post(callback, "application/json", strings.NewReader(data))
+		if err == nil {
+			response.Body.Close()
+		}
+	}
+}
+
+// SetRetry makes server send retry field with provided reconnection time to
+// new subscribers
+func (s *Server) SetRetry(retry time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.retry = retry
+}
+
+// LastEventIDs returns Last-Event-ID header sent with every subscription,
+// empty string when header was not set
+func (s *Server) LastEventIDs() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.lastEventIDs...)
+}
+
+// SendStatusUpdate publishes status_update_event for provided task
+func (s *Server) SendStatusUpdate(appID marathon.AppID, taskID marathon.TaskID, status string) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"eventType":  "status_update_event",
+		"appId":      appID,
+		"taskId":     taskID,
+		"taskStatus": status,
+		"host":       "agent.example.com",
+		"ports":      []int{},
+		"timestamp":  s.clockNow(),
+	})
+	s.SendEvent("status_update_event", string(data))
+}
+
+// CloseStreams disconnects all /v2/events subscribers
+func (s *Server) CloseStreams() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	close(s.closeStream)
+	s.closeStream = make(chan struct{})
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := "/" + strings.Trim(r.URL.Path, "/")
+
+	switch {
+	case path == "/v2/events":
+		s.handleEvents(w, r)
+	case path == "/v2/leader":
+		s.handleLeader(w, r)
+	case path == "/v2/deployments":
+		s.handleDeployments(w, r)
+	case path == "/v2/queue":
+		s.handleQueue(w, r)
+	case path == "/v2/apps":
+		s.handleApps(w, r)
+	case strings.HasPrefix(path, "/v2/apps/"):
+		s.handleApp(w, r, resourceID(path, "/v2/apps"))
+	case path == "/v2/eventSubscriptions":
+		s.handleEventSubscriptions(w, r)
+	case path == "/v2/tasks/delete":
+		s.handleTasksDelete(w, r)
+	case path == "/v2/groups":
+		s.handleGroups(w, r)
+	case strings.HasPrefix(path, "/v2/groups/"):
+		s.handleGroup(w, r, resourceID(path, "/v2/groups"))
+	default:
+		writeMessage(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (s *Server) handleLeader(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, map[string]string{"leader": s.leader})
+}
+
+func (s *Server) handleDeployments(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.Deployments())
+}
+
+func (s *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	queue := s.queue
+	if queue == nil {
+		queue = []*marathon.QueueItem{}
+	}
+	writeJSON(w, http.StatusOK, marathon.QueueResponse{Queue: queue})
+}
+
+func (s *Server) handleApps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, marathon.AppsResponse{Apps: s.sortedApps()})
+}
+
+func (s *Server) handleApp(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method == "GET" && strings.HasSuffix(path, "/tasks") {
+		s.handleTasks(w, r, marathon.AppID(strings.TrimSuffix(path, "/tasks")))
+		return
+	}
+	appID := marathon.AppID(path)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		writeMessage(w, http.StatusNotFound, fmt.Sprintf("App '%s' does not exist", appID))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, marathon.AppWrapper{App: *copyApp(app)})
+	case "PUT":
+		update := struct {
+			Instances *int              `json:"instances"`
+			Labels    map[string]string `json:"labels"`
+		}{}
+		body, err := s.record(r)
+		if err == nil {
+			err = json.Unmarshal(body, &update)
+		}
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		version := s.tick()
+		if update.Instances != nil && *update.Instances != app.Instances {
+			app.Instances = *update.Instances
+			if len(app.Tasks) > app.Instances {
+				app.Tasks = app.Tasks[:app.Instances]
+			}
+			app.VersionInfo.LastScalingAt = version
+		}
+		if update.Labels != nil {
+			app.Labels = update.Labels
+			app.VersionInfo.LastConfigChangeAt = version
+		}
+		app.Version = version
+		writeJSON(w, http.StatusOK, s.deploy(version, []string{appID.String()}, nil))
+	case "DELETE":
+		_, err := s.record(r)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		delete(s.apps, appID)
+		writeJSON(w, http.StatusOK, s.deploy(s.tick(), []string{appID.String()}, nil))
+	default:
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request, appID marathon.AppID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	app, ok := s.apps[appID]
+	if !ok {
+		writeMessage(w, http.StatusNotFound, fmt.Sprintf("App '%s' does not exist", appID))
+		return
+	}
+	tasks := make([]*marathon.Task, 0, len(app.Tasks))
+	for i := range app.Tasks {
+		task := app.Tasks[i]
+		tasks = append(tasks, &task)
+	}
+	writeJSON(w, http.StatusOK, marathon.TasksResponse{Tasks: tasks})
+}
+
+func (s *Server) handleEventSubscriptions(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	callbackURL := r.URL.Query().Get("callbackUrl")
+	eventType := "subscribe_event"
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, map[string][]string{"callbackUrls": s.callbacks})
+		return
+	case "POST":
+		if _, err := s.record(r); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		registered := false
+		for _, callback := range s.callbacks {
+			registered = registered || callback == callbackURL
+		}
+		if !registered {
+			s.callbacks = append(s.callbacks, callbackURL)
+		}
+	case "DELETE":
+		if _, err := s.record(r); err != nil {
+			writeMessage(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var callbacks []string
+		for _, callback := range s.callbacks {
+			if callback != callbackURL {
+				callbacks = append(callbacks, callback)
+			}
+		}
+		s.callbacks = callbacks
+		eventType = "unsubscribe_event"
+	default:
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"callbackUrl": callbackURL,
+		"clientIp":    r.RemoteAddr,
+		"eventType":   eventType,
+	})
+}
+
+func (s *Server) handleTasksDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	kill := marathon.KillTasksData{}
+	body, err := s.record(r)
+	if err == nil {
+		err = json.Unmarshal(body, &kill)
+	}
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	killed := make(map[marathon.TaskID]bool, len(kill.IDs))
+	for _, id := range kill.IDs {
+		killed[id] = true
+	}
+	scale := r.URL.Query().Get("scale") == "true"
+	version := s.tick()
+	var affected []string
+	for _, sorted := range s.sortedApps() {
+		app := s.apps[sorted.ID]
+		var tasks []marathon.Task
+		for _, task := range app.Tasks {
+			if !killed[task.ID] {
+				tasks = append(tasks, task)
+			}
+		}
+		if len(tasks) == len(app.Tasks) {
+			continue
+		}
+		if scale {
+			app.Instances -= len(app.Tasks) - len(tasks)
+			app.VersionInfo.LastScalingAt = version
+			app.Version = version
+		}
+		app.Tasks = tasks
+		affected = append(affected, app.ID.String())
+	}
+	writeJSON(w, http.StatusOK, s.deploy(version, affected, nil))
+}
+
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusOK, s.rootGroup())
+}
+
+func (s *Server) handleGroup(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method != "DELETE" {
+		writeMessage(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	groupID := marathon.GroupID(path)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.groups[groupID]; !ok {
+		writeMessage(w, http.StatusNotFound, fmt.Sprintf("Group '%s' does not exist", groupID))
+		return
+	}
+	if _, err := s.record(r); err != nil {
+		writeMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	prefix := groupID.String() + "/"
+	for id := range s.groups {
+		if id == groupID || strings.HasPrefix(id.String(), prefix) {
+			delete(s.groups, id)
+		}
+	}
+	for id := range s.apps {
+		if strings.HasPrefix(id.String(), prefix) {
+			delete(s.apps, id)
+		}
+	}
+	writeJSON(w, http.StatusOK, s.deploy(s.tick(), nil, []string{groupID.String()}))
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeMessage(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+
+	frames := make(chan string, 100)
+	lastEventID := r.Header.Get("Last-Event-ID")
+	filter := eventFilter{}
+	for _, eventType := range r.URL.Query()["event_type"] {
+		filter[eventType] = true
+	}
+	s.mutex.Lock()
+	s.subscribers[frames] = filter
+	s.lastEventIDs = append(s.lastEventIDs, lastEventID)
+	closeStream := s.closeStream
+	retry := s.retry
+	// events published after Last-Event-ID are replayed to resumed subscription
+	var missed []string
+	if id, err := strconv.Atoi(lastEventID); err == nil && id >= 0 && id < len(s.history) {
+		for _, e := range s.history[id:] {
+			if filter.accepts(e.eventType) {
+				missed = append(missed, e.frame)
+			}
+		}
+	}
+	s.mutex.Unlock()
+
+	defer func() {
+		s.mutex.Lock()
+		delete(s.subscribers, frames)
+		s.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	attached, _ := json.Marshal(map[string]string{
+		"eventType":     "event_stream_attached",
+		"remoteAddress": r.RemoteAddr,
+	})
+	if retry > 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", retry/time.Millisecond)
+	}
+	fmt.Fprintf(w, "event: event_stream_attached\ndata: %s\n\n", attached)
+	for _, frame := range missed {
+		fmt.Fprint(w, frame)
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case frame := <-frames:
+			fmt.Fprint(w, frame)
+			flusher.Flush()
+		case <-closeStream:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// record stores mutating request, must be called with mutex held
+func (s *Server) record(r *http.Request) ([]byte, error) {
+	body := []byte{}
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Body: body})
+	return body, nil
+}
+
+// deploy registers deployment, must be called with mutex held
+func (s *Server) deploy(version string, apps, groups []string) marathon.ScaleResponse {
+	deployment := Deployment{
+		ID:             fmt.Sprintf("deployment-%d", len(s.deployments)+1),
+		Version:        version,
+		AffectedApps:   apps,
+		AffectedGroups: groups,
+	}
+	s.deployments = append(s.deployments, deployment)
+	return marathon.ScaleResponse{Version: version, DeploymentID: deployment.ID}
+}
+
+// tick advances server clock and returns new version, must be called with
+// mutex held
+func (s *Server) tick() string {
+	s.clock = s.clock.Add(time.Millisecond)
+	return s.clock.Format(versionFormat)
+}
+
+func (s *Server) clockNow() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.clock.Format(versionFormat)
+}
+
+// sortedApps must be called with mutex held
+func (s *Server) sortedApps() []*marathon.App {
+	apps := make([]*marathon.App, 0, len(s.apps))
+	for _, app := range s.apps {
+		apps = append(apps, copyApp(app))
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].ID < apps[j].ID })
+	return apps
+}
+
+// rootGroup builds group tree out of registered groups and applications,
+// must be called with mutex held
+func (s *Server) rootGroup() *marathon.Group {
+	root := &marathon.Group{ID: "/", Apps: []*marathon.App{}, Groups: []*marathon.Group{}}
+	nodes := map[marathon.GroupID]*marathon.Group{"/": root}
+
+	var node func(id marathon.GroupID) *marathon.Group
+	node = func(id marathon.GroupID) *marathon.Group {
+		if group, ok := nodes[id]; ok {
+			return group
+		}
+		group := &marathon.Group{ID: id, Apps: []*marathon.App{}, Groups: []*marathon.Group{}}
+		if registered, ok := s.groups[id]; ok {
+			group.Version = registered.Version
+		}
+		nodes[id] = group
+		parent := node(parentGroup(id.String()))
+		parent.Groups = append(parent.Groups, group)
+		return group
+	}
+
+	ids := make([]string, 0, len(s.groups))
+	for id := range s.groups {
+		ids = append(ids, id.String())
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		node(marathon.GroupID(id))
+	}
+	for _, app := range s.sortedApps() {
+		group := node(parentGroup(app.ID.String()))
+		group.Apps = append(group.Apps, app)
+	}
+	return root
+}
+
+// resourceID extracts application or group id from request path. Like real
+// Marathon, repeated slashes (e.g. /v2/apps//group/app) are tolerated.
+func resourceID(path, prefix string) string {
+	return "/" + strings.TrimLeft(strings.TrimPrefix(path, prefix), "/")
+}
+
+func parentGroup(id string) marathon.GroupID {
+	index := strings.LastIndex(id, "/")
+	if index <= 0 {
+		return "/"
+	}
+	return marathon.GroupID(id[:index])
+}
+
+func copyApp(app *marathon.App) *marathon.App {
+	c := *app
+	if app.Labels != nil {
+		c.Labels = make(map[string]string, len(app.Labels))
+		for k, v := range app.Labels {
+			c.Labels[k] = v
+		}
+	}
+	c.Tasks = append([]marathon.Task(nil), app.Tasks...)
+	return &c
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
@@ -0,0 +1,156 @@
+package marathontest
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerAppScaleDownIsAppliedToState(t *testing.T) {
+	t.Parallel()
+	// given
+	server := NewServer()
+	defer server.Close()
+	server.AddApp(&marathon.App{
+		ID:        "/group/app",
+		Instances: 2,
+		Labels:    map[string]string{"owner": "team"},
+		Tasks:     []marathon.Task{{ID: "group_app.1"}, {ID: "group_app.2"}},
+	})
+	m, err := marathon.New(server.Config())
+	require.NoError(t, err)
+	// when
+	err = m.AppScaleDown(context.Background(), &marathon.App{ID: "/group/app"})
+	// then
+	require.NoError(t, err)
+	app, ok := server.App("/group/app")
+	require.True(t, ok)
+	assert.Equal(t, 1, app.Instances)
+	assert.Len(t, app.Tasks, 1)
+	assert.Equal(t, map[string]string{"owner": "team", "appcop": "scaleDown"}, app.Labels)
+	assert.Len(t, server.Deployments(), 1)
+	assert.Equal(t, []string{"/group/app"}, server.Deployments()[0].AffectedApps)
+}
+
+func TestServerAppDeleteRemovesApp(t *testing.T) {
+	t.Parallel()
+	// given
+	server := NewServer()
+	defer server.Close()
+	server.AddApp(&marathon.App{ID: "/app"})
+	m, _ := marathon.New(server.Config())
+	// when
+	err := m.AppDelete(context.Background(), "/app")
+	// then
+	require.NoError(t, err)
+	_, ok := server.App("/app")
+	assert.False(t, ok)
+	assert.Equal(t, "DELETE", server.Requests()[0].Method)
+}
+
+func TestServerAppGetReturnsErrorForUnknownApp(t *testing.T) {
+	t.Parallel()
+	// given
+	server := NewServer()
+	defer server.Close()
+	m, _ := marathon.New(server.Config())
+	// when
+	_, err := m.AppGet(context.Background(), "/unknown")
+	// then
+	assert.Error(t, err)
+}
+
+func TestServerServesGroupTreeAndDeletesGroups(t *testing.T) {
+	t.Parallel()
+	// given
+	server := NewServer()
+	defer server.Close()
+	server.AddGroup("/a/empty", "2017-01-24T15:37:58.780Z")
+	server.AddApp(&marathon.App{ID: "/a/full/app"})
+	m, _ := marathon.New(server.Config())
+	// when
+	groups, err := m.GetEmptyLeafGroups(context.Background())
+	// then
+	require.NoError(t, err)
+	require.Len(t, groups, 1)
+	assert.Equal(t, marathon.GroupID("/a/empty"), groups[0].ID)
+	assert.Equal(t, "2017-01-24T15:37:58.780Z", groups[0].Version)
+	// when
+	err = m.GroupDelete(context.Background(), "/a/empty")
+	// then
+	require.NoError(t, err)
+	assert.False(t, server.HasGroup("/a/empty"))
+}
+
+func TestServerLeaderDefaultsToOwnLocation(t *testing.T) {
+	t.Parallel()
+	// given
+	server := NewServer()
+	defer server.Close()
+	m, _ := marathon.New(server.Config())
+	// when
+	leader, err := m.LeaderGet(context.Background())
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, server.Location(), leader)
+	// when
+	server.SetLeader("other:8080")
+	leader, _ = m.LeaderGet(context.Background())
+	// then
+	assert.Equal(t, "other:8080", leader)
+}
+
+func TestServerStreamsScriptedEvents(t *testing.T) {
+	t.Parallel()
+	// given
+	server := NewServer()
+	defer server.Close()
+	response, err := http.Get(server.URL() + "/v2/events")
+	require.NoError(t, err)
+	defer response.Body.Close()
+	reader := bufio.NewReader(response.Body)
+	require.True(t, server.WaitForSubscribers(1, time.Second))
+	// when
+	server.SendStatusUpdate("/app", "app.1", "TASK_FAILED")
+	// then
+	var lines []string
+	for len(lines) < 5 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	assert.Equal(t, "event: event_stream_attached", lines[0])
+	assert.Equal(t, "id: 1", lines[2])
+	assert.Equal(t, "event: status_update_event", lines[3])
+	assert.Contains(t, lines[4], `"taskStatus":"TASK_FAILED"`)
+}
+
+func TestServerTasksKillRemovesTasksAndScalesAppDown(t *testing.T) {
+	t.Parallel()
+	// given
+	server := NewServer()
+	defer server.Close()
+	server.AddApp(&marathon.App{
+		ID:        "/app",
+		Instances: 3,
+		Tasks:     []marathon.Task{{ID: "app.1"}, {ID: "app.2"}, {ID: "app.3"}},
+	})
+	m, _ := marathon.New(server.Config())
+	// when
+	err := m.TasksKill(context.Background(), []marathon.TaskID{"app.2"})
+	// then
+	require.NoError(t, err)
+	app, _ := server.App("/app")
+	assert.Equal(t, 2, app.Instances)
+	assert.Equal(t, []marathon.Task{{ID: "app.1"}, {ID: "app.3"}}, app.Tasks)
+	assert.Equal(t, []string{"/app"}, server.Deployments()[0].AffectedApps)
+}
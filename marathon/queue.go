@@ -0,0 +1,74 @@
+package marathon
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// QueueResponse json returned from marathon /v2/queue endpoint
+type QueueResponse struct {
+	Queue []*QueueItem `json:"queue"`
+}
+
+// QueueItem represents application waiting in marathon launch queue
+type QueueItem struct {
+	App                    App                    `json:"app"`
+	Count                  int                    `json:"count"`
+	Delay                  QueueDelay             `json:"delay"`
+	Since                  string                 `json:"since"`
+	ProcessedOffersSummary ProcessedOffersSummary `json:"processedOffersSummary"`
+}
+
+// QueueDelay describes backoff applied to application launch
+type QueueDelay struct {
+	// Overdue is true when launch delay passed and application is still
+	// waiting for matching offer
+	Overdue         bool `json:"overdue"`
+	TimeLeftSeconds int  `json:"timeLeftSeconds"`
+}
+
+// ProcessedOffersSummary summarizes offers marathon considered for
+// application launch
+type ProcessedOffersSummary struct {
+	ProcessedOffersCount    int                  `json:"processedOffersCount"`
+	UnusedOffersCount       int                  `json:"unusedOffersCount"`
+	LastUnusedOfferAt       string               `json:"lastUnusedOfferAt"`
+	LastUsedOfferAt         string               `json:"lastUsedOfferAt"`
+	RejectSummaryLastOffers []OfferRejectSummary `json:"rejectSummaryLastOffers"`
+}
+
+// OfferRejectSummary tells how many offers were declined for given reason
+type OfferRejectSummary struct {
+	Reason    string `json:"reason"`
+	Declined  int    `json:"declined"`
+	Processed int    `json:"processed"`
+}
+
+// IsStuck checks if application is waiting past its launch delay or offers
+// are not matching its requirements
+func (q *QueueItem) IsStuck() bool {
+	return q.Delay.Overdue || q.ProcessedOffersSummary.UnusedOffersCount > 0
+}
+
+// SinceTime returns time when application was put into launch queue
+func (q *QueueItem) SinceTime() (time.Time, error) {
+	return time.Parse(time.RFC3339, q.Since)
+}
+
+// DeclineReasons returns number of declined offers by reason
+func (q *QueueItem) DeclineReasons() map[string]int {
+	reasons := make(map[string]int)
+	for _, summary := range q.ProcessedOffersSummary.RejectSummaryLastOffers {
+		if summary.Declined > 0 {
+			reasons[summary.Reason] = summary.Declined
+		}
+	}
+	return reasons
+}
+
+// ParseQueue json
+func ParseQueue(jsonBlob []byte) ([]*QueueItem, error) {
+	queue := &QueueResponse{}
+	err := json.Unmarshal(jsonBlob, queue)
+	return queue.Queue, err
+}
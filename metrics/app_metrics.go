@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// overflowMetric is marked instead of application meters above limit
+	overflowMetric = "overflow"
+	appSeriesGauge = "metrics.app.series"
+	// maxSweepInterval bounds how long idle meter outlives its TTL
+	maxSweepInterval = time.Minute
+)
+
+// Kinds of labeled application metrics
+const (
+	// KindAppStatus counts task statuses of application
+	KindAppStatus = "app_status"
+	// KindAppScore is current score of application
+	KindAppScore = "app_score"
+	// KindGroupPenalties counts penalties taken against applications in
+	// group
+	KindGroupPenalties = "group_penalties"
+)
+
+// appLabels describe application metric for targets supporting labels,
+// metrics of the same kind are exposed as single metric with different
+// label values
+type appLabels struct {
+	kind string
+	// pairs of label name and value
+	pairs []string
+}
+
+func (l appLabels) get(name string) (string, bool) {
+	for i := 0; i+1 < len(l.pairs); i += 2 {
+		if l.pairs[i] == name {
+			return l.pairs[i+1], true
+		}
+	}
+	return "", false
+}
+
+// appSeries tracks application meters registered with MarkApp, so idle ones
+// can be unregistered and their number limited
+var appSeries = struct {
+	sync.RWMutex
+	lastUpdate map[string]time.Time
+	// labels of metrics updated with labels
+	labels map[string]appLabels
+	// limit of tracked meters, 0 means no limit
+	limit int
+	// stop ends sweeper started by previous Init
+	stop func()
+}{
+	lastUpdate: make(map[string]time.Time),
+	labels:     make(map[string]appLabels),
+}
+
+// startAppSeries starts sweeper expiring idle meters, when ttl is set
+func startAppSeries(ttl time.Duration, limit int) {
+	appSeries.Lock()
+	defer appSeries.Unlock()
+	appSeries.limit = limit
+	if ttl <= 0 {
+		return
+	}
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sweepAppSeries(ttl, quit)
+	}()
+	appSeries.stop = func() {
+		close(quit)
+		<-done
+	}
+}
+
+// stopAppSeries stops sweeper and waits until sweep in progress is finished
+func stopAppSeries() {
+	appSeries.Lock()
+	stop := appSeries.stop
+	appSeries.stop = nil
+	appSeries.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+func sweepAppSeries(ttl time.Duration, quit <-chan struct{}) {
+	interval := ttl / 2
+	if interval > maxSweepInterval {
+		interval = maxSweepInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case now := <-ticker.C:
+			expireAppSeries(metrics.DefaultRegistry, now.Add(-ttl))
+		}
+	}
+}
+
+// expireAppSeries unregisters application meters not updated since deadline
+func expireAppSeries(registry metrics.Registry, deadline time.Time) {
+	appSeries.Lock()
+	defer appSeries.Unlock()
+	for name, lastUpdate := range appSeries.lastUpdate {
+		if lastUpdate.Before(deadline) {
+			registry.Unregister(name)
+			delete(appSeries.lastUpdate, name)
+			delete(appSeries.labels, name)
+		}
+	}
+	UpdateGauge(appSeriesGauge, int64(len(appSeries.lastUpdate)))
+}
+
+// trackApp records update of application meter, returns false when meter is
+// not tracked yet and limit is reached
+func trackApp(name string, labels *appLabels) bool {
+	appSeries.Lock()
+	defer appSeries.Unlock()
+	_, tracked := appSeries.lastUpdate[name]
+	if !tracked && appSeries.limit > 0 && len(appSeries.lastUpdate) >= appSeries.limit {
+		return false
+	}
+	appSeries.lastUpdate[name] = time.Now()
+	if labels != nil && !tracked {
+		appSeries.labels[name] = *labels
+	}
+	if !tracked {
+		UpdateGauge(appSeriesGauge, int64(len(appSeries.lastUpdate)))
+	}
+	return true
+}
+
+func labelsOf(name string) (appLabels, bool) {
+	appSeries.RLock()
+	defer appSeries.RUnlock()
+	labels, ok := appSeries.labels[name]
+	return labels, ok
+}
+
+func markApp(name string, labels *appLabels) {
+	name = appMetric(name)
+	if !trackApp(name, labels) {
+		name = appMetric(overflowMetric)
+	}
+	metrics.GetOrRegisterMeter(name, metrics.DefaultRegistry).Mark(1)
+}
+
+func updateAppGauge(name string, value int64, labels *appLabels) {
+	name = appMetric(name)
+	if !trackApp(name, labels) {
+		// gauges can not be summed up, overflow counts dropped updates
+		metrics.GetOrRegisterMeter(appMetric(overflowMetric), metrics.DefaultRegistry).Mark(1)
+		return
+	}
+	metrics.GetOrRegisterGauge(name, metrics.DefaultRegistry).Update(value)
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetAppSeries() {
+	appSeries.Lock()
+	defer appSeries.Unlock()
+	appSeries.lastUpdate = make(map[string]time.Time)
+	appSeries.labels = make(map[string]appLabels)
+}
+
+func appSeriesCount() int64 {
+	gauge, _ := metrics.Get(systemMetric(appSeriesGauge)).(metrics.Gauge)
+	return gauge.Value()
+}
+
+func TestMarkAppAboveLimitMarksOverflow(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{AppSubPrefix: "limited", AppMetricsLimit: 2}))
+	defer startAppSeries(0, 0)
+	resetAppSeries()
+	metrics.Unregister("limited." + overflowMetric)
+	metrics.Unregister("limited.first.task_failed")
+
+	// when
+	MarkApp("first.task_failed")
+	MarkAppStatus("second.task_failed", "/second", "task_failed")
+	MarkAppStatus("third.task_failed", "/third", "task_failed")
+	MarkApp("third.task_killed")
+	MarkApp("first.task_failed")
+
+	// then
+	assert.Nil(t, metrics.Get("limited.third.task_failed"))
+	assert.Nil(t, metrics.Get("limited.third.task_killed"))
+	_, labeled := labelsOf("limited.third.task_failed")
+	assert.False(t, labeled)
+	overflow, _ := metrics.Get("limited." + overflowMetric).(metrics.Meter)
+	require.NotNil(t, overflow)
+	assert.Equal(t, int64(2), overflow.Count())
+	first, _ := metrics.Get("limited.first.task_failed").(metrics.Meter)
+	assert.Equal(t, int64(2), first.Count(), "tracked meters are marked above limit")
+	assert.Equal(t, int64(2), appSeriesCount())
+}
+
+func TestExpireAppSeriesUnregistersIdleMeters(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{AppSubPrefix: "idle"}))
+	resetAppSeries()
+	MarkAppStatus("old.task_failed", "/old", "task_failed")
+	deadline := time.Now().Add(time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	MarkApp("fresh.task_failed")
+
+	// when
+	expireAppSeries(metrics.DefaultRegistry, deadline)
+
+	// then
+	assert.Nil(t, metrics.Get("idle.old.task_failed"))
+	_, labeled := labelsOf("idle.old.task_failed")
+	assert.False(t, labeled)
+	assert.NotNil(t, metrics.Get("idle.fresh.task_failed"))
+	assert.Equal(t, int64(1), appSeriesCount())
+
+	// when
+	MarkAppStatus("old.task_failed", "/old", "task_failed")
+	// then
+	old, _ := metrics.Get("idle.old.task_failed").(metrics.Meter)
+	require.NotNil(t, old)
+	assert.Equal(t, int64(1), old.Count(), "expired meter starts over")
+}
+
+func TestSweeperExpiresMetersAfterTTL(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{AppSubPrefix: "swept", AppMetricsTTL: 5 * time.Millisecond}))
+	defer stopAppSeries()
+	MarkApp("app.task_failed")
+
+	// when
+	for i := 0; i < 100 && metrics.Get("swept.app.task_failed") != nil; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// then
+	assert.Nil(t, metrics.Get("swept.app.task_failed"))
+}
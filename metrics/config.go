@@ -20,4 +20,13 @@ type Config struct {
 	// main Prefix, representing applications specific metric, e.g task_running,
 	// task_staging, task_failed.
 	AppSubPrefix string
+	// AppMetricsTTL is how long application meter is kept without updates,
+	// 0 keeps meters forever
+	AppMetricsTTL time.Duration
+	// AppMetricsLimit caps number of application meters, updates of meters
+	// above limit are counted by overflow meter. 0 means no limit.
+	AppMetricsLimit int
+	// DogStatsD enables tags in StatsD datagrams, application id and task
+	// status are sent as tags instead of metric name segments.
+	DogStatsD bool
 }
@@ -0,0 +1,213 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// influx writes registry in InfluxDB line protocol. Every metric is
+// measurement tagged with instance host, labeled application metrics of the
+// same kind are single measurement tagged with labels and application group.
+type influx struct {
+	host string
+	send func(lines []byte) error
+}
+
+func initInflux(addr string, interval time.Duration) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("metrics: invalid InfluxDB address %s", redactURL(addr))
+	}
+
+	var send func([]byte) error
+	switch u.Scheme {
+	case "http", "https":
+		send = influxHTTP(addr, &http.Client{Timeout: interval})
+	case "udp":
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return fmt.Errorf("metrics: cannot connect to InfluxDB: %s", err)
+		}
+		send = influxUDP(conn)
+	default:
+		return fmt.Errorf("metrics: InfluxDB address should be http(s):// write URL or udp://host:port, got %s", redactURL(addr))
+	}
+
+	i := &influx{host: instance, send: send}
+	go func() {
+		for now := range time.Tick(interval) {
+			if err := i.flush(metrics.DefaultRegistry, now); err != nil {
+				log.WithError(err).Warn("Unable to send metrics to InfluxDB")
+			}
+		}
+	}()
+	return nil
+}
+
+// redactURL strips user info and query, which may carry InfluxDB credentials
+// (u and p parameters), so address can be logged
+func redactURL(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "<invalid URL>"
+	}
+	u.User = nil
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// influxHTTP posts lines to write endpoint, e.g.
+// http://localhost:8086/write?db=appcop
+func influxHTTP(writeURL string, client *http.Client) func([]byte) error {
+	return func(lines []byte) error {
+		res, err := client.Post(writeURL, "text/plain; charset=utf-8", bytes.NewReader(lines))
+		if err != nil {
+			// error quotes URL, which may carry credentials
+			if urlErr, ok := err.(*url.Error); ok {
+				urlErr.URL = redactURL(urlErr.URL)
+			}
+			return err
+		}
+		defer res.Body.Close()
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		if res.StatusCode/100 != 2 {
+			return fmt.Errorf("influxdb write returned %d", res.StatusCode)
+		}
+		return nil
+	}
+}
+
+// influxUDP sends lines in datagrams not exceeding maxPacketSize, splitting
+// them on line boundaries
+func influxUDP(conn net.Conn) func([]byte) error {
+	return func(lines []byte) error {
+		for len(lines) > 0 {
+			n := len(lines)
+			if n > maxPacketSize {
+				n = bytes.LastIndexByte(lines[:maxPacketSize], '\n') + 1
+				if n == 0 {
+					// single line above limit is sent whole
+					n = bytes.IndexByte(lines, '\n') + 1
+				}
+			}
+			if _, err := conn.Write(lines[:n]); err != nil {
+				return err
+			}
+			lines = lines[n:]
+		}
+		return nil
+	}
+}
+
+func (i *influx) flush(registry metrics.Registry, now time.Time) error {
+	names := []string{}
+	all := make(map[string]interface{})
+	registry.Each(func(name string, metric interface{}) {
+		names = append(names, name)
+		all[name] = metric
+	})
+	sort.Strings(names)
+
+	timestamp := strconv.FormatInt(now.UnixNano(), 10)
+	lines := bytes.Buffer{}
+	for _, name := range names {
+		measurement := trimSystemPrefix(name)
+		tags := []string{"host", i.host}
+		if labels, ok := labelsOf(name); ok {
+			measurement = labels.kind
+			tags = influxTags(labels, i.host)
+		}
+		var fields string
+
+		switch m := all[name].(type) {
+		case metrics.Meter:
+			fields = "count=" + formatInt(m.Count()) + ",m1=" + formatFloat(m.Rate1())
+		case metrics.Counter:
+			fields = "value=" + formatInt(m.Count())
+		case metrics.Gauge:
+			fields = "value=" + formatInt(m.Value())
+		case metrics.GaugeFloat64:
+			fields = "value=" + formatFloat(m.Value())
+		case metrics.Timer:
+			t := m.Snapshot()
+			// timers record nanoseconds, fields are in milliseconds
+			fields = distributionFields(t.Count(), t.Mean(), float64(t.Max()), t.Percentiles([]float64{0.5, 0.95, 0.99}), 1e6)
+		case metrics.Histogram:
+			h := m.Snapshot()
+			fields = distributionFields(h.Count(), h.Mean(), float64(h.Max()), h.Percentiles([]float64{0.5, 0.95, 0.99}), 1)
+		default:
+			continue
+		}
+
+		lines.WriteString(influxMeasurementEscaper.Replace(measurement))
+		for t := 0; t < len(tags); t += 2 {
+			// empty tag values are not allowed
+			if tags[t+1] != "" {
+				lines.WriteString("," + tags[t] + "=" + influxTagEscaper.Replace(tags[t+1]))
+			}
+		}
+		lines.WriteString(" " + fields + " " + timestamp + "\n")
+	}
+
+	if lines.Len() == 0 {
+		return nil
+	}
+	return i.send(lines.Bytes())
+}
+
+// influxTags returns label pairs with host and group of application, sorted
+// by key as InfluxDB writes them faster
+func influxTags(labels appLabels, host string) []string {
+	tags := map[string]string{"host": host}
+	for i := 0; i+1 < len(labels.pairs); i += 2 {
+		tags[labels.pairs[i]] = labels.pairs[i+1]
+	}
+	if app, ok := labels.get("app"); ok {
+		if _, ok := tags["group"]; !ok {
+			tags["group"] = path.Dir(app)
+		}
+	}
+	keys := []string{}
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, key := range keys {
+		pairs = append(pairs, key, tags[key])
+	}
+	return pairs
+}
+
+func distributionFields(count int64, mean, max float64, percentiles []float64, unit float64) string {
+	return "count=" + formatInt(count) +
+		",mean=" + formatFloat(mean/unit) +
+		",max=" + formatFloat(max/unit) +
+		",p50=" + formatFloat(percentiles[0]/unit) +
+		",p95=" + formatFloat(percentiles[1]/unit) +
+		",p99=" + formatFloat(percentiles[2]/unit)
+}
+
+// formatInt formats integer field, i suffix distinguishes it from float
+func formatInt(value int64) string {
+	return strconv.FormatInt(value, 10) + "i"
+}
@@ -21,6 +21,8 @@ const (
 	PathSeparator = "/"
 	// MetricSeparator is separator of groups in metrics system
 	MetricSeparator = "."
+	// histogramSize is number of values UpdateHistogram keeps
+	histogramSize = 10000
 )
 
 var (
@@ -60,13 +62,45 @@ func Mark(name string) {
 	meter.Mark(1)
 }
 
-// MarkApp marks or register Meter on graphite
+// MarkApp marks or register Meter on graphite. Meter is unregistered when
+// it was not marked for AppMetricsTTL, above AppMetricsLimit overflow meter
+// is marked instead.
 func MarkApp(name string) {
-	meter := metrics.GetOrRegisterMeter(
-		appMetric(name),
+	markApp(name, nil)
+}
+
+// MarkAppStatus marks or register Meter of application status, name is used
+// by graphite while Prometheus exposes it as series labeled with app and
+// status
+func MarkAppStatus(name, app, status string) {
+	MarkAppLabeled(name, KindAppStatus, "app", app, "status", strings.ToLower(status))
+}
+
+// MarkAppLabeled marks or register application Meter, name is used by
+// graphite while targets supporting labels expose meters of the same kind as
+// single metric with label name and value pairs
+func MarkAppLabeled(name, kind string, labels ...string) {
+	markApp(name, &appLabels{kind: kind, pairs: labels})
+}
+
+// UpdateAppGauge updates application Gauge, it is exposed like meters of
+// MarkAppLabeled
+func UpdateAppGauge(name, kind string, value int64, labels ...string) {
+	updateAppGauge(name, value, &appLabels{kind: kind, pairs: labels})
+}
+
+// UpdateHistogram replaces values of histogram, so it shows current
+// distribution instead of all values ever recorded
+func UpdateHistogram(name string, values []int64) {
+	histogram := metrics.GetOrRegisterHistogram(
+		systemMetric(name),
 		metrics.DefaultRegistry,
+		metrics.NewUniformSample(histogramSize),
 	)
-	meter.Mark(1)
+	histogram.Clear()
+	for _, value := range values {
+		histogram.Update(value)
+	}
 }
 
 // Time execution of function
@@ -78,6 +112,15 @@ func Time(name string, function func()) {
 	timer.Time(function)
 }
 
+// UpdateTimer records duration of event that already happened
+func UpdateTimer(name string, duration time.Duration) {
+	timer := metrics.GetOrRegisterTimer(
+		systemMetric(name),
+		metrics.DefaultRegistry,
+	)
+	timer.Update(duration)
+}
+
 // UpdateGauge for provided metric
 func UpdateGauge(name string, value int64) {
 	gauge := metrics.GetOrRegisterGauge(
@@ -89,6 +132,8 @@ func UpdateGauge(name string, value int64) {
 
 // Init Metrics
 func Init(cfg Config) error {
+	// sweeper of previous Init reads prefixes
+	stopAppSeries()
 	prefix = cfg.Prefix
 	if prefix == "default" {
 		pfx, err := defaultPrefix()
@@ -109,6 +154,7 @@ func Init(cfg Config) error {
 
 	systemSubPrefix = cfg.SystemSubPrefix
 	appSubPrefix = cfg.AppSubPrefix
+	startAppSeries(cfg.AppMetricsTTL, cfg.AppMetricsLimit)
 
 	collectSystemMetrics()
 
@@ -123,6 +169,23 @@ func Init(cfg Config) error {
 
 		log.Infof("Sending metrics to Graphite on %s as %q", cfg.Addr, prefix)
 		return initGraphite(cfg.Addr, cfg.Interval)
+	case "statsd":
+		if cfg.Addr == "" {
+			return errors.New("metrics: statsd addr missing")
+		}
+
+		log.Infof("Sending metrics to StatsD on %s as %q", cfg.Addr, prefix)
+		return initStatsd(cfg.Addr, cfg.Interval, cfg.DogStatsD)
+	case "influx":
+		if cfg.Addr == "" {
+			return errors.New("metrics: influx addr missing")
+		}
+
+		log.Infof("Sending metrics to InfluxDB on %s", redactURL(cfg.Addr))
+		return initInflux(cfg.Addr, cfg.Interval)
+	case "prometheus":
+		log.Infof("Metrics exposed for Prometheus on %s", PrometheusPath)
+		return nil
 	case "":
 		log.Infof("Metrics disabled")
 		return nil
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/assert"
@@ -171,6 +172,24 @@ func TestTime(t *testing.T) {
 	assert.Equal(t, int64(2), time.Count())
 }
 
+func TestUpdateTimer(t *testing.T) {
+	// given
+	err := Init(Config{Target: "stdout", Prefix: ""})
+	systemTimer := systemMetric("updatedTimer")
+
+	// expect
+	assert.Nil(t, metrics.Get(systemTimer))
+
+	// when
+	UpdateTimer("updatedTimer", 2*time.Second)
+
+	// then
+	timer, _ := metrics.Get(systemTimer).(metrics.Timer)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), timer.Count())
+	assert.Equal(t, int64(2*time.Second), timer.Max())
+}
+
 func TestUpdateGauge(t *testing.T) {
 	// given
 	err := Init(Config{Target: "stdout", Prefix: ""})
@@ -194,6 +213,42 @@ func TestUpdateGauge(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestUpdateHistogramReplacesValues(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{}))
+	UpdateHistogram("distribution", []int64{100, 200})
+
+	// when
+	UpdateHistogram("distribution", []int64{1, 2, 3})
+
+	// then
+	histogram := metrics.Get(systemMetric("distribution")).(metrics.Histogram)
+	assert.Equal(t, int64(3), histogram.Count())
+	assert.Equal(t, int64(3), histogram.Max())
+}
+
+func TestUpdateAppGaugeAboveLimitMarksOverflow(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{AppSubPrefix: "gauges", AppMetricsLimit: 1}))
+	defer startAppSeries(0, 0)
+	resetAppSeries()
+	metrics.Unregister("gauges." + overflowMetric)
+
+	// when
+	UpdateAppGauge("first.score", KindAppScore, 3, "app", "/first")
+	UpdateAppGauge("second.score", KindAppScore, 5, "app", "/second")
+	UpdateAppGauge("first.score", KindAppScore, 4, "app", "/first")
+
+	// then
+	gauge := metrics.Get("gauges.first.score").(metrics.Gauge)
+	assert.Equal(t, int64(4), gauge.Value())
+	labels, _ := labelsOf("gauges.first.score")
+	assert.Equal(t, appLabels{kind: KindAppScore, pairs: []string{"app", "/first"}}, labels)
+	assert.Nil(t, metrics.Get("gauges.second.score"))
+	overflow := metrics.Get("gauges." + overflowMetric).(metrics.Meter)
+	assert.Equal(t, int64(1), overflow.Count())
+}
+
 func TestMetricsInit_ForGraphiteWithNoAddress(t *testing.T) {
 	err := Init(Config{Target: "graphite", Addr: ""})
 	assert.Error(t, err)
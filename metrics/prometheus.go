@@ -0,0 +1,161 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+)
+
+// PrometheusPath is where metrics are exposed in Prometheus format
+const PrometheusPath = "/metrics"
+
+const prometheusNamespace = "appcop"
+
+var quantiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// PrometheusHandler exposes metrics from default registry in Prometheus text
+// format
+func PrometheusHandler() http.Handler {
+	return prometheusHandler(metrics.DefaultRegistry)
+}
+
+func prometheusHandler(registry metrics.Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		out := bufio.NewWriter(w)
+		writePrometheus(out, registry)
+		if err := out.Flush(); err != nil {
+			log.WithError(err).Warn("Unable to write metrics")
+		}
+	})
+}
+
+// writePrometheus writes every metric as separate family, except
+// application meters which are written as single labeled family
+func writePrometheus(out *bufio.Writer, registry metrics.Registry) {
+	names := []string{}
+	all := make(map[string]interface{})
+	registry.Each(func(name string, metric interface{}) {
+		names = append(names, name)
+		all[name] = metric
+	})
+	sort.Strings(names)
+
+	written := make(map[string]bool)
+	// labeled samples are written after other metrics, grouped by family
+	labeled := make(map[string][]string)
+	labeledTypes := make(map[string]string)
+	for _, name := range names {
+		metric := all[name]
+		if labels, ok := labelsOf(name); ok {
+			if family, kind, sample, ok := labeledSample(labels, metric); ok {
+				labeled[family] = append(labeled[family], sample)
+				labeledTypes[family] = kind
+				continue
+			}
+		}
+
+		family := prometheusName(name)
+		// different dotted names may collide once sanitized
+		if written[family] {
+			continue
+		}
+		written[family] = true
+
+		switch m := metric.(type) {
+		case metrics.Meter:
+			writeFamily(out, family+"_total", "counter")
+			fmt.Fprintf(out, "%s_total %d\n", family, m.Count())
+		case metrics.Counter:
+			writeFamily(out, family, "gauge")
+			fmt.Fprintf(out, "%s %d\n", family, m.Count())
+		case metrics.Gauge:
+			writeFamily(out, family, "gauge")
+			fmt.Fprintf(out, "%s %d\n", family, m.Value())
+		case metrics.GaugeFloat64:
+			writeFamily(out, family, "gauge")
+			fmt.Fprintf(out, "%s %g\n", family, m.Value())
+		case metrics.Timer:
+			t := m.Snapshot()
+			// timers record nanoseconds, Prometheus convention is seconds
+			writeSummary(out, family+"_seconds", t.Percentiles(quantiles), 1e9)
+		case metrics.Histogram:
+			h := m.Snapshot()
+			writeSummary(out, family, h.Percentiles(quantiles), 1)
+		}
+	}
+
+	families := []string{}
+	for family := range labeled {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	for _, family := range families {
+		writeFamily(out, family, labeledTypes[family])
+		for _, sample := range labeled[family] {
+			fmt.Fprintln(out, sample)
+		}
+	}
+}
+
+// labeledSample formats meter or gauge as sample of family named after kind
+// of labels
+func labeledSample(labels appLabels, metric interface{}) (family, kind, sample string, ok bool) {
+	var value int64
+	switch m := metric.(type) {
+	case metrics.Meter:
+		family, kind, value = prometheusNamespace+"_"+labels.kind+"_total", "counter", m.Count()
+	case metrics.Gauge:
+		family, kind, value = prometheusNamespace+"_"+labels.kind, "gauge", m.Value()
+	default:
+		return "", "", "", false
+	}
+	pairs := []string{}
+	for i := 0; i+1 < len(labels.pairs); i += 2 {
+		pairs = append(pairs, labels.pairs[i]+`="`+escape(labels.pairs[i+1])+`"`)
+	}
+	return family, kind, fmt.Sprintf("%s{%s} %d", family, strings.Join(pairs, ","), value), true
+}
+
+func writeFamily(out *bufio.Writer, family, kind string) {
+	fmt.Fprintf(out, "# TYPE %s %s\n", family, kind)
+}
+
+// writeSummary writes quantiles only, sum is known only for values kept in
+// sample reservoir and would not match all time count
+func writeSummary(out *bufio.Writer, family string, values []float64, unit float64) {
+	writeFamily(out, family, "summary")
+	for i, q := range quantiles {
+		fmt.Fprintf(out, "%s{quantile=\"%g\"} %g\n", family, q, values[i]/unit)
+	}
+}
+
+// prometheusName strips system prefix (it contains instance, which is
+// Prometheus target label) and replaces separators with underscores
+func prometheusName(name string) string {
+	return prometheusNamespace + "_" + invalidNameChars.ReplaceAllString(trimSystemPrefix(name), "_")
+}
+
+// trimSystemPrefix removes system sub prefix and instance from name, for
+// targets reporting instance as label
+func trimSystemPrefix(name string) string {
+	if system := systemMetric(""); system != "" {
+		return strings.TrimPrefix(name, system+MetricSeparator)
+	}
+	return name
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape label value as required by Prometheus text format
+func escape(value string) string {
+	return labelEscaper.Replace(value)
+}
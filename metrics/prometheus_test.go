@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusHandlerExposesRegistry(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{SystemSubPrefix: "internal", Instance: "host", AppSubPrefix: "applications"}))
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterMeter(systemMetric("events.callback"), registry).Mark(3)
+	metrics.GetOrRegisterGauge(systemMetric("queue.stuck"), registry).Update(2)
+	metrics.GetOrRegisterTimer(systemMetric("marathon.get"), registry).Update(2 * time.Second)
+	trackApp(appMetric("com.example.app.task_failed"), &appLabels{kind: KindAppStatus, pairs: []string{"app", "/com.example/app", "status", "task_failed"}})
+	metrics.GetOrRegisterMeter(appMetric("com.example.app.task_failed"), registry).Mark(1)
+	trackApp(appMetric("quoted.task_killed"), &appLabels{kind: KindAppStatus, pairs: []string{"app", `/quo"ted`, "status", "task_killed"}})
+	metrics.GetOrRegisterMeter(appMetric("quoted.task_killed"), registry).Mark(2)
+	metrics.GetOrRegisterMeter(appMetric("unlabeled"), registry).Mark(1)
+	trackApp(appMetric("com.example.app.score"), &appLabels{kind: KindAppScore, pairs: []string{"app", "/com.example/app"}})
+	metrics.GetOrRegisterGauge(appMetric("com.example.app.score"), registry).Update(7)
+	recorder := httptest.NewRecorder()
+
+	// when
+	prometheusHandler(registry).ServeHTTP(recorder, httptest.NewRequest("GET", PrometheusPath, nil))
+
+	// then
+	body := recorder.Body.String()
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "version=0.0.4")
+	assert.Contains(t, body, "# TYPE appcop_events_callback_total counter\nappcop_events_callback_total 3\n")
+	assert.Contains(t, body, "# TYPE appcop_queue_stuck gauge\nappcop_queue_stuck 2\n")
+	assert.Contains(t, body, "# TYPE appcop_marathon_get_seconds summary\n")
+	assert.Contains(t, body, "appcop_marathon_get_seconds{quantile=\"0.5\"} 2\n")
+	assert.NotContains(t, body, "appcop_marathon_get_seconds_sum")
+	assert.NotContains(t, body, "appcop_marathon_get_seconds_count")
+	assert.Contains(t, body, "appcop_applications_unlabeled_total 1\n")
+	assert.Contains(t, body, "# TYPE appcop_app_status_total counter\n"+
+		"appcop_app_status_total{app=\"/com.example/app\",status=\"task_failed\"} 1\n"+
+		"appcop_app_status_total{app=\"/quo\\\"ted\",status=\"task_killed\"} 2\n")
+	assert.Contains(t, body, "# TYPE appcop_app_score gauge\nappcop_app_score{app=\"/com.example/app\"} 7\n")
+	assert.Equal(t, 1, strings.Count(body, "# TYPE appcop_app_status_total"))
+	assert.NotContains(t, body, "host")
+}
+
+func TestPrometheusNameSkipsCollidingFamilies(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{Instance: "host"}))
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterGauge(systemMetric("a.b"), registry).Update(1)
+	metrics.GetOrRegisterGauge(systemMetric("a_b"), registry).Update(2)
+	recorder := httptest.NewRecorder()
+
+	// when
+	prometheusHandler(registry).ServeHTTP(recorder, httptest.NewRequest("GET", PrometheusPath, nil))
+
+	// then
+	assert.Equal(t, "# TYPE appcop_a_b gauge\nappcop_a_b 1\n", recorder.Body.String())
+}
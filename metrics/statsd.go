@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rcrowley/go-metrics"
+)
+
+// maxPacketSize keeps datagrams below common MTU, so they are not
+// fragmented
+const maxPacketSize = 1432
+
+var (
+	statsdNameEscaper = strings.NewReplacer(":", "_", "|", "_", "@", "_", "#", "_", " ", "_")
+	statsdTagEscaper  = strings.NewReplacer(",", "_", "|", "_", " ", "_")
+)
+
+// statsd flushes registry as StatsD datagrams. Meters are sent as deltas
+// since previous flush, timers and histograms as gauges of their
+// percentiles. With dogStatsD labeled application metrics of the same kind
+// are sent as single metric with tags.
+type statsd struct {
+	conn      net.Conn
+	prefix    string
+	dogStatsD bool
+	// counts keeps meter counts sent with previous flush
+	counts map[string]int64
+	packet bytes.Buffer
+}
+
+func initStatsd(addr string, interval time.Duration, dogStatsD bool) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return fmt.Errorf("metrics: cannot connect to StatsD: %s", err)
+	}
+	s := newStatsd(conn, prefix, dogStatsD)
+	go func() {
+		for range time.Tick(interval) {
+			if err := s.flush(metrics.DefaultRegistry); err != nil {
+				log.WithError(err).Warn("Unable to send metrics to StatsD")
+			}
+		}
+	}()
+	return nil
+}
+
+func newStatsd(conn net.Conn, prefix string, dogStatsD bool) *statsd {
+	return &statsd{conn: conn, prefix: prefix, dogStatsD: dogStatsD, counts: make(map[string]int64)}
+}
+
+func (s *statsd) flush(registry metrics.Registry) error {
+	names := []string{}
+	all := make(map[string]interface{})
+	registry.Each(func(name string, metric interface{}) {
+		names = append(names, name)
+		all[name] = metric
+	})
+	sort.Strings(names)
+
+	previous := s.counts
+	// meters unregistered since previous flush are forgotten
+	s.counts = make(map[string]int64, len(previous))
+	// flush goes on after failed write, so baselines of all meters are
+	// carried forward and only first error is returned
+	var firstErr error
+	for _, name := range names {
+		var err error
+		switch m := all[name].(type) {
+		case metrics.Meter:
+			err = s.sendMeter(name, m.Count(), previous)
+		case metrics.Counter:
+			err = s.send(name, strconv.FormatInt(m.Count(), 10), "g", "")
+		case metrics.Gauge:
+			gaugeName, tags := s.tagged(name)
+			err = s.send(gaugeName, strconv.FormatInt(m.Value(), 10), "g", tags)
+		case metrics.GaugeFloat64:
+			err = s.send(name, formatFloat(m.Value()), "g", "")
+		case metrics.Timer:
+			t := m.Snapshot()
+			// timers record nanoseconds, StatsD convention is milliseconds
+			err = s.sendDistribution(name, t.Count(), t.Mean(), float64(t.Max()), t.Percentiles([]float64{0.5, 0.95, 0.99}), 1e6, previous)
+		case metrics.Histogram:
+			h := m.Snapshot()
+			err = s.sendDistribution(name, h.Count(), h.Mean(), float64(h.Max()), h.Percentiles([]float64{0.5, 0.95, 0.99}), 1, previous)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := s.writePacket(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+func (s *statsd) sendMeter(name string, count int64, previous map[string]int64) error {
+	delta := count - previous[name]
+	// meter was unregistered and registered again
+	if delta < 0 {
+		delta = count
+	}
+	s.counts[name] = count
+	if delta == 0 {
+		return nil
+	}
+	name, tags := s.tagged(name)
+	return s.send(name, strconv.FormatInt(delta, 10), "c", tags)
+}
+
+// tagged returns name and tags of labeled application metric with
+// DogStatsD, otherwise name is returned unchanged
+func (s *statsd) tagged(name string) (string, string) {
+	labels, ok := labelsOf(name)
+	if !ok || !s.dogStatsD {
+		return name, ""
+	}
+	tags := []string{}
+	for i := 0; i+1 < len(labels.pairs); i += 2 {
+		tags = append(tags, labels.pairs[i]+":"+statsdTagEscaper.Replace(labels.pairs[i+1]))
+	}
+	kindName := strings.Join(FilterOutEmptyStrings([]string{appSubPrefix, labels.kind}), MetricSeparator)
+	return kindName, strings.Join(tags, ",")
+}
+
+func (s *statsd) sendDistribution(name string, count int64, mean, max float64, percentiles []float64, unit float64,
+	previous map[string]int64) error {
+	firstErr := s.sendMeter(name+MetricSeparator+"count", count, previous)
+	gauges := []struct {
+		suffix string
+		value  float64
+	}{
+		{"mean", mean}, {"max", max}, {"p50", percentiles[0]}, {"p95", percentiles[1]}, {"p99", percentiles[2]},
+	}
+	for _, gauge := range gauges {
+		if err := s.send(name+MetricSeparator+gauge.suffix, formatFloat(gauge.value/unit), "g", ""); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// send appends metric to packet, packet is written when it would exceed
+// maxPacketSize
+func (s *statsd) send(name, value, kind, tags string) error {
+	line := statsdNameEscaper.Replace(strings.Join(FilterOutEmptyStrings([]string{s.prefix, name}), MetricSeparator)) +
+		":" + value + "|" + kind
+	if tags != "" {
+		line += "|#" + tags
+	}
+	if s.packet.Len() > 0 && s.packet.Len()+1+len(line) > maxPacketSize {
+		if err := s.writePacket(); err != nil {
+			return err
+		}
+	}
+	if s.packet.Len() > 0 {
+		s.packet.WriteByte('\n')
+	}
+	s.packet.WriteString(line)
+	return nil
+}
+
+func (s *statsd) writePacket() error {
+	if s.packet.Len() == 0 {
+		return nil
+	}
+	_, err := s.conn.Write(s.packet.Bytes())
+	s.packet.Reset()
+	return err
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// udpListener collects datagrams sent to local address
+func udpListener(t *testing.T) (net.PacketConn, net.Conn) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	require.NoError(t, err)
+	return listener, conn
+}
+
+func receive(t *testing.T, listener net.PacketConn) []string {
+	buffer := make([]byte, 65536)
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := listener.ReadFrom(buffer)
+	require.NoError(t, err)
+	assert.True(t, n <= maxPacketSize)
+	return strings.Split(string(buffer[:n]), "\n")
+}
+
+func TestStatsdFlushesMeterDeltasGaugesAndTimers(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{SystemSubPrefix: "internal", Instance: "host", AppSubPrefix: "applications"}))
+	listener, conn := udpListener(t)
+	defer listener.Close()
+	defer conn.Close()
+	registry := metrics.NewRegistry()
+	meter := metrics.GetOrRegisterMeter(systemMetric("events.callback"), registry)
+	meter.Mark(3)
+	metrics.GetOrRegisterGauge(systemMetric("queue.stuck"), registry).Update(2)
+	metrics.GetOrRegisterTimer(systemMetric("marathon.get"), registry).Update(2 * time.Second)
+	trackApp(appMetric("statsd.app.task_failed"), &appLabels{kind: KindAppStatus, pairs: []string{"app", "/statsd/app", "status", "task_failed"}})
+	metrics.GetOrRegisterMeter(appMetric("statsd.app.task_failed"), registry).Mark(1)
+	s := newStatsd(conn, "prefix", false)
+
+	// when
+	require.NoError(t, s.flush(registry))
+	// then
+	assert.Equal(t, []string{
+		"prefix.applications.statsd.app.task_failed:1|c",
+		"prefix.internal.host.events.callback:3|c",
+		"prefix.internal.host.marathon.get.count:1|c",
+		"prefix.internal.host.marathon.get.mean:2000|g",
+		"prefix.internal.host.marathon.get.max:2000|g",
+		"prefix.internal.host.marathon.get.p50:2000|g",
+		"prefix.internal.host.marathon.get.p95:2000|g",
+		"prefix.internal.host.marathon.get.p99:2000|g",
+		"prefix.internal.host.queue.stuck:2|g",
+	}, receive(t, listener))
+
+	// when
+	meter.Mark(2)
+	require.NoError(t, s.flush(registry))
+	// then
+	lines := receive(t, listener)
+	assert.Contains(t, lines, "prefix.internal.host.events.callback:2|c", "only delta is sent")
+	assert.NotContains(t, lines, "prefix.applications.statsd.app.task_failed:0|c", "unchanged meters are skipped")
+}
+
+func TestDogStatsdTagsAppMetricsWithAppAndStatus(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{Instance: "host", AppSubPrefix: "applications"}))
+	listener, conn := udpListener(t)
+	defer listener.Close()
+	defer conn.Close()
+	registry := metrics.NewRegistry()
+	trackApp(appMetric("dog.app.task_killed"), &appLabels{kind: KindAppStatus, pairs: []string{"app", "/dog/app", "status", "task_killed"}})
+	metrics.GetOrRegisterMeter(appMetric("dog.app.task_killed"), registry).Mark(4)
+	s := newStatsd(conn, "", true)
+
+	// when
+	require.NoError(t, s.flush(registry))
+
+	// then
+	assert.Equal(t, []string{"applications.app_status:4|c|#app:/dog/app,status:task_killed"}, receive(t, listener))
+}
+
+func TestStatsdSplitsDatagramsAboveMaxPacketSize(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{Instance: "host"}))
+	listener, conn := udpListener(t)
+	defer listener.Close()
+	defer conn.Close()
+	registry := metrics.NewRegistry()
+	for i := 0; i < 100; i++ {
+		metrics.GetOrRegisterGauge(systemMetric(strings.Repeat("x", 20)+string(rune('a'+i%26))+strings.Repeat("y", i)), registry).Update(1)
+	}
+	s := newStatsd(conn, "", false)
+
+	// when
+	require.NoError(t, s.flush(registry))
+
+	// then
+	received := 0
+	for received < 100 {
+		received += len(receive(t, listener))
+	}
+	assert.Equal(t, 100, received)
+}
+
+func TestInitStatsdRequiresAddress(t *testing.T) {
+	// when
+	err := Init(Config{Target: "statsd"})
+	// then
+	assert.Error(t, err)
+}
+
+func TestStatsdSendsWholeCountOfReregisteredMeter(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{Instance: "host"}))
+	listener, conn := udpListener(t)
+	defer listener.Close()
+	defer conn.Close()
+	registry := metrics.NewRegistry()
+	metrics.GetOrRegisterMeter("expired", registry).Mark(5)
+	s := newStatsd(conn, "", false)
+	require.NoError(t, s.flush(registry))
+	receive(t, listener)
+	registry.Unregister("expired")
+	metrics.GetOrRegisterMeter("expired", registry).Mark(2)
+
+	// when
+	require.NoError(t, s.flush(registry))
+
+	// then
+	assert.Equal(t, []string{"expired:2|c"}, receive(t, listener))
+}
+
+// failingConn fails first write, later writes reach wrapped connection
+type failingConn struct {
+	net.Conn
+	writes int
+}
+
+func (c *failingConn) Write(b []byte) (int, error) {
+	c.writes++
+	if c.writes == 1 {
+		return 0, errors.New("network unreachable")
+	}
+	return c.Conn.Write(b)
+}
+
+func TestStatsdKeepsBaselinesOfAllMetersWhenWriteFailsDuringFlush(t *testing.T) {
+	// given
+	require.NoError(t, Init(Config{Instance: "host"}))
+	listener, conn := udpListener(t)
+	defer listener.Close()
+	defer conn.Close()
+	registry := metrics.NewRegistry()
+	for i := 0; i < 100; i++ {
+		metrics.GetOrRegisterMeter(strings.Repeat("x", 20)+strconv.Itoa(i), registry).Mark(1)
+	}
+	s := newStatsd(&failingConn{Conn: conn}, "", false)
+
+	// when
+	err := s.flush(registry)
+
+	// then
+	assert.Error(t, err)
+	assert.Len(t, s.counts, 100)
+	assert.True(t, len(receive(t, listener)) < 100)
+	// when
+	require.NoError(t, s.flush(registry))
+	// then no meter is sent again
+	require.NoError(t, listener.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, _, err = listener.ReadFrom(make([]byte, 65536))
+	assert.Error(t, err)
+}
@@ -1,11 +1,16 @@
 package mgc
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/audit"
 	"github.com/allegro/marathon-appcop/marathon"
 	"github.com/allegro/marathon-appcop/metrics"
+	"github.com/allegro/marathon-appcop/notify"
 )
 
 // MarathonGC is Marathon Garbage Collector receiever, mainly holds applications registry
@@ -15,24 +20,51 @@ type MarathonGC struct {
 	marathon    marathon.Marathoner
 	apps        []*marathon.App
 	lastRefresh time.Time
+	notifier    notify.Notifier
+	immunity    marathon.Immunity
+	// quit stops job, done is closed when job stopped and inflight tracks
+	// collection in progress
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	inflight sync.WaitGroup
+	// paused is 1 when collection is skipped, accessed atomically
+	paused int32
 }
 
 // New instantiates MarathonGC reciever
-func New(config Config, marathon marathon.Marathoner) (*MarathonGC, error) {
+func New(config Config, m marathon.Marathoner) (*MarathonGC, error) {
 
 	return &MarathonGC{
 		config:      config,
-		marathon:    marathon,
+		marathon:    m,
 		apps:        nil,
 		lastRefresh: time.Time{},
+		notifier:    notify.Noop{},
+		immunity:    marathon.LabelImmunity{},
 	}, nil
 }
 
+// NotifyWith sends deletions of applications to notifier, by default nobody
+// is notified. Must be called before job is started.
+func (mgc *MarathonGC) NotifyWith(notifier notify.Notifier) {
+	mgc.notifier = notifier
+}
+
+// CheckImmunityWith decides which suspended applications are not deleted, by
+// default only those with APP_IMMUNITY label. Must be called before job is
+// started.
+func (mgc *MarathonGC) CheckImmunityWith(immunity marathon.Immunity) {
+	mgc.immunity = immunity
+}
+
 // StartMarathonGCJob is highest control element of MarathonGC module,
 // which starts job goroutine for periodic:
 // - collection of suspended apps,
 // - collection of empty groups.
-func (mgc *MarathonGC) StartMarathonGCJob() {
+// Job stops when Stop is called or provided context is cancelled, the latter
+// aborts calls to Marathon in progress.
+func (mgc *MarathonGC) StartMarathonGCJob(ctx context.Context) {
 	if !mgc.config.Enabled {
 		log.Info("Marathon Garbage Collection enabled")
 		return
@@ -41,23 +73,77 @@ func (mgc *MarathonGC) StartMarathonGCJob() {
 		"Interval": mgc.config.Interval,
 	}).Info("Marathon GC job started")
 
+	mgc.quit = make(chan struct{})
+	mgc.done = make(chan struct{})
 	go func() {
 		var err error
 		ticker := time.NewTicker(mgc.config.Interval)
-		for range ticker.C {
-			metrics.Time("mgc.refresh", func() { err = mgc.refresh() })
+		defer ticker.Stop()
+		defer close(mgc.done)
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info("Marathon GC job stopped")
+				return
+			case <-mgc.quit:
+				log.Info("Marathon GC job stopped")
+				return
+			case <-ticker.C:
+			}
+			if mgc.Paused() {
+				metrics.Mark("mgc.paused")
+				continue
+			}
+			mgc.inflight.Add(1)
+			metrics.Time("mgc.refresh", func() { err = mgc.refresh(ctx) })
 			if err != nil {
 				metrics.Mark("mgc.refresh.error")
+				mgc.inflight.Done()
 				continue
 			}
-			mgc.gcSuspended()
-			mgc.gcEmptyGroups()
+			mgc.gcSuspended(ctx)
+			mgc.gcEmptyGroups(ctx)
+			mgc.inflight.Done()
 		}
 	}()
 }
 
+// Stop job, collection in progress is not interrupted
+func (mgc *MarathonGC) Stop() {
+	mgc.stopOnce.Do(func() {
+		if mgc.quit != nil {
+			close(mgc.quit)
+			<-mgc.done
+		}
+	})
+}
+
+// Wait for collection in progress
+func (mgc *MarathonGC) Wait() {
+	mgc.inflight.Wait()
+}
+
+// Pause skips collections until Resume is called
+func (mgc *MarathonGC) Pause() {
+	if atomic.SwapInt32(&mgc.paused, 1) == 0 {
+		log.Info("Pausing Marathon GC job")
+	}
+}
+
+// Resume collections
+func (mgc *MarathonGC) Resume() {
+	if atomic.SwapInt32(&mgc.paused, 0) == 1 {
+		log.Info("Resuming Marathon GC job")
+	}
+}
+
+// Paused tells whether collections are skipped
+func (mgc *MarathonGC) Paused() bool {
+	return atomic.LoadInt32(&mgc.paused) == 1
+}
+
 // gcSuspended commits garbage collection for suspended apps
-func (mgc *MarathonGC) gcSuspended() {
+func (mgc *MarathonGC) gcSuspended(ctx context.Context) {
 	log.Info("Staring GC on suspended apps")
 	apps := mgc.getOldSuspended()
 	if len(apps) == 0 {
@@ -67,7 +153,7 @@ func (mgc *MarathonGC) gcSuspended() {
 
 	var deletedCount int
 	metrics.Time("mgc.delete.suspended", func() {
-		deletedCount = mgc.deleteSuspended(apps)
+		deletedCount = mgc.deleteSuspended(ctx, apps)
 	})
 	if deletedCount == 0 {
 		metrics.UpdateGauge("mgc.delete.suspended.count", int64(deletedCount))
@@ -78,15 +164,19 @@ func (mgc *MarathonGC) gcSuspended() {
 
 // gcEmptyGroups is starting GC jobs on groups
 // It is evaluating time of last group update
-func (mgc *MarathonGC) gcEmptyGroups() {
+func (mgc *MarathonGC) gcEmptyGroups(ctx context.Context) {
 	log.Info("Staring GC on empty groups")
-	groups, err := mgc.marathon.GetEmptyLeafGroups()
+	groups, err := mgc.marathon.GetEmptyLeafGroups(ctx)
 	if err != nil {
 		log.WithError(err).Error("Ending GCEmptyGroups")
 		return
 	}
 
 	for _, group := range groups {
+		// leadership lost while collecting
+		if mgc.Paused() {
+			return
+		}
 		t, err := toMarathonDate(group.Version)
 		if err != nil {
 			log.WithError(err).Error("Unable to parse date")
@@ -94,7 +184,7 @@ func (mgc *MarathonGC) gcEmptyGroups() {
 		}
 		if group.IsEmpty() && (t.elapsed() > mgc.config.MaxSuspendTime) {
 			metrics.Time("mgc.groups.delete", func() {
-				err = mgc.groupDelete(group.ID)
+				err = mgc.groupDelete(ctx, group.ID)
 			})
 			if err != nil {
 				metrics.Mark("mgc.groups.delete.error")
@@ -104,18 +194,25 @@ func (mgc *MarathonGC) gcEmptyGroups() {
 	}
 }
 
-func (mgc *MarathonGC) groupDelete(groupID marathon.GroupID) error {
+func (mgc *MarathonGC) groupDelete(ctx context.Context, groupID marathon.GroupID) error {
 	log.Infof("Deleting group %s", groupID)
-	return mgc.marathon.GroupDelete(groupID)
+	err := mgc.marathon.GroupDelete(ctx, groupID)
+	audit.Log(audit.Entry{
+		Action: "deleteGroup",
+		Target: groupID.String(),
+		Reason: "group empty for too long",
+		Err:    err,
+	})
+	return err
 }
 
-func (mgc *MarathonGC) refresh() error {
+func (mgc *MarathonGC) refresh(ctx context.Context) error {
 	log.WithFields(log.Fields{
 		"LastUpdate": mgc.lastRefresh,
 	}).Info("Refreshing local app registry")
 
 	// get apps
-	apps, err := mgc.marathon.AppsGet()
+	apps, err := mgc.marathon.AppsGet(ctx)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"LastUpdate": mgc.lastRefresh,
@@ -147,20 +244,39 @@ func (mgc *MarathonGC) getOldSuspended() []*marathon.App {
 	var ret []*marathon.App
 
 	for _, app := range mgc.apps {
-		if mgc.shouldBeCollected(app) && (!mgc.config.AppCopOnly || appCopped(app)) {
-			ret = append(ret, app)
+		if !mgc.shouldBeCollected(app) || (mgc.config.AppCopOnly && !appCopped(app)) {
+			continue
+		}
+		if mgc.immunity.Immune(app) {
+			log.WithField("appId", app.ID).Info("Suspended app has immunity, not deleting")
+			continue
 		}
+		ret = append(ret, app)
 	}
 	return ret
 }
 
 // deleteSuspended returns number (int) of successfully deleted applications
-func (mgc *MarathonGC) deleteSuspended(apps []*marathon.App) int {
+func (mgc *MarathonGC) deleteSuspended(ctx context.Context, apps []*marathon.App) int {
 
 	n := 0
 	var err error
 	for _, app := range apps {
-		err = mgc.marathon.AppDelete(app.ID)
+		// leadership lost while collecting
+		if mgc.Paused() {
+			break
+		}
+		entry := audit.Entry{
+			Action:  "delete",
+			Target:  app.ID.String(),
+			Reason:  "suspended for too long",
+			Details: log.Fields{"lastScalingAt": app.VersionInfo.LastScalingAt},
+		}
+		mgc.notify(notify.PhaseBefore, entry, app)
+		err = mgc.marathon.AppDelete(ctx, app.ID)
+		entry.Err = err
+		audit.Log(entry)
+		mgc.notify(notify.PhaseAfter, entry, app)
 		if err != nil {
 			log.WithError(err).Errorf("Error while deleting suspended app: %s", app.ID)
 			continue
@@ -170,6 +286,12 @@ func (mgc *MarathonGC) deleteSuspended(apps []*marathon.App) int {
 	return n
 }
 
+func (mgc *MarathonGC) notify(phase string, entry audit.Entry, app *marathon.App) {
+	event := notify.NewEvent(phase, entry)
+	event.Labels = app.Labels
+	mgc.notifier.Notify(event)
+}
+
 func appCopped(app *marathon.App) bool {
 	_, ok := app.Labels["appcop"]
 
@@ -1,10 +1,12 @@
 package mgc
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/notify"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -15,7 +17,7 @@ func TestMGCConstructorShouldReturnStruct(t *testing.T) {
 	marathon := marathon.Marathon{}
 	config := Config{}
 	timeNow := time.Now()
-	given := MarathonGC{config: config,
+	given := &MarathonGC{config: config,
 		marathon:    marathon,
 		apps:        nil,
 		lastRefresh: timeNow,
@@ -36,7 +38,7 @@ func TestMGCConstructorShouldReturnDifferentStruct(t *testing.T) {
 	marathon := marathon.Marathon{}
 	config := Config{}
 	timeNow := time.Now()
-	given := MarathonGC{config: config,
+	given := &MarathonGC{config: config,
 		marathon:    nil,
 		apps:        nil,
 		lastRefresh: timeNow,
@@ -200,6 +202,51 @@ func TestGetOneOldSuspendedWhenMGCIsConfiguredToSuspendOnlyAppCopped(t *testing.
 	assert.NotNil(t, apps)
 }
 
+// grantedImmunity excuses listed applications
+type grantedImmunity map[marathon.AppID]bool
+
+func (g grantedImmunity) Immune(app *marathon.App) bool {
+	return g[app.ID]
+}
+
+func TestGetOldSuspendedSkipsImmuneApps(t *testing.T) {
+	t.Parallel()
+	//given
+	m := marathon.Marathon{}
+	given, _ := New(Config{}, m)
+	given.CheckImmunityWith(grantedImmunity{"/granted": true})
+	wayBack := "2006-01-02T15:04:05.000Z"
+	versionInfo := marathon.VersionInfo{LastScalingAt: wayBack, LastConfigChangeAt: wayBack}
+	given.apps = []*marathon.App{
+		{ID: "/suspended", VersionInfo: versionInfo},
+		{ID: "/granted", VersionInfo: versionInfo},
+	}
+	// when
+	apps := given.getOldSuspended()
+	// then
+	require.Len(t, apps, 1)
+	assert.Equal(t, marathon.AppID("/suspended"), apps[0].ID)
+}
+
+func TestGetOldSuspendedSkipsAppsWithImmunityLabelByDefault(t *testing.T) {
+	t.Parallel()
+	//given
+	m := marathon.Marathon{}
+	given, _ := New(Config{}, m)
+	wayBack := "2006-01-02T15:04:05.000Z"
+	given.apps = []*marathon.App{
+		{
+			ID:          "/labeled",
+			VersionInfo: marathon.VersionInfo{LastScalingAt: wayBack, LastConfigChangeAt: wayBack},
+			Labels:      map[string]string{marathon.ApplicationImmunityLabel: "true"},
+		},
+	}
+	// when
+	apps := given.getOldSuspended()
+	// then
+	assert.Empty(t, apps)
+}
+
 func TestGetOldSuspendedReturnsNothingWhenMGCIsConfiguredToSuspendOnlyAppCopped(t *testing.T) {
 	t.Parallel()
 	//given
@@ -287,7 +334,7 @@ func TestMGCRefreshSuccessWhenMarathonReturnsTwoApps(t *testing.T) {
 	mgc, _ := New(Config{}, m)
 
 	// when
-	err := mgc.refresh()
+	err := mgc.refresh(context.Background())
 
 	// then
 	require.NoError(t, err)
@@ -305,7 +352,7 @@ func TestMGCRefreshErrorWhenMarathonGetAppsReturnsError(t *testing.T) {
 	m := marathon.MStub{Apps: apps, AppsGetFail: true}
 	mgc, _ := New(Config{}, m)
 	// when
-	err := mgc.refresh()
+	err := mgc.refresh(context.Background())
 	// then
 	require.Error(t, err)
 	assert.NotNil(t, mgc)
@@ -318,7 +365,7 @@ func TestMGCGroupDeleteWhenMrathonReturnsSuccess(t *testing.T) {
 	m := marathon.MStub{}
 	mgc, _ := New(Config{}, m)
 	// when
-	err := mgc.groupDelete("testgroup")
+	err := mgc.groupDelete(context.Background(), "testgroup")
 	//then
 	require.NoError(t, err)
 }
@@ -329,7 +376,7 @@ func TestMGCGroupDeleteWhenMrathonReturnsError(t *testing.T) {
 	m := marathon.MStub{GroupDelFail: true}
 	mgc, _ := New(Config{}, m)
 	// when
-	err := mgc.groupDelete("testgroup")
+	err := mgc.groupDelete(context.Background(), "testgroup")
 	//then
 	require.Error(t, err)
 }
@@ -343,7 +390,7 @@ func TestMGCDeleteSuspendedAppsWhenThereIsOneAppToDelete(t *testing.T) {
 	m := marathon.MStub{Apps: apps}
 	mgc, _ := New(Config{}, m)
 	// when
-	i := mgc.deleteSuspended(apps)
+	i := mgc.deleteSuspended(context.Background(), apps)
 	// then
 	assert.Equal(t, 1, i)
 }
@@ -358,7 +405,7 @@ func TestMGCDeleteSuspendedAppsWhenThereIsTwoAppsToDelete(t *testing.T) {
 	m := marathon.MStub{Apps: apps}
 	mgc, _ := New(Config{}, m)
 	// when
-	i := mgc.deleteSuspended(apps)
+	i := mgc.deleteSuspended(context.Background(), apps)
 	// then
 	assert.Equal(t, 2, i)
 }
@@ -372,11 +419,93 @@ func TestMGCDeleteSuspendedAppsWhenMarathonReturnsErrorsOnSomeDeletes(t *testing
 		{ID: "testapp2"},
 		{ID: "testapp3"},
 	}
-	failCounter := &marathon.FailCounter{Counter: 1}
-	m := marathon.MStub{Apps: apps, AppDelHalfFail: true, FailCounter: failCounter}
+	m := marathon.NewMStub()
+	m.Apps = apps
+	m.AppDelHalfFail = true
+	m.FailCounter.Counter = 1
 	mgc, _ := New(Config{}, m)
 	// when
-	i := mgc.deleteSuspended(apps)
+	i := mgc.deleteSuspended(context.Background(), apps)
 	// then
 	assert.Equal(t, 2, i)
 }
+
+// recordingNotifier keeps notified events
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (r *recordingNotifier) Notify(event notify.Event) {
+	r.events = append(r.events, event)
+}
+
+func TestMGCDeleteSuspendedNotifiesBeforeAndAfterDelete(t *testing.T) {
+	t.Parallel()
+	// given
+	apps := []*marathon.App{
+		{ID: "testapp0", Labels: map[string]string{"appcop": "suspend"}},
+	}
+	m := marathon.MStub{Apps: apps, AppDelFail: true}
+	mgc, _ := New(Config{}, m)
+	notifier := &recordingNotifier{}
+	mgc.NotifyWith(notifier)
+	// when
+	mgc.deleteSuspended(context.Background(), apps)
+	// then
+	require.Len(t, notifier.events, 2)
+	before, after := notifier.events[0], notifier.events[1]
+	assert.Equal(t, notify.PhaseBefore, before.Phase)
+	assert.Equal(t, "delete", before.Action)
+	assert.Equal(t, "testapp0", before.AppID)
+	assert.Equal(t, map[string]string{"appcop": "suspend"}, before.Labels)
+	assert.Empty(t, before.Error)
+	assert.Equal(t, notify.PhaseAfter, after.Phase)
+	assert.Equal(t, "unable to delete app", after.Error)
+}
+
+func TestMGCDeleteSuspendedDeletesNothingWhenPaused(t *testing.T) {
+	t.Parallel()
+	// given
+	apps := []*marathon.App{
+		{ID: "testapp0"},
+	}
+	m := marathon.MStub{Apps: apps}
+	mgc, _ := New(Config{}, m)
+	mgc.Pause()
+	// when
+	i := mgc.deleteSuspended(context.Background(), apps)
+	// then
+	assert.Equal(t, 0, i)
+	// when
+	mgc.Resume()
+	i = mgc.deleteSuspended(context.Background(), apps)
+	// then
+	assert.Equal(t, 1, i)
+}
+
+func TestMGCStopWhenJobIsNotStartedReturnsImmediately(t *testing.T) {
+	t.Parallel()
+	// given
+	mgc, _ := New(Config{Enabled: false}, marathon.MStub{})
+	mgc.StartMarathonGCJob(context.Background())
+	// when
+	mgc.Stop()
+	mgc.Wait()
+}
+
+func TestMGCStopEndsJob(t *testing.T) {
+	t.Parallel()
+	// given
+	mgc, _ := New(Config{Enabled: true, Interval: time.Millisecond}, marathon.MStub{})
+	mgc.StartMarathonGCJob(context.Background())
+	time.Sleep(5 * time.Millisecond)
+	// when
+	mgc.Stop()
+	mgc.Wait()
+	// then
+	select {
+	case <-mgc.done:
+	default:
+		t.Fatal("job not stopped")
+	}
+}
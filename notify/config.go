@@ -0,0 +1,20 @@
+package notify
+
+import "time"
+
+// Config specific to notify package
+type Config struct {
+	// URL receiving notifications as JSON POST, empty disables notifications
+	URL string
+	// Secret used to sign payload with HMAC-SHA256, empty sends unsigned
+	// notifications
+	Secret string
+	// Timeout of single delivery attempt
+	Timeout time.Duration
+	// QueueSize limits notifications waiting for delivery and for retry
+	QueueSize int
+	// Retries of failed delivery, delayed by RetryBackoff doubled with
+	// every attempt, after that notification is dead-lettered to log
+	Retries      int
+	RetryBackoff time.Duration
+}
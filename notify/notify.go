@@ -0,0 +1,74 @@
+// Package notify tells application owners about actions AppCop takes against
+// their applications, before action is taken and after it finished.
+package notify
+
+import (
+	"time"
+
+	"github.com/allegro/marathon-appcop/audit"
+)
+
+// Notification phases
+const (
+	// PhaseBefore is sent when action is about to be taken
+	PhaseBefore = "before"
+	// PhaseAfter is sent when action was taken or failed, dry-run actions
+	// are notified only after
+	PhaseAfter = "after"
+)
+
+// Event describes action taken against application
+type Event struct {
+	AppID  string `json:"appId"`
+	Action string `json:"action"`
+	Phase  string `json:"phase"`
+	Actor  string `json:"actor"`
+	Reason string `json:"reason"`
+	// Score of application when action was taken
+	Score  int  `json:"score"`
+	DryRun bool `json:"dryRun"`
+	// Evidence contains action specific details, same as in audit log
+	Evidence map[string]interface{} `json:"evidence,omitempty"`
+	Labels   map[string]string      `json:"labels,omitempty"`
+	// Error is set when action failed
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Notifier sends events to application owners, Notify must not block caller
+type Notifier interface {
+	Notify(event Event)
+}
+
+// Noop drops all events
+type Noop struct{}
+
+// Notify does nothing
+func (Noop) Notify(Event) {}
+
+// NewEvent describes audited action in given phase
+func NewEvent(phase string, entry audit.Entry) Event {
+	actor := entry.Actor
+	if actor == "" {
+		actor = audit.Actor
+	}
+	event := Event{
+		AppID:  entry.Target,
+		Action: entry.Action,
+		Phase:  phase,
+		Actor:  actor,
+		Reason: entry.Reason,
+		DryRun: entry.DryRun,
+		Time:   time.Now(),
+	}
+	if len(entry.Details) > 0 {
+		event.Evidence = make(map[string]interface{}, len(entry.Details))
+		for k, v := range entry.Details {
+			event.Evidence[k] = v
+		}
+	}
+	if entry.Err != nil {
+		event.Error = entry.Err.Error()
+	}
+	return event
+}
@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"errors"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/audit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEventCopiesAuditedAction(t *testing.T) {
+	t.Parallel()
+	// given
+	entry := audit.Entry{
+		Action:  "scaleDown",
+		Target:  "/team/app",
+		Reason:  "score above threshold",
+		DryRun:  true,
+		Details: log.Fields{"score": 12},
+		Err:     errors.New("marathon unavailable"),
+	}
+	// when
+	event := NewEvent(PhaseAfter, entry)
+	entry.Details["score"] = 13
+	// then
+	assert.Equal(t, "/team/app", event.AppID)
+	assert.Equal(t, "scaleDown", event.Action)
+	assert.Equal(t, PhaseAfter, event.Phase)
+	assert.Equal(t, audit.Actor, event.Actor)
+	assert.Equal(t, "score above threshold", event.Reason)
+	assert.True(t, event.DryRun)
+	assert.Equal(t, map[string]interface{}{"score": 12}, event.Evidence, "evidence is copied")
+	assert.Equal(t, "marathon unavailable", event.Error)
+	assert.False(t, event.Time.IsZero())
+}
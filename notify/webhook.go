@@ -0,0 +1,307 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+// SignatureHeader holds hex encoded HMAC-SHA256 of payload prefixed with
+// sha256=
+const SignatureHeader = "X-AppCop-Signature"
+
+const (
+	defaultTimeout      = 5 * time.Second
+	defaultQueueSize    = 100
+	defaultRetryBackoff = time.Second
+	// maxBackoffShift keeps doubled backoff from overflowing
+	maxBackoffShift = 16
+)
+
+var (
+	errStopped   = errors.New("notifier stopped")
+	errQueueFull = errors.New("notification queue full")
+)
+
+// delivery is event waiting in retry queue
+type delivery struct {
+	event   Event
+	attempt int
+	due     time.Time
+}
+
+// Webhook posts events as JSON to configured URL. Events are delivered by
+// single goroutine in order they were notified, except failed deliveries,
+// which are retried with backoff after later events, so receivers should
+// order events by time. Events are dead-lettered to log when retries are
+// exhausted.
+type Webhook struct {
+	config Config
+	client *http.Client
+	queue  chan Event
+	// deadLetter is called with events which will never be delivered
+	deadLetter func(event Event, err error)
+	// quit stops delivery, done is closed when queued events were delivered
+	// or dead-lettered
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	// stopped is 1 when no more events are accepted, accessed atomically
+	stopped int32
+}
+
+// New creates webhook notifier, it sends nothing until started
+func New(config Config) (*Webhook, error) {
+	if config.URL != "" {
+		u, err := url.Parse(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification URL: %s", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("notification URL should be http or https, got %q", u.Scheme)
+		}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaultRetryBackoff
+	}
+
+	return &Webhook{
+		config:     config,
+		client:     &http.Client{Timeout: config.Timeout},
+		queue:      make(chan Event, config.QueueSize),
+		deadLetter: logDeadLetter,
+	}, nil
+}
+
+// Start delivering events until Stop is called or provided context is
+// cancelled, the latter dead-letters events waiting for delivery
+func (w *Webhook) Start(ctx context.Context) {
+	if w.config.URL == "" {
+		log.Info("Notifications disabled")
+		return
+	}
+	log.WithField("URL", w.config.URL).Info("Sending notifications")
+
+	w.quit = make(chan struct{})
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+		w.run(ctx)
+	}()
+}
+
+// Stop accepting events, events already queued are delivered once more
+// without retries
+func (w *Webhook) Stop() {
+	w.stopOnce.Do(func() {
+		atomic.StoreInt32(&w.stopped, 1)
+		if w.quit != nil {
+			close(w.quit)
+		}
+	})
+}
+
+// Wait until queued events are delivered or dead-lettered after Stop
+func (w *Webhook) Wait() {
+	if w.done != nil {
+		<-w.done
+	}
+}
+
+// Notify queues event for delivery, when queue is full event is
+// dead-lettered instead of blocking caller
+func (w *Webhook) Notify(event Event) {
+	if w.config.URL == "" {
+		return
+	}
+	if atomic.LoadInt32(&w.stopped) == 1 {
+		w.deadLetter(event, errStopped)
+		return
+	}
+	select {
+	case w.queue <- event:
+		metrics.Mark("notify.queued")
+	default:
+		w.deadLetter(event, errQueueFull)
+	}
+}
+
+func (w *Webhook) run(ctx context.Context) {
+	// retries are kept sorted by due time
+	var retries []delivery
+	for {
+		var retry <-chan time.Time
+		if len(retries) > 0 {
+			retry = time.After(time.Until(retries[0].due))
+		}
+		select {
+		case <-ctx.Done():
+			w.abort(retries, ctx.Err())
+			return
+		case <-w.quit:
+			w.drain(ctx, retries)
+			return
+		case event := <-w.queue:
+			retries = w.attempt(ctx, delivery{event: event}, retries)
+		case <-retry:
+			next := retries[0]
+			retries = w.attempt(ctx, next, retries[1:])
+		}
+	}
+}
+
+// attempt delivers event and schedules retry when delivery failed, returns
+// updated retries
+func (w *Webhook) attempt(ctx context.Context, d delivery, retries []delivery) []delivery {
+	err := w.deliver(ctx, d.event)
+	if err == nil {
+		return retries
+	}
+	d.attempt++
+	if d.attempt > w.config.Retries {
+		w.deadLetter(d.event, err)
+		return retries
+	}
+	if len(retries) >= w.config.QueueSize {
+		w.deadLetter(d.event, errQueueFull)
+		return retries
+	}
+	metrics.Mark("notify.retry")
+	log.WithError(err).WithFields(log.Fields{
+		"appId":   d.event.AppID,
+		"attempt": d.attempt,
+	}).Warn("Notification failed, retrying")
+
+	d.due = time.Now().Add(w.backoff(d.attempt))
+	i := sort.Search(len(retries), func(i int) bool { return retries[i].due.After(d.due) })
+	retries = append(retries, delivery{})
+	copy(retries[i+1:], retries[i:])
+	retries[i] = d
+	return retries
+}
+
+// drain delivers queued events and events waiting for retry once
+func (w *Webhook) drain(ctx context.Context, retries []delivery) {
+	for _, d := range retries {
+		if err := w.deliver(ctx, d.event); err != nil {
+			w.deadLetter(d.event, err)
+		}
+	}
+	for {
+		select {
+		case event := <-w.queue:
+			if err := w.deliver(ctx, event); err != nil {
+				w.deadLetter(event, err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// abort dead-letters all events waiting for delivery
+func (w *Webhook) abort(retries []delivery, err error) {
+	for _, d := range retries {
+		w.deadLetter(d.event, err)
+	}
+	for {
+		select {
+		case event := <-w.queue:
+			w.deadLetter(event, err)
+		default:
+			return
+		}
+	}
+}
+
+func (w *Webhook) backoff(attempt int) time.Duration {
+	shift := attempt - 1
+	if shift > maxBackoffShift {
+		shift = maxBackoffShift
+	}
+	return w.config.RetryBackoff << uint(shift)
+}
+
+func (w *Webhook) deliver(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if w.config.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.config.Secret, body))
+	}
+
+	var resp *http.Response
+	metrics.Time("notify.deliver", func() { resp, err = w.client.Do(req) })
+	if err != nil {
+		metrics.Mark("notify.error")
+		return err
+	}
+	defer drainAndClose(resp)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		metrics.Mark("notify.error")
+		return fmt.Errorf("notification rejected with status %d", resp.StatusCode)
+	}
+	metrics.Mark("notify.delivered")
+	return nil
+}
+
+// drainAndClose reads whole body, so connection can be reused
+func drainAndClose(r *http.Response) {
+	if _, err := io.Copy(ioutil.Discard, r.Body); err != nil {
+		log.WithError(err).Debug("Can't read response")
+	}
+	if err := r.Body.Close(); err != nil {
+		log.WithError(err).Error("Can't close response")
+	}
+}
+
+// Sign returns value of SignatureHeader for payload, receivers should
+// compute it with shared secret and compare in constant time
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// logDeadLetter logs whole payload, so undelivered notification can be
+// found and resent by hand
+func logDeadLetter(event Event, err error) {
+	metrics.Mark("notify.dead_letter")
+	payload, _ := json.Marshal(event)
+	log.WithError(err).WithFields(log.Fields{
+		"deadLetter": true,
+		"appId":      event.AppID,
+		"action":     event.Action,
+		"phase":      event.Phase,
+		"payload":    string(payload),
+	}).Error("Notification not delivered")
+}
@@ -0,0 +1,186 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver records notifications and responds with statuses in order, last
+// status is repeated
+type receiver struct {
+	mutex     sync.Mutex
+	statuses  []int
+	bodies    [][]byte
+	signature []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.bodies = append(r.bodies, body)
+	r.signature = append(r.signature, req.Header.Get(SignatureHeader))
+	status := r.statuses[0]
+	if len(r.statuses) > 1 {
+		r.statuses = r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) received() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.bodies)
+}
+
+// deadLetters collects events webhook gave up on
+type deadLetters struct {
+	mutex  sync.Mutex
+	events []Event
+	errs   []error
+}
+
+func (d *deadLetters) add(event Event, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.events = append(d.events, event)
+	d.errs = append(d.errs, err)
+}
+
+// startWebhook starts webhook posting to receiver, returned server must be
+// closed by test
+func startWebhook(t *testing.T, config Config, statuses ...int) (*Webhook, *receiver, *deadLetters, *httptest.Server) {
+	r := &receiver{statuses: statuses}
+	server := httptest.NewServer(r)
+	config.URL = server.URL
+	webhook, err := New(config)
+	require.NoError(t, err)
+	dead := &deadLetters{}
+	webhook.deadLetter = dead.add
+	webhook.Start(context.Background())
+	return webhook, r, dead, server
+}
+
+func TestWebhookPostsSignedEvent(t *testing.T) {
+	t.Parallel()
+	// given
+	webhook, r, dead, server := startWebhook(t, Config{Secret: "shared"}, http.StatusOK)
+	defer server.Close()
+	event := Event{AppID: "/team/app", Action: "delete", Phase: PhaseBefore, Labels: map[string]string{"owner": "team"}}
+	// when
+	webhook.Notify(event)
+	webhook.Stop()
+	webhook.Wait()
+	// then
+	require.Equal(t, 1, r.received())
+	assert.Equal(t, Sign("shared", r.bodies[0]), r.signature[0])
+	received := Event{}
+	require.NoError(t, json.Unmarshal(r.bodies[0], &received))
+	assert.Equal(t, event, received)
+	assert.Empty(t, dead.events)
+}
+
+func TestWebhookRetriesFailedDelivery(t *testing.T) {
+	t.Parallel()
+	// given
+	webhook, r, dead, server := startWebhook(t, Config{Retries: 2, RetryBackoff: time.Millisecond},
+		http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent)
+	defer server.Close()
+	// when
+	webhook.Notify(Event{AppID: "/team/app"})
+	for r.received() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	webhook.Stop()
+	webhook.Wait()
+	// then
+	assert.Equal(t, 3, r.received())
+	assert.Empty(t, dead.events)
+}
+
+func TestWebhookDeadLettersEventWhenRetriesAreExhausted(t *testing.T) {
+	t.Parallel()
+	// given
+	webhook, r, dead, server := startWebhook(t, Config{Retries: 1, RetryBackoff: time.Millisecond}, http.StatusInternalServerError)
+	defer server.Close()
+	// when
+	webhook.Notify(Event{AppID: "/team/app"})
+	for r.received() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	webhook.Stop()
+	webhook.Wait()
+	// then
+	require.Len(t, dead.events, 1)
+	assert.Equal(t, "/team/app", dead.events[0].AppID)
+	assert.EqualError(t, dead.errs[0], "notification rejected with status 500")
+}
+
+func TestWebhookDeadLettersEventWhenQueueIsFull(t *testing.T) {
+	t.Parallel()
+	// given
+	webhook, err := New(Config{URL: "http://localhost/", QueueSize: 1})
+	require.NoError(t, err)
+	dead := &deadLetters{}
+	webhook.deadLetter = dead.add
+	// when
+	webhook.Notify(Event{AppID: "/queued"})
+	webhook.Notify(Event{AppID: "/dropped"})
+	// then
+	require.Len(t, dead.events, 1)
+	assert.Equal(t, "/dropped", dead.events[0].AppID)
+	assert.Equal(t, errQueueFull, dead.errs[0])
+}
+
+func TestWebhookDeliversQueuedEventsOnStop(t *testing.T) {
+	t.Parallel()
+	// given
+	webhook, r, dead, server := startWebhook(t, Config{QueueSize: 10}, http.StatusOK)
+	defer server.Close()
+	for i := 0; i < 5; i++ {
+		webhook.Notify(Event{AppID: "/team/app"})
+	}
+	// when
+	webhook.Stop()
+	webhook.Wait()
+	webhook.Notify(Event{AppID: "/late"})
+	// then
+	assert.Equal(t, 5, r.received())
+	require.Len(t, dead.events, 1)
+	assert.Equal(t, errStopped, dead.errs[0])
+}
+
+func TestWebhookWithoutURLDropsEvents(t *testing.T) {
+	t.Parallel()
+	// given
+	webhook, err := New(Config{})
+	require.NoError(t, err)
+	dead := &deadLetters{}
+	webhook.deadLetter = dead.add
+	// when
+	webhook.Start(context.Background())
+	webhook.Notify(Event{AppID: "/team/app"})
+	webhook.Stop()
+	webhook.Wait()
+	// then
+	assert.Empty(t, dead.events)
+}
+
+func TestNewRejectsInvalidURL(t *testing.T) {
+	t.Parallel()
+	for _, url := range []string{"ftp://example.com/", "://", "example.com/hook"} {
+		// when
+		_, err := New(Config{URL: url})
+		// then
+		assert.Error(t, err, url)
+	}
+}
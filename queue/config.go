@@ -0,0 +1,18 @@
+package queue
+
+import "time"
+
+// Config specific to queue module
+type Config struct {
+	Enabled bool
+	// Interval between launch queue inspections
+	Interval time.Duration
+	// MaxWaitTime is how long application may wait in launch queue
+	// (overdue or with declined offers) before it is penalized
+	MaxWaitTime time.Duration
+	// Action taken for stuck application: score or suspend
+	Action string
+	// Penalty is a score added to stuck application when Action is score
+	Penalty int
+	DryRun  bool
+}
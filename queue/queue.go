@@ -0,0 +1,265 @@
+// Package queue inspects Marathon launch queue looking for applications that
+// can never launch (e.g. because of impossible constraints or too high
+// resource demands). Such applications produce few task events, so they are
+// invisible to the scorer.
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/audit"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/metrics"
+	"github.com/allegro/marathon-appcop/notify"
+	"github.com/allegro/marathon-appcop/score"
+)
+
+const (
+	// ActionScore adds configured penalty to stuck application score
+	ActionScore = "score"
+	// ActionSuspend suspends stuck application
+	ActionSuspend = "suspend"
+)
+
+// Inspector periodically checks launch queue and penalizes applications
+// stuck there for too long
+type Inspector struct {
+	config      Config
+	marathon    marathon.Marathoner
+	scoreUpdate chan<- score.Update
+	notifier    notify.Notifier
+	immunity    marathon.Immunity
+	// firstSeen is used when marathon does not report since field
+	firstSeen map[marathon.AppID]time.Time
+	// lastAction prevents penalizing application on every inspection
+	lastAction map[marathon.AppID]time.Time
+	now        func() time.Time
+	// quit stops job, done is closed when job stopped and inflight tracks
+	// inspection in progress
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	inflight sync.WaitGroup
+	// paused is 1 when inspection is skipped, accessed atomically
+	paused int32
+}
+
+// New instantiates launch queue Inspector
+func New(config Config, m marathon.Marathoner, scoreUpdate chan<- score.Update) (*Inspector, error) {
+	if config.Enabled && config.Action != ActionScore && config.Action != ActionSuspend {
+		return nil, fmt.Errorf("invalid queue action %s", config.Action)
+	}
+
+	return &Inspector{
+		config:      config,
+		marathon:    m,
+		scoreUpdate: scoreUpdate,
+		notifier:    notify.Noop{},
+		immunity:    marathon.LabelImmunity{},
+		firstSeen:   make(map[marathon.AppID]time.Time),
+		lastAction:  make(map[marathon.AppID]time.Time),
+		now:         time.Now,
+	}, nil
+}
+
+// NotifyWith sends suspensions of applications to notifier, by default
+// nobody is notified. Must be called before job is started.
+func (i *Inspector) NotifyWith(notifier notify.Notifier) {
+	i.notifier = notifier
+}
+
+// CheckImmunityWith decides which stuck applications are not suspended, by
+// default only those with APP_IMMUNITY label. Must be called before job is
+// started.
+func (i *Inspector) CheckImmunityWith(immunity marathon.Immunity) {
+	i.immunity = immunity
+}
+
+// StartInspectorJob starts goroutine periodically inspecting launch queue,
+// job stops when Stop is called or provided context is cancelled.
+func (i *Inspector) StartInspectorJob(ctx context.Context) {
+	if !i.config.Enabled {
+		log.Info("Launch queue inspection disabled")
+		return
+	}
+	log.WithFields(log.Fields{
+		"Interval": i.config.Interval,
+		"Action":   i.config.Action,
+	}).Info("Launch queue inspection started")
+
+	i.quit = make(chan struct{})
+	i.done = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(i.config.Interval)
+		defer ticker.Stop()
+		defer close(i.done)
+		for {
+			select {
+			case <-ctx.Done():
+				log.Info("Launch queue inspection stopped")
+				return
+			case <-i.quit:
+				log.Info("Launch queue inspection stopped")
+				return
+			case <-ticker.C:
+				if i.Paused() {
+					metrics.Mark("queue.inspect.paused")
+					continue
+				}
+				i.inflight.Add(1)
+				metrics.Time("queue.inspect", func() { i.inspect(ctx) })
+				i.inflight.Done()
+			}
+		}
+	}()
+}
+
+// Stop job, inspection in progress is not interrupted
+func (i *Inspector) Stop() {
+	i.stopOnce.Do(func() {
+		if i.quit != nil {
+			close(i.quit)
+			<-i.done
+		}
+	})
+}
+
+// Wait for inspection in progress
+func (i *Inspector) Wait() {
+	i.inflight.Wait()
+}
+
+// Pause skips inspections until Resume is called
+func (i *Inspector) Pause() {
+	if atomic.SwapInt32(&i.paused, 1) == 0 {
+		log.Info("Pausing launch queue inspection")
+	}
+}
+
+// Resume inspections
+func (i *Inspector) Resume() {
+	if atomic.SwapInt32(&i.paused, 0) == 1 {
+		log.Info("Resuming launch queue inspection")
+	}
+}
+
+// Paused tells whether inspections are skipped
+func (i *Inspector) Paused() bool {
+	return atomic.LoadInt32(&i.paused) == 1
+}
+
+func (i *Inspector) inspect(ctx context.Context) {
+	items, err := i.marathon.QueueGet(ctx)
+	if err != nil {
+		metrics.Mark("queue.inspect.error")
+		log.WithError(err).Error("Unable to get launch queue")
+		return
+	}
+
+	now := i.now()
+	queued := make(map[marathon.AppID]bool, len(items))
+	stuckCount := 0
+	for _, item := range items {
+		// leadership lost while inspecting
+		if i.Paused() {
+			return
+		}
+		appID := item.App.ID
+		queued[appID] = true
+		if !item.IsStuck() {
+			delete(i.firstSeen, appID)
+			continue
+		}
+		stuckCount++
+
+		waiting := now.Sub(i.stuckSince(item, now))
+		if waiting < i.config.MaxWaitTime {
+			continue
+		}
+		if last, ok := i.lastAction[appID]; ok && now.Sub(last) < i.config.MaxWaitTime {
+			continue
+		}
+		i.lastAction[appID] = now
+		i.penalize(ctx, item, waiting)
+	}
+	metrics.UpdateGauge("queue.stuck", int64(stuckCount))
+
+	// forget applications that left the queue
+	for appID := range i.firstSeen {
+		if !queued[appID] {
+			delete(i.firstSeen, appID)
+		}
+	}
+	for appID := range i.lastAction {
+		if !queued[appID] {
+			delete(i.lastAction, appID)
+		}
+	}
+}
+
+func (i *Inspector) stuckSince(item *marathon.QueueItem, now time.Time) time.Time {
+	if since, err := item.SinceTime(); err == nil {
+		return since
+	}
+	first, ok := i.firstSeen[item.App.ID]
+	if !ok {
+		i.firstSeen[item.App.ID] = now
+		return now
+	}
+	return first
+}
+
+func (i *Inspector) penalize(ctx context.Context, item *marathon.QueueItem, waiting time.Duration) {
+	app := item.App
+	entry := audit.Entry{
+		Action: i.config.Action,
+		Target: app.ID.String(),
+		Reason: "stuck in launch queue",
+		DryRun: i.config.DryRun,
+		Details: log.Fields{
+			"waiting":        waiting.String(),
+			"overdue":        item.Delay.Overdue,
+			"unusedOffers":   item.ProcessedOffersSummary.UnusedOffersCount,
+			"declineReasons": item.DeclineReasons(),
+		},
+	}
+
+	if i.config.Action == ActionScore {
+		entry.Details["penalty"] = i.config.Penalty
+		select {
+		case i.scoreUpdate <- score.Update{App: &app, Update: i.config.Penalty}:
+		case <-ctx.Done():
+			return
+		}
+		metrics.Mark("queue.penalize.score")
+		audit.Log(entry)
+		return
+	}
+
+	if i.immunity.Immune(&app) {
+		log.WithField("appId", app.ID).Info("Stuck app has immunity, not suspending")
+		return
+	}
+	if !i.config.DryRun {
+		i.notify(notify.PhaseBefore, entry, &app)
+		entry.Err = i.marathon.AppSuspend(ctx, &app)
+	}
+	if entry.Err != nil {
+		metrics.Mark("queue.penalize.suspend.error")
+	} else {
+		metrics.Mark("queue.penalize.suspend")
+	}
+	audit.Log(entry)
+	i.notify(notify.PhaseAfter, entry, &app)
+}
+
+func (i *Inspector) notify(phase string, entry audit.Entry, app *marathon.App) {
+	event := notify.NewEvent(phase, entry)
+	event.Labels = app.Labels
+	i.notifier.Notify(event)
+}
@@ -0,0 +1,255 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/notify"
+	"github.com/allegro/marathon-appcop/score"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func stuckItem(appID marathon.AppID, since time.Time) *marathon.QueueItem {
+	return &marathon.QueueItem{
+		App:   marathon.App{ID: appID, Instances: 1, Labels: map[string]string{}},
+		Count: 1,
+		Delay: marathon.QueueDelay{Overdue: true},
+		Since: since.Format(time.RFC3339),
+		ProcessedOffersSummary: marathon.ProcessedOffersSummary{
+			UnusedOffersCount: 5,
+			RejectSummaryLastOffers: []marathon.OfferRejectSummary{
+				{Reason: "InsufficientCpus", Declined: 5, Processed: 5},
+				{Reason: "UnfulfilledConstraint", Declined: 0, Processed: 5},
+			},
+		},
+	}
+}
+
+func newTestInspector(t *testing.T, config Config, m marathon.Marathoner, updates chan score.Update) *Inspector {
+	inspector, err := New(config, m, updates)
+	require.NoError(t, err)
+	inspector.now = func() time.Time { return now }
+	return inspector
+}
+
+func TestNewReturnsErrorForUnknownAction(t *testing.T) {
+	t.Parallel()
+	// when
+	inspector, err := New(Config{Enabled: true, Action: "explode"}, marathon.MStub{}, nil)
+	// then
+	assert.Error(t, err)
+	assert.Nil(t, inspector)
+}
+
+func TestNewIgnoresActionWhenDisabled(t *testing.T) {
+	t.Parallel()
+	// when
+	inspector, err := New(Config{Action: "explode"}, marathon.MStub{}, nil)
+	// then
+	assert.NoError(t, err)
+	assert.NotNil(t, inspector)
+}
+
+func TestInspectScoresAppStuckLongerThanMaxWaitTime(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.MStub{Queue: []*marathon.QueueItem{
+		stuckItem("/stuck", now.Add(-time.Hour)),
+		stuckItem("/fresh", now.Add(-time.Minute)),
+	}}
+	updates := make(chan score.Update, 10)
+	inspector := newTestInspector(t, Config{Action: ActionScore, Penalty: 50, MaxWaitTime: 10 * time.Minute}, m, updates)
+	// when
+	inspector.inspect(context.Background())
+	// then
+	require.Len(t, updates, 1)
+	update := <-updates
+	assert.Equal(t, marathon.AppID("/stuck"), update.App.ID)
+	assert.Equal(t, 50, update.Update)
+}
+
+func TestInspectPenalizesNothingWhenPaused(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.MStub{Queue: []*marathon.QueueItem{stuckItem("/stuck", now.Add(-time.Hour))}}
+	updates := make(chan score.Update, 10)
+	inspector := newTestInspector(t, Config{Action: ActionScore, Penalty: 50, MaxWaitTime: 10 * time.Minute}, m, updates)
+	inspector.Pause()
+	// when
+	inspector.inspect(context.Background())
+	// then
+	assert.Empty(t, updates)
+	assert.True(t, inspector.Paused())
+}
+
+func TestInspectDoesNotPenalizeSameAppOnEveryInspection(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.MStub{Queue: []*marathon.QueueItem{stuckItem("/stuck", now.Add(-time.Hour))}}
+	updates := make(chan score.Update, 10)
+	inspector := newTestInspector(t, Config{Action: ActionScore, Penalty: 1, MaxWaitTime: 10 * time.Minute}, m, updates)
+	// when
+	inspector.inspect(context.Background())
+	inspector.inspect(context.Background())
+	inspector.now = func() time.Time { return now.Add(11 * time.Minute) }
+	inspector.inspect(context.Background())
+	// then
+	assert.Len(t, updates, 2)
+}
+
+func TestInspectIgnoresAppsWaitingOnlyForBackoff(t *testing.T) {
+	t.Parallel()
+	// given
+	item := stuckItem("/backoff", now.Add(-time.Hour))
+	item.Delay = marathon.QueueDelay{Overdue: false, TimeLeftSeconds: 30}
+	item.ProcessedOffersSummary = marathon.ProcessedOffersSummary{}
+	m := marathon.MStub{Queue: []*marathon.QueueItem{item}}
+	updates := make(chan score.Update, 10)
+	inspector := newTestInspector(t, Config{Action: ActionScore, Penalty: 1, MaxWaitTime: time.Minute}, m, updates)
+	// when
+	inspector.inspect(context.Background())
+	// then
+	assert.Len(t, updates, 0)
+}
+
+func TestInspectUsesFirstSeenTimeWhenSinceIsMissing(t *testing.T) {
+	t.Parallel()
+	// given
+	item := stuckItem("/stuck", now)
+	item.Since = ""
+	m := marathon.MStub{Queue: []*marathon.QueueItem{item}}
+	updates := make(chan score.Update, 10)
+	inspector := newTestInspector(t, Config{Action: ActionScore, Penalty: 1, MaxWaitTime: time.Minute}, m, updates)
+	// when
+	inspector.inspect(context.Background())
+	// then
+	assert.Len(t, updates, 0)
+	// when
+	inspector.now = func() time.Time { return now.Add(2 * time.Minute) }
+	inspector.inspect(context.Background())
+	// then
+	assert.Len(t, updates, 1)
+}
+
+func TestInspectSuspendsStuckApp(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Queue = []*marathon.QueueItem{stuckItem("/stuck", now.Add(-time.Hour))}
+	inspector := newTestInspector(t, Config{Action: ActionSuspend, MaxWaitTime: time.Minute}, m, nil)
+	// when
+	inspector.inspect(context.Background())
+	// then
+	assert.Equal(t, 1, m.SuspendCounter.Counter)
+}
+
+func TestInspectDoesNotSuspendInDryRunOrWhenAppIsImmune(t *testing.T) {
+	t.Parallel()
+	// given
+	immune := stuckItem("/immune", now.Add(-time.Hour))
+	immune.App.Labels[marathon.ApplicationImmunityLabel] = "true"
+	m := marathon.NewMStub()
+	m.Queue = []*marathon.QueueItem{immune}
+	inspector := newTestInspector(t, Config{Action: ActionSuspend, MaxWaitTime: time.Minute}, m, nil)
+	stuck := marathon.NewMStub()
+	stuck.Queue = []*marathon.QueueItem{stuckItem("/stuck", now.Add(-time.Hour))}
+	dryRun := newTestInspector(t, Config{Action: ActionSuspend, MaxWaitTime: time.Minute, DryRun: true}, stuck, nil)
+	// when
+	inspector.inspect(context.Background())
+	dryRun.inspect(context.Background())
+	// then
+	assert.Equal(t, 0, m.SuspendCounter.Counter)
+	assert.Equal(t, 0, stuck.SuspendCounter.Counter)
+}
+
+func TestInspectDoesNotSuspendAppWithGrantedImmunity(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Queue = []*marathon.QueueItem{stuckItem("/granted", now.Add(-time.Hour))}
+	scorer, err := score.New(score.Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2}, nil)
+	require.NoError(t, err)
+	scorer.GrantImmunity("/granted", time.Hour, "alice", "investigating")
+	inspector := newTestInspector(t, Config{Action: ActionSuspend, MaxWaitTime: time.Minute}, m, nil)
+	inspector.CheckImmunityWith(scorer)
+	// when
+	inspector.inspect(context.Background())
+	// then
+	assert.Equal(t, 0, m.SuspendCounter.Counter)
+}
+
+// recordingNotifier keeps notified events
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (r *recordingNotifier) Notify(event notify.Event) {
+	r.events = append(r.events, event)
+}
+
+func TestInspectNotifiesBeforeAndAfterSuspend(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Queue = []*marathon.QueueItem{stuckItem("/stuck", now.Add(-time.Hour))}
+	inspector := newTestInspector(t, Config{Action: ActionSuspend, MaxWaitTime: time.Minute}, m, nil)
+	notifier := &recordingNotifier{}
+	inspector.NotifyWith(notifier)
+	// when
+	inspector.inspect(context.Background())
+	// then
+	require.Len(t, notifier.events, 2)
+	before, after := notifier.events[0], notifier.events[1]
+	assert.Equal(t, notify.PhaseBefore, before.Phase)
+	assert.Equal(t, notify.PhaseAfter, after.Phase)
+	for _, event := range notifier.events {
+		assert.Equal(t, "/stuck", event.AppID)
+		assert.Equal(t, ActionSuspend, event.Action)
+		assert.Equal(t, "stuck in launch queue", event.Reason)
+	}
+}
+
+func TestInspectNotifiesOnlyAfterSuspendInDryRun(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Queue = []*marathon.QueueItem{stuckItem("/stuck", now.Add(-time.Hour))}
+	inspector := newTestInspector(t, Config{Action: ActionSuspend, MaxWaitTime: time.Minute, DryRun: true}, m, nil)
+	notifier := &recordingNotifier{}
+	inspector.NotifyWith(notifier)
+	// when
+	inspector.inspect(context.Background())
+	// then
+	require.Len(t, notifier.events, 1)
+	assert.Equal(t, notify.PhaseAfter, notifier.events[0].Phase)
+	assert.True(t, notifier.events[0].DryRun)
+}
+
+func TestStopEndsInspectorJob(t *testing.T) {
+	t.Parallel()
+	// given
+	inspector, err := New(Config{
+		Enabled:  true,
+		Interval: time.Millisecond,
+		Action:   ActionScore,
+	}, marathon.MStub{}, make(chan score.Update, 10))
+	require.NoError(t, err)
+	inspector.StartInspectorJob(context.Background())
+	time.Sleep(5 * time.Millisecond)
+
+	// when
+	inspector.Stop()
+	inspector.Wait()
+
+	// then
+	select {
+	case <-inspector.done:
+	default:
+		t.Fatal("job not stopped")
+	}
+}
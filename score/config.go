@@ -2,6 +2,14 @@ package score
 
 import "time"
 
+const (
+	// EnforcementScale lowers application instances and lets Marathon choose
+	// which task is killed
+	EnforcementScale = "scale"
+	// EnforcementKillTasks kills unhealthy or most often restarting tasks
+	EnforcementKillTasks = "kill-tasks"
+)
+
 // Config contains specific configuration to score module
 type Config struct {
 	DryRun           bool
@@ -10,4 +18,7 @@ type Config struct {
 	ResetInterval    time.Duration
 	EvaluateInterval time.Duration
 	ScaleLimit       int
+	// Enforcement tells how penalty is applied to application, see
+	// EnforcementScale and EnforcementKillTasks
+	Enforcement string
 }
@@ -0,0 +1,43 @@
+package score
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+// updateScoreGauge publishes current score of application, labeled with
+// application id trimmed with appid-prefix
+func (s *Scorer) updateScoreGauge(appID marathon.AppID, score int) {
+	metric := appID.GetMetric(s.appIDPrefix)
+	metrics.UpdateAppGauge(metric+metrics.MetricSeparator+"score", metrics.KindAppScore, int64(score),
+		"app", appID.GetMetricApp(s.appIDPrefix))
+}
+
+// updateScoreDistribution publishes histogram of scores across applications
+func (s *Scorer) updateScoreDistribution() {
+	s.mutex.RLock()
+	scores := make([]int64, 0, len(s.scores))
+	for _, score := range s.scores {
+		scores = append(scores, int64(score.score))
+	}
+	s.mutex.RUnlock()
+	metrics.UpdateHistogram("score.distribution", scores)
+}
+
+// markPenalty counts penalties per group of application and reports time
+// since first failure counted in score, then clears that baseline. Score is
+// nil when application was penalized by operator without failures
+func (s *Scorer) markPenalty(appID marathon.AppID, score *Score) {
+	group := marathon.AppID(path.Dir(appID.GetMetricApp(s.appIDPrefix)))
+	metric := metrics.FilterOutEmptyStrings([]string{group.GetMetric(""), "penalties"})
+	metrics.MarkAppLabeled(strings.Join(metric, metrics.MetricSeparator), metrics.KindGroupPenalties,
+		"group", group.String())
+	if score != nil && !score.firstUpdate.IsZero() {
+		metrics.UpdateTimer("score.time_to_penalty", time.Since(score.firstUpdate))
+		score.firstUpdate = time.Time{}
+	}
+}
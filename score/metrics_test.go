@@ -0,0 +1,105 @@
+package score
+
+import (
+	"context"
+	"testing"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gaugeValue(t *testing.T, name string) int64 {
+	gauge, ok := metrics.Get(name).(metrics.Gauge)
+	require.True(t, ok, name)
+	return gauge.Value()
+}
+
+func TestScoreIsPublishedAsGaugeWithoutAppIDPrefix(t *testing.T) {
+	t.Parallel()
+	// given
+	scorer, err := newTestScorer()
+	require.NoError(t, err)
+	scorer.appIDPrefix = "/com.example."
+	// when
+	scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "/com.example.gauge/app"}, Update: 3})
+	// then
+	assert.Equal(t, int64(3), gaugeValue(t, "gauge.app.score"))
+	// when
+	scorer.subtractScore("/com.example.gauge/app")
+	// then
+	assert.Equal(t, int64(2), gaugeValue(t, "gauge.app.score"))
+	// when
+	scorer.resetScore("/com.example.gauge/app")
+	// then
+	assert.Equal(t, int64(0), gaugeValue(t, "gauge.app.score"))
+}
+
+func meterCount(name string) int64 {
+	if meter, ok := metrics.Get(name).(metrics.Meter); ok {
+		return meter.Count()
+	}
+	return 0
+}
+
+func timerCount(name string) int64 {
+	if timer, ok := metrics.Get(name).(metrics.Timer); ok {
+		return timer.Count()
+	}
+	return 0
+}
+
+// penalty metrics tests are not parallel, they share time to penalty timer
+
+func TestPenaltyIsCountedPerGroupWithTimeToPenalty(t *testing.T) {
+	// given
+	m := marathon.NewMStub()
+	m.Apps = []*marathon.App{{ID: "/penalties/app", Instances: 2}}
+	scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, m)
+	require.NoError(t, err)
+	scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "/penalties/app"}, Update: 3})
+	penalties := meterCount("penalties.penalties")
+	timeToPenalty := timerCount("score.time_to_penalty")
+	// when
+	scorer.EvaluateApps(context.Background())
+	// then
+	assert.Equal(t, penalties+1, meterCount("penalties.penalties"))
+	assert.Equal(t, timeToPenalty+1, timerCount("score.time_to_penalty"))
+	_, ok := metrics.Get("score.distribution").(metrics.Histogram)
+	assert.True(t, ok)
+}
+
+func TestPenaltyMetricsAreNotUpdatedOnDryRun(t *testing.T) {
+	// given
+	m := marathon.NewMStub()
+	m.Apps = []*marathon.App{{ID: "/dryrun/app", Instances: 2}}
+	scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1,
+		DryRun: true}, m)
+	require.NoError(t, err)
+	scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "/dryrun/app"}, Update: 3})
+	timeToPenalty := timerCount("score.time_to_penalty")
+	// when
+	scorer.EvaluateApps(context.Background())
+	// then
+	assert.Equal(t, 0, m.ScaleCounter.Counter)
+	assert.Zero(t, meterCount("dryrun.penalties"))
+	assert.Equal(t, timeToPenalty, timerCount("score.time_to_penalty"))
+}
+
+func TestTimeToPenaltyBaselineIsClearedByPenalty(t *testing.T) {
+	// given
+	scorer, err := newTestScorer()
+	require.NoError(t, err)
+	app := &marathon.App{ID: "/baseline/app"}
+	scorer.initOrUpdateScore(Update{App: app, Update: 1})
+	score := scorer.scores[app.ID]
+	// when
+	scorer.markPenalty(app.ID, score)
+	// then
+	assert.True(t, score.firstUpdate.IsZero())
+	// when
+	scorer.initOrUpdateScore(Update{App: app, Update: 1})
+	// then
+	assert.False(t, score.firstUpdate.IsZero())
+}
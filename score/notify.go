@@ -0,0 +1,27 @@
+package score
+
+import (
+	"github.com/allegro/marathon-appcop/audit"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/notify"
+)
+
+// NotifyWith sends penalties to notifier, by default nobody is notified.
+// Must be called before ScoreManager is started.
+func (s *Scorer) NotifyWith(notifier notify.Notifier) {
+	s.notifier = notifier
+}
+
+// notify sends penalty in given phase, scale down of last instance is
+// notified as suspend. Must be called with mutex held.
+func (s *Scorer) notify(phase string, entry audit.Entry, app *marathon.App) {
+	event := notify.NewEvent(phase, entry)
+	if entry.Action == "scaleDown" && app.Instances == 1 {
+		event.Action = "suspend"
+	}
+	if score, ok := s.scores[app.ID]; ok {
+		event.Score = score.score
+	}
+	event.Labels = app.Labels
+	s.notifier.Notify(event)
+}
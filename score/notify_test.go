@@ -0,0 +1,76 @@
+package score
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/notify"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingNotifier keeps notified events
+type recordingNotifier struct {
+	mutex  sync.Mutex
+	events []notify.Event
+}
+
+func (r *recordingNotifier) Notify(event notify.Event) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingNotifier) phases() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	phases := []string{}
+	for _, event := range r.events {
+		phases = append(phases, event.Action+" "+event.Phase)
+	}
+	return phases
+}
+
+var notifyTestCases = []struct {
+	instances int
+	dryRun    bool
+	immune    bool
+	expected  []string
+}{
+	{instances: 2, expected: []string{"scaleDown before", "scaleDown after"}},
+	{instances: 1, expected: []string{"suspend before", "suspend after"}},
+	{instances: 2, dryRun: true, expected: []string{"scaleDown after"}},
+	{instances: 2, immune: true, expected: []string{}},
+}
+
+func TestPenaltyIsNotifiedBeforeAndAfterAction(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range notifyTestCases {
+		// given
+		app := &marathon.App{ID: "/notified", Instances: testCase.instances, Labels: map[string]string{"owner": "team"}}
+		m := marathon.NewMStub()
+		m.Apps = []*marathon.App{app}
+		scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2,
+			ScaleLimit: 1, DryRun: testCase.dryRun}, m)
+		require.NoError(t, err)
+		notifier := &recordingNotifier{}
+		scorer.NotifyWith(notifier)
+		if testCase.immune {
+			scorer.GrantImmunity(app.ID, time.Hour, "alice", "deploying fix")
+		}
+		scorer.initOrUpdateScore(Update{App: app, Update: 3})
+		// when
+		_, _ = scorer.evaluateApps(context.Background())
+		// then
+		assert.Equal(t, testCase.expected, notifier.phases())
+		for _, event := range notifier.events {
+			assert.Equal(t, "/notified", event.AppID)
+			assert.Equal(t, 3, event.Score)
+			assert.Equal(t, testCase.dryRun, event.DryRun)
+			assert.Equal(t, map[string]string{"owner": "team"}, event.Labels)
+		}
+	}
+}
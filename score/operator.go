@@ -0,0 +1,102 @@
+package score
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/audit"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+// ImmunityError is returned when penalty is not taken because application
+// is immune
+type ImmunityError struct {
+	AppID marathon.AppID
+}
+
+func (e ImmunityError) Error() string {
+	return fmt.Sprintf("app: %s has immunity", e.AppID)
+}
+
+// Reset forgets score of application on operator request
+func (s *Scorer) Reset(appID marathon.AppID, actor, reason string) {
+	s.resetScore(appID)
+	metrics.Mark("score.operator.reset")
+	audit.Log(audit.Entry{Action: "resetScore", Target: appID.String(), Actor: actor, Reason: reason})
+}
+
+// Pardon lowers score of application by threshold on operator request.
+// Returns false and records nothing when application has no score.
+func (s *Scorer) Pardon(appID marathon.AppID, actor, reason string) bool {
+	if !s.subtractScore(appID) {
+		return false
+	}
+	metrics.Mark("score.operator.pardon")
+	audit.Log(audit.Entry{Action: "pardon", Target: appID.String(), Actor: actor, Reason: reason,
+		Details: log.Fields{"subtracted": s.ScaleDownScore}})
+	return true
+}
+
+// GrantImmunity excuses application from penalties for duration without
+// APP_IMMUNITY label. Immunity is held in memory of this replica only, it is
+// not persisted as label, because Marathon restarts tasks when labels change.
+func (s *Scorer) GrantImmunity(appID marathon.AppID, duration time.Duration, actor, reason string) {
+	until := time.Now().Add(duration)
+	s.mutex.Lock()
+	s.immunities[appID] = until
+	s.mutex.Unlock()
+	metrics.Mark("score.operator.immunity")
+	audit.Log(audit.Entry{Action: "grantImmunity", Target: appID.String(), Actor: actor, Reason: reason,
+		Details: log.Fields{"until": until.UTC().Format(time.RFC3339)}})
+}
+
+// RevokeImmunity withdraws immunity granted with GrantImmunity
+func (s *Scorer) RevokeImmunity(appID marathon.AppID, actor, reason string) {
+	s.mutex.Lock()
+	delete(s.immunities, appID)
+	s.mutex.Unlock()
+	audit.Log(audit.Entry{Action: "revokeImmunity", Target: appID.String(), Actor: actor, Reason: reason})
+}
+
+// Penalize takes penalty against application regardless of its score on
+// operator request. With dryRun set penalty is only recorded.
+func (s *Scorer) Penalize(ctx context.Context, appID marathon.AppID, dryRun bool, actor, reason string) error {
+	metrics.Mark("score.operator.penalize")
+	return s.penalize(ctx, appID, dryRun || s.DryRun, actor, reason)
+}
+
+// Immune check if application has APP_IMMUNITY label or unexpired immunity
+// granted with GrantImmunity
+func (s *Scorer) Immune(app *marathon.App) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.immune(app)
+}
+
+// immune must be called with mutex held
+func (s *Scorer) immune(app *marathon.App) bool {
+	return app.HasImmunity() || !s.immuneUntil(app.ID).IsZero()
+}
+
+// immuneUntil returns end of granted immunity, zero when application has
+// none. Must be called with mutex held.
+func (s *Scorer) immuneUntil(appID marathon.AppID) time.Time {
+	until, ok := s.immunities[appID]
+	if !ok || time.Now().After(until) {
+		return time.Time{}
+	}
+	return until
+}
+
+// expireImmunities must be called with mutex held
+func (s *Scorer) expireImmunities() {
+	now := time.Now()
+	for appID, until := range s.immunities {
+		if now.After(until) {
+			delete(s.immunities, appID)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package score
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOperatorScorer(t *testing.T, m marathon.MStub) *Scorer {
+	scorer, err := New(Config{ScaleDownScore: 5, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, m)
+	require.NoError(t, err)
+	return scorer
+}
+
+func TestResetAndPardonAdjustScore(t *testing.T) {
+	t.Parallel()
+	// given
+	scorer := newTestOperatorScorer(t, marathon.MStub{})
+	scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "/reset"}, Update: 7})
+	scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "/pardon"}, Update: 7})
+	// when
+	scorer.Reset("/reset", "alice", "incident fixed")
+	pardonedScored := scorer.Pardon("/pardon", "alice", "incident fixed")
+	pardonedUnknown := scorer.Pardon("/unknown", "alice", "incident fixed")
+	// then
+	_, found := scorer.AppScore("/reset")
+	assert.False(t, found)
+	pardoned, _ := scorer.AppScore("/pardon")
+	assert.Equal(t, 2, pardoned.Score)
+	assert.True(t, pardonedScored)
+	assert.False(t, pardonedUnknown)
+	_, found = scorer.AppScore("/unknown")
+	assert.False(t, found)
+}
+
+func TestGrantedImmunityProtectsFromPenaltyUntilRevokedOrExpired(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Apps = []*marathon.App{{ID: "/app", Instances: 2}}
+	scorer := newTestOperatorScorer(t, m)
+	scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "/app"}, Update: 7})
+	// when
+	scorer.GrantImmunity("/app", time.Hour, "alice", "investigating")
+	appScore, _ := scorer.AppScore("/app")
+	err := scorer.scaleDown(context.Background(), "/app")
+	// then
+	assert.Equal(t, ImmunityError{AppID: "/app"}, err)
+	assert.True(t, appScore.Immune)
+	assert.False(t, appScore.ImmuneUntil.IsZero())
+	assert.Equal(t, 0, m.ScaleCounter.Counter)
+
+	// when
+	scorer.RevokeImmunity("/app", "alice", "investigated")
+	err = scorer.scaleDown(context.Background(), "/app")
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 1, m.ScaleCounter.Counter)
+
+	// when
+	scorer.GrantImmunity("/app", -time.Second, "alice", "already expired")
+	scorer.resetScores()
+	// then
+	assert.Empty(t, scorer.immunities)
+}
+
+func TestImmuneWhenLabeledOrGranted(t *testing.T) {
+	t.Parallel()
+	// given
+	scorer := newTestOperatorScorer(t, marathon.MStub{})
+	labeled := &marathon.App{ID: "/labeled", Labels: map[string]string{marathon.ApplicationImmunityLabel: "true"}}
+	granted := &marathon.App{ID: "/granted"}
+	expired := &marathon.App{ID: "/expired"}
+	// when
+	scorer.GrantImmunity("/granted", time.Hour, "alice", "investigating")
+	scorer.GrantImmunity("/expired", -time.Second, "alice", "investigated")
+	// then
+	assert.True(t, scorer.Immune(labeled))
+	assert.True(t, scorer.Immune(granted))
+	assert.False(t, scorer.Immune(expired))
+	assert.False(t, scorer.Immune(&marathon.App{ID: "/other"}))
+}
+
+func TestGrantedImmunityIsListedWithoutScore(t *testing.T) {
+	t.Parallel()
+	// given
+	scorer := newTestOperatorScorer(t, marathon.MStub{})
+	// when
+	scorer.GrantImmunity("/app", time.Hour, "alice", "deploying fix")
+	// then
+	scores := scorer.Scores()
+	require.Len(t, scores, 1)
+	assert.Equal(t, marathon.AppID("/app"), scores[0].AppID)
+	assert.True(t, scores[0].Immune)
+}
+
+func TestDryRunPenalizeOfImmuneAppRecordsNoPenalty(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Apps = []*marathon.App{{ID: "/app", Instances: 2}}
+	scorer := newTestOperatorScorer(t, m)
+	scorer.GrantImmunity("/app", time.Hour, "alice", "investigating")
+	// when
+	err := scorer.Penalize(context.Background(), "/app", true, "alice", "rehearsal")
+	appScore, _ := scorer.AppScore("/app")
+	// then
+	assert.Equal(t, ImmunityError{AppID: "/app"}, err)
+	assert.Nil(t, appScore.LastPenalty)
+}
+
+func TestPenalizeTakesPenaltyRegardlessOfScore(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Apps = []*marathon.App{{ID: "/app", Instances: 2}}
+	scorer := newTestOperatorScorer(t, m)
+	// when
+	dryRunErr := scorer.Penalize(context.Background(), "/app", true, "alice", "rehearsal")
+	dryRun, _ := scorer.AppScore("/app")
+	err := scorer.Penalize(context.Background(), "/app", false, "alice", "misbehaving")
+	penalized, _ := scorer.AppScore("/app")
+	// then
+	require.NoError(t, dryRunErr)
+	require.NotNil(t, dryRun.LastPenalty)
+	assert.True(t, dryRun.LastPenalty.DryRun)
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.ScaleCounter.Counter)
+	require.NotNil(t, penalized.LastPenalty)
+	assert.False(t, penalized.LastPenalty.DryRun)
+	assert.Equal(t, "alice", penalized.LastPenalty.Actor)
+	assert.Equal(t, 0, penalized.LastPenalty.Score)
+}
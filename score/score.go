@@ -1,21 +1,34 @@
 package score
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/audit"
 	"github.com/allegro/marathon-appcop/marathon"
 	"github.com/allegro/marathon-appcop/metrics"
+	"github.com/allegro/marathon-appcop/notify"
 )
 
+// restartThreshold is minimal number of failures of single instance, which
+// makes its task a candidate to kill
+const restartThreshold = 2
+
 // Score contains score value to update, struct keeped inside Scorer as value as
 // value as value as value
 type Score struct {
 	score      int
 	lastUpdate time.Time
+	// firstUpdate is time of first failure counted in score since last
+	// penalty
+	firstUpdate time.Time
+	// immune is set when application had immunity label at last update
+	immune bool
 }
 
 // Scorer keeps records of all applications behaviour on marathon
@@ -27,8 +40,29 @@ type Scorer struct {
 	EvaluateInterval time.Duration
 	DryRun           bool
 	ScaleLimit       int
+	Enforcement      string
 	service          marathon.Marathoner
 	scores           map[marathon.AppID]*Score
+	// appIDPrefix is trimmed from application id in metrics
+	appIDPrefix string
+	notifier    notify.Notifier
+	// failedInstances counts failures of application tasks per restartKey
+	failedInstances map[marathon.AppID]map[string]int
+	// failureReasons counts task failures of application per reason
+	failureReasons map[marathon.AppID]map[string]int
+	// penalties keeps last penalty taken against application, it is not
+	// reset with scores but expires after ResetInterval
+	penalties map[marathon.AppID]Penalty
+	// immunities keeps end of immunity granted by operator
+	immunities map[marathon.AppID]time.Time
+	// quit stops ScoreManager, done is closed when it stopped and inflight
+	// tracks evaluations in progress
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	inflight sync.WaitGroup
+	// paused is 1 when apps are not evaluated, accessed atomically
+	paused int32
 }
 
 // Update struct for scoring specific app
@@ -36,6 +70,11 @@ type Update struct {
 	// TODO(tz) to consider, store only AppID
 	App    *marathon.App
 	Update int
+	// Task which failed and Host it was running on, empty if unknown
+	Task marathon.TaskID
+	Host string
+	// Reason of task failure reported by Mesos, empty if unknown
+	Reason string
 }
 
 // New creates new scorer instance
@@ -49,6 +88,19 @@ func New(config Config, m marathon.Marathoner) (*Scorer, error) {
 		return nil, errors.New("ResetInterval should be lower than EvaluateInterval")
 	}
 
+	enforcement := config.Enforcement
+	if enforcement == "" {
+		enforcement = EnforcementScale
+	}
+	if enforcement != EnforcementScale && enforcement != EnforcementKillTasks {
+		return nil, fmt.Errorf("unknown enforcement mode %q", config.Enforcement)
+	}
+
+	appIDPrefix := ""
+	if m != nil {
+		appIDPrefix = m.GetAppIDPrefix()
+	}
+
 	return &Scorer{
 		ScaleDownScore:   config.ScaleDownScore,
 		ResetInterval:    config.ResetInterval,
@@ -56,14 +108,24 @@ func New(config Config, m marathon.Marathoner) (*Scorer, error) {
 		EvaluateInterval: config.EvaluateInterval,
 		ScaleLimit:       config.ScaleLimit,
 		DryRun:           config.DryRun,
+		Enforcement:      enforcement,
 		service:          m,
+		appIDPrefix:      appIDPrefix,
+		notifier:         notify.Noop{},
 		scores:           make(map[marathon.AppID]*Score),
+		failedInstances:  make(map[marathon.AppID]map[string]int),
+		failureReasons:   make(map[marathon.AppID]map[string]int),
+		penalties:        make(map[marathon.AppID]Penalty),
+		immunities:       make(map[marathon.AppID]time.Time),
 	}, nil
 }
 
-// ScoreManager starts Scorer job
-func (s *Scorer) ScoreManager() chan Update {
+// ScoreManager starts Scorer job, job is running until Stop is called or
+// provided context is cancelled.
+func (s *Scorer) ScoreManager(ctx context.Context) chan Update {
 	updates := make(chan Update)
+	s.quit = make(chan struct{})
+	s.done = make(chan struct{})
 
 	log.Info("Starting ScoreManager")
 	if s.DryRun {
@@ -74,11 +136,29 @@ func (s *Scorer) ScoreManager() chan Update {
 	resetTimer := time.NewTicker(s.ResetInterval)
 
 	go func() {
+		defer printTicker.Stop()
+		defer evaluateTicker.Stop()
+		defer resetTimer.Stop()
+		defer close(s.done)
 		for {
 			select {
+			case <-ctx.Done():
+				log.Info("Stopping ScoreManager")
+				return
+			case <-s.quit:
+				log.Info("Stopping ScoreManager")
+				return
 			case <-evaluateTicker.C:
+				if s.Paused() {
+					metrics.Mark("score.evaluates.paused")
+					continue
+				}
 				metrics.Mark("score.evaluates")
-				go s.EvaluateApps()
+				s.inflight.Add(1)
+				go func() {
+					defer s.inflight.Done()
+					s.EvaluateApps(ctx)
+				}()
 			case <-printTicker.C:
 				// Only used for debug purposes
 				go s.printScores()
@@ -94,6 +174,42 @@ func (s *Scorer) ScoreManager() chan Update {
 	return updates
 }
 
+// Pause evaluating apps until Resume is called. Collected scores are reset,
+// they are stale by the time evaluation is resumed.
+func (s *Scorer) Pause() {
+	if atomic.SwapInt32(&s.paused, 1) == 0 {
+		log.Info("Pausing ScoreManager")
+		s.resetScores()
+	}
+}
+
+// Resume evaluating apps
+func (s *Scorer) Resume() {
+	if atomic.SwapInt32(&s.paused, 0) == 1 {
+		log.Info("Resuming ScoreManager")
+	}
+}
+
+// Paused tells whether apps evaluation is paused
+func (s *Scorer) Paused() bool {
+	return atomic.LoadInt32(&s.paused) == 1
+}
+
+// Stop ScoreManager, no more updates are received and apps are not evaluated
+func (s *Scorer) Stop() {
+	s.stopOnce.Do(func() {
+		if s.quit != nil {
+			close(s.quit)
+			<-s.done
+		}
+	})
+}
+
+// Wait for evaluations (and scale downs) in progress
+func (s *Scorer) Wait() {
+	s.inflight.Wait()
+}
+
 func (s *Scorer) initOrUpdateScore(u Update) {
 	log.WithFields(log.Fields{
 		"appId":       u.App.ID,
@@ -105,11 +221,32 @@ func (s *Scorer) initOrUpdateScore(u Update) {
 	su := u.Update
 	now := time.Now()
 
-	if appScore, isScored := s.scores[u.App.ID]; isScored {
+	appScore, isScored := s.scores[u.App.ID]
+	if isScored {
 		appScore.score += su
 		appScore.lastUpdate = now
+		appScore.immune = u.App.HasImmunity()
+		// baseline is cleared by penalty
+		if appScore.firstUpdate.IsZero() {
+			appScore.firstUpdate = now
+		}
 	} else {
-		s.scores[u.App.ID] = &Score{score: su, lastUpdate: now}
+		appScore = &Score{score: su, lastUpdate: now, firstUpdate: now, immune: u.App.HasImmunity()}
+		s.scores[u.App.ID] = appScore
+	}
+	s.updateScoreGauge(u.App.ID, appScore.score)
+
+	if key := restartKey(u.Task, u.Host); key != "" {
+		if _, ok := s.failedInstances[u.App.ID]; !ok {
+			s.failedInstances[u.App.ID] = make(map[string]int)
+		}
+		s.failedInstances[u.App.ID][key]++
+	}
+	if u.Reason != "" {
+		if _, ok := s.failureReasons[u.App.ID]; !ok {
+			s.failureReasons[u.App.ID] = make(map[string]int)
+		}
+		s.failureReasons[u.App.ID][u.Reason]++
 	}
 	s.mutex.Unlock()
 }
@@ -118,63 +255,82 @@ func (s *Scorer) initOrUpdateScore(u Update) {
 func (s *Scorer) resetScore(appID marathon.AppID) {
 	s.mutex.Lock()
 
+	if _, ok := s.scores[appID]; ok {
+		s.updateScoreGauge(appID, 0)
+	}
 	delete(s.scores, appID)
+	delete(s.failedInstances, appID)
+	delete(s.failureReasons, appID)
 	s.mutex.Unlock()
 }
 
 // Substracts score by configured treshold
-// Noop if appID not exists
-func (s *Scorer) subtractScore(appID marathon.AppID) {
+// Noop returning false if appID not exists
+func (s *Scorer) subtractScore(appID marathon.AppID) bool {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	var ok bool
 	var score *Score
 	if score, ok = s.scores[appID]; !ok {
-		return
+		return false
 	}
 
 	score.score -= s.ScaleDownScore
+	s.updateScoreGauge(appID, score.score)
+	return true
 }
 
 func (s *Scorer) resetScores() {
+	s.mutex.Lock()
 	log.WithFields(log.Fields{
 		"ScoresRecorded": len(s.scores),
 	}).Debug("Reseting scores")
 
-	s.mutex.Lock()
-
+	for appID := range s.scores {
+		s.updateScoreGauge(appID, 0)
+	}
 	s.scores = make(map[marathon.AppID]*Score)
+	s.failedInstances = make(map[marathon.AppID]map[string]int)
+	s.failureReasons = make(map[marathon.AppID]map[string]int)
+	s.expireImmunities()
+	s.expirePenalties()
 	s.mutex.Unlock()
 }
 
 // EvaluateApps checks apps scores and if any is higher on score than limit,
 // scale them down by one instance
-func (s *Scorer) EvaluateApps() {
+func (s *Scorer) EvaluateApps(ctx context.Context) {
 
-	i, err := s.evaluateApps()
+	s.updateScoreDistribution()
+	i, err := s.evaluateApps(ctx)
 	if err != nil && i == 0 {
 		log.WithError(err).Error("Failed to evaluate")
 	}
 	log.Debugf("%d apps qualified for penalty", i)
 }
 
-func (s *Scorer) evaluateApps() (int, error) {
+func (s *Scorer) evaluateApps(ctx context.Context) (int, error) {
 	limit := 2
 	i := 0
 	var lastErr error
 
-	for appID, score := range s.scores {
+	// scores are modified by updates, resets and operators while apps are
+	// scaled down, so candidates are copied
+	for _, appID := range s.candidates() {
+		// leadership lost while evaluating
+		if s.Paused() {
+			break
+		}
 
-		curScore := score.score
 		// TODO(tz) - implement proper rate limiter with shared state accross goroutines
 		// and configurable
 		// https://gobyexample.com/rate-limiting
-		if !(curScore > s.ScaleDownScore && i <= limit) {
+		if !(s.aboveThreshold(appID) && i <= limit) {
 			continue
 		}
 
-		err := s.scaleDown(appID)
+		err := s.scaleDown(ctx, appID)
 		if err != nil {
 			lastErr = err
 			log.WithFields(log.Fields{
@@ -195,41 +351,163 @@ func (s *Scorer) evaluateApps() (int, error) {
 	return i, lastErr
 }
 
-func (s *Scorer) scaleDown(appID marathon.AppID) error {
+// candidates returns applications with score above threshold
+func (s *Scorer) candidates() []marathon.AppID {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var appIDs []marathon.AppID
+	for appID, score := range s.scores {
+		if score.score > s.ScaleDownScore {
+			appIDs = append(appIDs, appID)
+		}
+	}
+	return appIDs
+}
+
+// aboveThreshold tells whether score of application is still above
+// threshold, it may have been reset since candidates were copied
+func (s *Scorer) aboveThreshold(appID marathon.AppID) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	score, ok := s.scores[appID]
+	return ok && score.score > s.ScaleDownScore
+}
+
+func (s *Scorer) scaleDown(ctx context.Context, appID marathon.AppID) error {
+	return s.penalize(ctx, appID, s.DryRun, audit.Actor, "score above threshold")
+}
+
+// penalize scales application down or kills its tasks depending on
+// enforcement mode, application may have no score when penalty was
+// requested by operator
+func (s *Scorer) penalize(ctx context.Context, appID marathon.AppID, dryRun bool, actor, reason string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	score := 0
+	if appScore, ok := s.scores[appID]; ok {
+		score = appScore.score
+	}
 	log.WithFields(log.Fields{
 		"appId": appID,
-		"score": s.scores[appID].score,
+		"score": score,
 	}).Info("Scaling down application")
 
-	app, err := s.service.AppGet(appID)
+	app, err := s.service.AppGet(ctx, appID)
 	if err != nil {
 		return err
 	}
 
+	if s.immune(app) {
+		// returning error up makes sure rate limiting works,
+		// otherwise AppCop could loop over immune apps
+		return ImmunityError{AppID: app.ID}
+	}
+
+	entry := audit.Entry{
+		Action:  "scaleDown",
+		Target:  appID.String(),
+		Actor:   actor,
+		Reason:  reason,
+		DryRun:  dryRun,
+		Details: log.Fields{"score": score, "threshold": s.ScaleDownScore},
+	}
+	if reasons := s.failureReasons[appID]; len(reasons) > 0 {
+		entry.Details["reasons"] = reasons
+	}
+
+	// with single instance left application is suspended by scale down,
+	// so AppScaleDown can put appcop label on it
+	var tasks []marathon.TaskID
+	if s.Enforcement == EnforcementKillTasks && app.Instances > 1 {
+		var selection string
+		tasks, selection = s.selectTasks(app)
+		if len(tasks) > 0 {
+			entry.Action = "killTasks"
+			entry.Details["tasks"] = tasks
+			entry.Details["selection"] = selection
+		}
+	}
+
 	// dry-run flag
-	if s.DryRun {
+	if dryRun {
 		log.WithFields(log.Fields{
 			"appId": appID,
-			"score": s.scores[appID].score,
+			"score": score,
 		}).Info("NOOP - App Scale Down")
+		s.recordPenalty(entry)
+		audit.Log(entry)
+		s.notify(notify.PhaseAfter, entry, app)
 		return nil
 	}
 
-	if app.HasImmunity() {
-		// returning error up makes sure rate limiting works,
-		// otherwise AppCop could loop over immune apps
-		return fmt.Errorf("app: %s has immunity", app.ID)
-	}
+	s.notify(notify.PhaseBefore, entry, app)
 
-	err = s.service.AppScaleDown(app)
+	if len(tasks) > 0 {
+		err = s.service.TasksKill(ctx, tasks)
+	} else {
+		err = s.service.AppScaleDown(ctx, app)
+	}
+	entry.Err = err
+	s.recordPenalty(entry)
+	audit.Log(entry)
+	s.notify(notify.PhaseAfter, entry, app)
 	return err
 
 }
 
+// selectTasks picks task to kill, first unhealthy task with most consecutive
+// health check failures, then task of instance which failed most often, at
+// least restartThreshold times. Returns no tasks when none of them qualifies.
+// Must be called with mutex held.
+func (s *Scorer) selectTasks(app *marathon.App) ([]marathon.TaskID, string) {
+	var unhealthy *marathon.Task
+	for i, task := range app.Tasks {
+		if !task.IsUnhealthy() {
+			continue
+		}
+		if unhealthy == nil || task.ConsecutiveFailures() > unhealthy.ConsecutiveFailures() {
+			unhealthy = &app.Tasks[i]
+		}
+	}
+	if unhealthy != nil {
+		return []marathon.TaskID{unhealthy.ID}, "unhealthy"
+	}
+
+	var restarting *marathon.Task
+	failures := restartThreshold - 1
+	for i, task := range app.Tasks {
+		if f := s.failedInstances[app.ID][restartKey(task.ID, task.Host)]; f > failures {
+			failures = f
+			restarting = &app.Tasks[i]
+		}
+	}
+	if restarting != nil {
+		return []marathon.TaskID{restarting.ID}, "restarting"
+	}
+
+	return nil, ""
+}
+
+// restartKey identifies instance across restarts, as Marathon gives every
+// launched task new id. It is instance id when task id has one, otherwise
+// host task ran on, so failures of replacements on the same agent add up.
+func restartKey(id marathon.TaskID, host string) string {
+	if instance := id.InstanceID(); instance != "" {
+		return instance
+	}
+	if host != "" {
+		return "host:" + host
+	}
+	return string(id)
+}
+
 func (s *Scorer) printScores() {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
 	for app, score := range s.scores {
 		log.WithFields(log.Fields{
 			"app":   app,
@@ -1,16 +1,19 @@
 package score
 
 import (
+	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/notify"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func newTestScorer() (*Scorer, error) {
-	return New(Config{false, 1, 1, 3, 2, 1}, nil)
+	return New(Config{DryRun: false, ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, nil)
 }
 
 func TestNewProvidedConfigContainsUnsensibleValuesReturnsErrorAndNilScorer(t *testing.T) {
@@ -48,7 +51,13 @@ func TestNewReturnsCorrectlyInitializedScorerAndNoError(t *testing.T) {
 		UpdateInterval:   1,
 		EvaluateInterval: 2,
 		ScaleLimit:       1,
+		Enforcement:      EnforcementScale,
 		scores:           map[marathon.AppID]*Score{},
+		failedInstances:  map[marathon.AppID]map[string]int{},
+		failureReasons:   map[marathon.AppID]map[string]int{},
+		penalties:        map[marathon.AppID]Penalty{},
+		immunities:       map[marathon.AppID]time.Time{},
+		notifier:         notify.Noop{},
 	}
 	actualScorer, err := New(c, nil)
 	//then
@@ -65,7 +74,7 @@ var initOrUpdateTestCases = []struct {
 			{App: &marathon.App{ID: "appid"}, Update: 1},
 		},
 		expectedScores: map[marathon.AppID]*Score{
-			marathon.AppID("appid"): {1, time.Now()},
+			marathon.AppID("appid"): {score: 1, lastUpdate: time.Now()},
 		},
 	},
 	{
@@ -76,7 +85,7 @@ var initOrUpdateTestCases = []struct {
 			{App: &marathon.App{ID: "appid"}, Update: 1},
 		},
 		expectedScores: map[marathon.AppID]*Score{
-			marathon.AppID("appid"): {4, time.Now()},
+			marathon.AppID("appid"): {score: 4, lastUpdate: time.Now()},
 		},
 	},
 	{
@@ -87,8 +96,8 @@ var initOrUpdateTestCases = []struct {
 			{App: &marathon.App{ID: "appid1"}, Update: 1},
 		},
 		expectedScores: map[marathon.AppID]*Score{
-			marathon.AppID("appid0"): {2, time.Now()},
-			marathon.AppID("appid1"): {2, time.Now()},
+			marathon.AppID("appid0"): {score: 2, lastUpdate: time.Now()},
+			marathon.AppID("appid1"): {score: 2, lastUpdate: time.Now()},
 		},
 	},
 	{
@@ -99,8 +108,8 @@ var initOrUpdateTestCases = []struct {
 			{App: &marathon.App{ID: "appid1"}, Update: -1},
 		},
 		expectedScores: map[marathon.AppID]*Score{
-			marathon.AppID("appid0"): {0, time.Now()},
-			marathon.AppID("appid1"): {-2, time.Now()},
+			marathon.AppID("appid0"): {score: 0, lastUpdate: time.Now()},
+			marathon.AppID("appid1"): {score: -2, lastUpdate: time.Now()},
 		},
 	},
 	{
@@ -111,10 +120,10 @@ var initOrUpdateTestCases = []struct {
 			{App: &marathon.App{ID: "appid3"}, Update: -1},
 		},
 		expectedScores: map[marathon.AppID]*Score{
-			marathon.AppID("appid0"): {-1, time.Now()},
-			marathon.AppID("appid1"): {1, time.Now()},
-			marathon.AppID("appid2"): {-1, time.Now()},
-			marathon.AppID("appid3"): {-1, time.Now()},
+			marathon.AppID("appid0"): {score: -1, lastUpdate: time.Now()},
+			marathon.AppID("appid1"): {score: 1, lastUpdate: time.Now()},
+			marathon.AppID("appid2"): {score: -1, lastUpdate: time.Now()},
+			marathon.AppID("appid3"): {score: -1, lastUpdate: time.Now()},
 		},
 	},
 }
@@ -213,7 +222,7 @@ func TestSubstractScoresTestCases(t *testing.T) {
 		require.NoError(t, err)
 		// feed scores
 		for app, score := range testCase.initialScores {
-			scorer.scores[app] = &Score{score, time.Now()}
+			scorer.scores[app] = &Score{score: score, lastUpdate: time.Now()}
 		}
 		// actual substraction
 		for _, app := range testCase.appsToSubstractScoreFrom {
@@ -271,16 +280,15 @@ var evaluateScoresTestCases = []struct {
 func TestEvaluateScoresTestCases(t *testing.T) {
 	t.Parallel()
 	for _, testCase := range evaluateScoresTestCases {
-		scaleCounter := &marathon.ScaleCounter{Counter: 0}
-		m := marathon.MStub{ScaleCounter: scaleCounter}
-		scorer, err := New(Config{false, testCase.scaleDownScore, 1, 3, 2, 1}, m)
+		m := marathon.NewMStub()
+		scorer, err := New(Config{DryRun: false, ScaleDownScore: testCase.scaleDownScore, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, m)
 		require.NoError(t, err)
 		// feed scores
 		for app, score := range testCase.initialScores {
-			scorer.scores[app] = &Score{score, time.Now()}
+			scorer.scores[app] = &Score{score: score, lastUpdate: time.Now()}
 		}
 		// actual evaluation
-		appsToPacify, _ := scorer.evaluateApps()
+		appsToPacify, _ := scorer.evaluateApps(context.Background())
 		// check assertions
 		assert.Equal(t, testCase.expectedAppsToPacify, appsToPacify)
 		// expectedAppsToPacify equals ScaleCounter increments
@@ -291,16 +299,15 @@ func TestEvaluateScoresTestCases(t *testing.T) {
 func TestEvaluateScoresTestCasesWithDryRunTrue(t *testing.T) {
 	t.Parallel()
 	for _, testCase := range evaluateScoresTestCases {
-		scaleCounter := &marathon.ScaleCounter{Counter: 0}
-		m := marathon.MStub{ScaleCounter: scaleCounter}
-		scorer, err := New(Config{true, testCase.scaleDownScore, 1, 3, 2, 1}, m)
+		m := marathon.NewMStub()
+		scorer, err := New(Config{DryRun: true, ScaleDownScore: testCase.scaleDownScore, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, m)
 		require.NoError(t, err)
 		// feed scores
 		for app, score := range testCase.initialScores {
-			scorer.scores[app] = &Score{score, time.Now()}
+			scorer.scores[app] = &Score{score: score, lastUpdate: time.Now()}
 		}
 		// actual evaluation
-		appsToPacify, _ := scorer.evaluateApps()
+		appsToPacify, _ := scorer.evaluateApps(context.Background())
 		// check assertions
 		// check how many apps are above threshold
 		assert.Equal(t, testCase.expectedAppsToPacify, appsToPacify)
@@ -320,11 +327,11 @@ func TestScaleDownShouldReturnErrorWhenApplicationHasImmunityLabelSet(t *testing
 		Instances: 1,
 	}
 	m.Apps = []*marathon.App{app}
-	scorer, err := New(Config{false, 1, 1, 3, 2, 1}, m)
+	scorer, err := New(Config{DryRun: false, ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, m)
 	require.NoError(t, err)
-	scorer.scores[app.ID] = &Score{1, time.Now()}
+	scorer.scores[app.ID] = &Score{score: 1, lastUpdate: time.Now()}
 	// when
-	err = scorer.scaleDown("testApp0")
+	err = scorer.scaleDown(context.Background(), "testApp0")
 	// then
 	assert.Error(t, err)
 }
@@ -332,21 +339,280 @@ func TestScaleDownShouldReturnErrorWhenApplicationHasImmunityLabelSet(t *testing
 func TestScaleDownShouldReturnNoErrorAndScaleApplicationDownWhenNoImmunityLabelSet(t *testing.T) {
 	t.Parallel()
 	// given
-	scaleCounter := &marathon.ScaleCounter{Counter: 0}
-	m := marathon.MStub{ScaleCounter: scaleCounter}
+	m := marathon.NewMStub()
 	app := &marathon.App{
 		ID:        "testApp0",
 		Labels:    map[string]string{},
 		Instances: 1,
 	}
 	m.Apps = []*marathon.App{app}
-	scorer, err := New(Config{false, 1, 1, 3, 2, 1}, m)
-	scorer.scores[app.ID] = &Score{1, time.Now()}
+	scorer, err := New(Config{DryRun: false, ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, m)
+	scorer.scores[app.ID] = &Score{score: 1, lastUpdate: time.Now()}
 	require.NoError(t, err)
 	// when
-	err = scorer.scaleDown("testApp0")
+	err = scorer.scaleDown(context.Background(), "testApp0")
 	// then
 	expectedScale := 1
 	assert.NoError(t, err)
 	assert.Equal(t, expectedScale, m.ScaleCounter.Counter)
 }
+
+func TestNewReturnsErrorForUnknownEnforcementMode(t *testing.T) {
+	t.Parallel()
+	// given
+	c := Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1, Enforcement: "nuke"}
+	// when
+	scorer, err := New(c, nil)
+	// then
+	assert.Error(t, err)
+	assert.Nil(t, scorer)
+}
+
+var killTasksTestCases = []struct {
+	name          string
+	instances     int
+	tasks         []marathon.Task
+	failures      []marathon.Task
+	expectedKills []marathon.TaskID
+	expectedScale int
+}{
+	{
+		name:      "unhealthy task with most consecutive failures",
+		instances: 3,
+		tasks: []marathon.Task{
+			{ID: "app.1", Host: "a", HealthCheckResults: []marathon.HealthCheckResult{{Alive: true}}},
+			{ID: "app.2", Host: "b", HealthCheckResults: []marathon.HealthCheckResult{{Alive: false, ConsecutiveFailures: 2}}},
+			{ID: "app.3", Host: "c", HealthCheckResults: []marathon.HealthCheckResult{{Alive: false, ConsecutiveFailures: 5}}},
+		},
+		failures:      []marathon.Task{{ID: "app.0", Host: "a"}, {ID: "app.00", Host: "a"}},
+		expectedKills: []marathon.TaskID{"app.3"},
+	},
+	{
+		name:      "task on host where replacements failed most often",
+		instances: 3,
+		tasks: []marathon.Task{
+			{ID: "app.1", Host: "a"},
+			{ID: "app.2", Host: "b"},
+			{ID: "app.3", Host: "c"},
+		},
+		failures: []marathon.Task{{ID: "app.0", Host: "a"}, {ID: "app.00", Host: "b"}, {ID: "app.000", Host: "b"},
+			{ID: "app.0000", Host: "b"}, {ID: "app.00000", Host: "a"}},
+		expectedKills: []marathon.TaskID{"app.2"},
+	},
+	{
+		name:      "task of restarted instance",
+		instances: 2,
+		tasks: []marathon.Task{
+			{ID: "app.instance-1._app.3", Host: "a"},
+			{ID: "app.instance-2._app.1", Host: "a"},
+		},
+		failures:      []marathon.Task{{ID: "app.instance-1._app.1", Host: "b"}, {ID: "app.instance-1._app.2", Host: "c"}},
+		expectedKills: []marathon.TaskID{"app.instance-1._app.3"},
+	},
+	{
+		name:      "failures spread over hosts",
+		instances: 2,
+		tasks: []marathon.Task{
+			{ID: "app.1", Host: "a"},
+			{ID: "app.2", Host: "b"},
+		},
+		failures:      []marathon.Task{{ID: "app.0", Host: "a"}, {ID: "app.00", Host: "b"}, {ID: "app.000", Host: "c"}},
+		expectedScale: 1,
+	},
+	{
+		name:      "task failed once",
+		instances: 2,
+		tasks: []marathon.Task{
+			{ID: "app.1", Host: "a"},
+			{ID: "app.2", Host: "b"},
+		},
+		failures:      []marathon.Task{{ID: "app.1", Host: "a"}},
+		expectedScale: 1,
+	},
+	{
+		name:      "no task qualifies",
+		instances: 2,
+		tasks: []marathon.Task{
+			{ID: "app.1", Host: "a"},
+			{ID: "app.2", Host: "b"},
+		},
+		expectedScale: 1,
+	},
+	{
+		name:      "last instance is scaled down",
+		instances: 1,
+		tasks: []marathon.Task{
+			{ID: "app.1", Host: "a", HealthCheckResults: []marathon.HealthCheckResult{{Alive: false}}},
+		},
+		expectedScale: 1,
+	},
+}
+
+func TestScaleDownWithKillTasksEnforcementTestCases(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range killTasksTestCases {
+		// given
+		m := marathon.NewMStub()
+		m.Apps = []*marathon.App{{ID: "app", Instances: testCase.instances, Tasks: testCase.tasks}}
+		scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1,
+			Enforcement: EnforcementKillTasks}, m)
+		require.NoError(t, err)
+		scorer.scores["app"] = &Score{score: 2, lastUpdate: time.Now()}
+		for _, task := range testCase.failures {
+			scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "app"}, Update: 0, Task: task.ID, Host: task.Host})
+		}
+		// when
+		err = scorer.scaleDown(context.Background(), "app")
+		// then
+		require.NoError(t, err, testCase.name)
+		assert.Equal(t, testCase.expectedKills, m.KilledTasks.IDs, testCase.name)
+		assert.Equal(t, testCase.expectedScale, m.ScaleCounter.Counter, testCase.name)
+	}
+}
+
+func TestScaleDownWithKillTasksEnforcementKillsReplacementOfRepeatedlyFailingTask(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Apps = []*marathon.App{{ID: "/app", Instances: 2, Tasks: []marathon.Task{
+		{ID: "app.2f0c8a6e-2c56-11e8-9c4d-0242ac110002", Host: "agent-2"},
+		{ID: "app.9b1d7e3f-2c56-11e8-9c4d-0242ac110002", Host: "agent-1"},
+	}}}
+	scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1,
+		Enforcement: EnforcementKillTasks}, m)
+	require.NoError(t, err)
+	app := &marathon.App{ID: "/app"}
+	// task fails, Marathon launches replacement with new id on the same agent, which fails again
+	scorer.initOrUpdateScore(Update{App: app, Update: 1, Task: "app.6a1e5c4d-2c55-11e8-9c4d-0242ac110002", Host: "agent-1"})
+	scorer.initOrUpdateScore(Update{App: app, Update: 1, Task: "app.7c2b9f10-2c55-11e8-9c4d-0242ac110002", Host: "agent-1"})
+	// when
+	err = scorer.scaleDown(context.Background(), "/app")
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, []marathon.TaskID{"app.9b1d7e3f-2c56-11e8-9c4d-0242ac110002"}, m.KilledTasks.IDs)
+	assert.Equal(t, 0, m.ScaleCounter.Counter)
+}
+
+func TestScaleDownWithKillTasksEnforcementAndDryRunKillsNothing(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Apps = []*marathon.App{{ID: "app", Instances: 2, Tasks: []marathon.Task{
+		{ID: "app.1", HealthCheckResults: []marathon.HealthCheckResult{{Alive: false}}},
+	}}}
+	scorer, err := New(Config{DryRun: true, ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1,
+		Enforcement: EnforcementKillTasks}, m)
+	require.NoError(t, err)
+	scorer.scores["app"] = &Score{score: 2, lastUpdate: time.Now()}
+	// when
+	err = scorer.scaleDown(context.Background(), "app")
+	// then
+	assert.NoError(t, err)
+	assert.Empty(t, m.KilledTasks.IDs)
+	assert.Equal(t, 0, m.ScaleCounter.Counter)
+}
+
+func TestInitOrUpdateScoreCountsFailureReasonsUntilReset(t *testing.T) {
+	t.Parallel()
+	// given
+	scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, nil)
+	require.NoError(t, err)
+	app := &marathon.App{ID: "app"}
+	// when
+	scorer.initOrUpdateScore(Update{App: app, Update: 1, Reason: "REASON_CONTAINER_LIMITATION_MEMORY"})
+	scorer.initOrUpdateScore(Update{App: app, Update: 1, Reason: "REASON_CONTAINER_LIMITATION_MEMORY"})
+	scorer.initOrUpdateScore(Update{App: app, Update: 1})
+	// then
+	assert.Equal(t, map[string]int{"REASON_CONTAINER_LIMITATION_MEMORY": 2}, scorer.failureReasons["app"])
+	// when
+	scorer.resetScore("app")
+	// then
+	assert.Empty(t, scorer.failureReasons)
+}
+
+func TestPauseResetsScoresAndStopsEvaluation(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Apps = []*marathon.App{{ID: "app", Instances: 2}}
+	scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, m)
+	require.NoError(t, err)
+	scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "app"}, Update: 5})
+	// when
+	scorer.Pause()
+	scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "app"}, Update: 5})
+	// then
+	assert.Equal(t, 5, scorer.scores["app"].score)
+	// when
+	n, err := scorer.evaluateApps(context.Background())
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 0, m.ScaleCounter.Counter)
+	// when
+	scorer.Resume()
+	n, err = scorer.evaluateApps(context.Background())
+	// then
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, m.ScaleCounter.Counter)
+}
+
+func TestEvaluateAppsWhileScoresAreModifiedConcurrently(t *testing.T) {
+	t.Parallel()
+	// given
+	apps := []*marathon.App{}
+	for i := 0; i < 10; i++ {
+		apps = append(apps, &marathon.App{ID: marathon.AppID(fmt.Sprintf("/race%d", i)), Instances: 2})
+	}
+	m := marathon.NewMStub()
+	m.Apps = apps
+	scorer, err := New(Config{DryRun: true, ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: 3, EvaluateInterval: 2, ScaleLimit: 1}, m)
+	require.NoError(t, err)
+	modified := make(chan struct{})
+	go func() {
+		defer close(modified)
+		for i := 0; i < 50; i++ {
+			for _, app := range apps {
+				scorer.initOrUpdateScore(Update{App: app, Update: 5})
+			}
+			scorer.Reset(apps[i%len(apps)].ID, "alice", "race")
+			scorer.Pardon(apps[(i+1)%len(apps)].ID, "alice", "race")
+			scorer.Pause()
+			scorer.Resume()
+		}
+	}()
+	// when
+	evaluations := 0
+	for {
+		select {
+		case <-modified:
+			// then no concurrent map iteration and write
+			assert.NotZero(t, evaluations)
+			return
+		default:
+			_, err := scorer.evaluateApps(context.Background())
+			assert.NoError(t, err)
+			evaluations++
+		}
+	}
+}
+
+func TestStopEndsScoreManagerAndWaitReturns(t *testing.T) {
+	t.Parallel()
+	// given
+	scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: time.Millisecond, ResetInterval: time.Hour,
+		EvaluateInterval: time.Millisecond, ScaleLimit: 1}, marathon.NewMStub())
+	require.NoError(t, err)
+	updates := scorer.ScoreManager(context.Background())
+	updates <- Update{App: &marathon.App{ID: "app"}, Update: 1}
+	// when
+	scorer.Stop()
+	scorer.Wait()
+	// then
+	select {
+	case updates <- Update{App: &marathon.App{ID: "app"}, Update: 1}:
+		t.Fatal("update received after stop")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
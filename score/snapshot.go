@@ -0,0 +1,136 @@
+package score
+
+import (
+	"time"
+
+	"github.com/allegro/marathon-appcop/audit"
+	"github.com/allegro/marathon-appcop/marathon"
+)
+
+// Penalty describes action taken against application with score above
+// threshold
+type Penalty struct {
+	// Action is scaleDown or killTasks
+	Action string
+	// Actor who requested penalty, appcop when threshold was exceeded
+	Actor string
+	At    time.Time
+	// Score which triggered penalty
+	Score  int
+	DryRun bool
+	// Err is set when action failed
+	Err string
+}
+
+// AppScore is snapshot of application state kept by Scorer
+type AppScore struct {
+	AppID      marathon.AppID
+	Score      int
+	LastUpdate time.Time
+	// Immune is set when application had immunity label at last update or
+	// was granted immunity by operator
+	Immune bool
+	// ImmuneUntil is end of immunity granted by operator, zero when none
+	ImmuneUntil time.Time
+	LastPenalty *Penalty
+}
+
+// Threshold returns score above which application is penalized
+func (s *Scorer) Threshold() int {
+	return s.ScaleDownScore
+}
+
+// Scores returns snapshot of all applications with score, taken penalty or
+// granted immunity
+func (s *Scorer) Scores() []AppScore {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	scores := make([]AppScore, 0, len(s.scores))
+	for appID := range s.scores {
+		scores = append(scores, s.appScore(appID))
+	}
+	for appID := range s.penalties {
+		if _, ok := s.scores[appID]; !ok {
+			scores = append(scores, s.appScore(appID))
+		}
+	}
+	for appID := range s.immunities {
+		_, scored := s.scores[appID]
+		_, penalized := s.penalties[appID]
+		if !scored && !penalized && !s.immuneUntil(appID).IsZero() {
+			scores = append(scores, s.appScore(appID))
+		}
+	}
+	return scores
+}
+
+// AppScore returns snapshot of application, false when application has
+// neither score, taken penalty nor granted immunity
+func (s *Scorer) AppScore(appID marathon.AppID) (AppScore, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if !s.tracked(appID) {
+		return AppScore{AppID: appID}, false
+	}
+	return s.appScore(appID), true
+}
+
+// tracked must be called with mutex held
+func (s *Scorer) tracked(appID marathon.AppID) bool {
+	_, scored := s.scores[appID]
+	_, penalized := s.penalties[appID]
+	return scored || penalized || !s.immuneUntil(appID).IsZero()
+}
+
+// appScore must be called with mutex held
+func (s *Scorer) appScore(appID marathon.AppID) AppScore {
+	appScore := AppScore{AppID: appID}
+	if score, ok := s.scores[appID]; ok {
+		appScore.Score = score.score
+		appScore.LastUpdate = score.lastUpdate
+		appScore.Immune = score.immune
+	}
+	if until := s.immuneUntil(appID); !until.IsZero() {
+		appScore.Immune = true
+		appScore.ImmuneUntil = until
+	}
+	if penalty, ok := s.penalties[appID]; ok {
+		appScore.LastPenalty = &penalty
+	}
+	return appScore
+}
+
+// expirePenalties forgets penalties taken more than ResetInterval ago, so
+// deleted applications are not kept forever. Must be called with mutex held.
+func (s *Scorer) expirePenalties() {
+	expired := time.Now().Add(-s.ResetInterval)
+	for appID, penalty := range s.penalties {
+		if penalty.At.Before(expired) {
+			delete(s.penalties, appID)
+		}
+	}
+}
+
+// recordPenalty keeps audited action as last penalty of its target, must be
+// called with mutex held
+func (s *Scorer) recordPenalty(entry audit.Entry) {
+	appID := marathon.AppID(entry.Target)
+	penalty := Penalty{
+		Action: entry.Action,
+		Actor:  entry.Actor,
+		At:     time.Now(),
+		DryRun: entry.DryRun,
+	}
+	score, scored := s.scores[appID]
+	if scored {
+		penalty.Score = score.score
+	}
+	if entry.Err != nil {
+		penalty.Err = entry.Err.Error()
+	} else if !entry.DryRun {
+		s.markPenalty(appID, score)
+	}
+	s.penalties[appID] = penalty
+}
@@ -0,0 +1,77 @@
+package score
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/audit"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScoresReturnSnapshotWithImmunityAndLastPenalty(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.NewMStub()
+	m.Apps = []*marathon.App{{ID: "/penalized", Instances: 2}}
+	scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: time.Hour, EvaluateInterval: 2, ScaleLimit: 1}, m)
+	require.NoError(t, err)
+	immune := &marathon.App{ID: "/immune", Labels: map[string]string{marathon.ApplicationImmunityLabel: "true"}}
+	scorer.initOrUpdateScore(Update{App: immune, Update: 1})
+	scorer.initOrUpdateScore(Update{App: &marathon.App{ID: "/penalized"}, Update: 3})
+	// when
+	_, err = scorer.evaluateApps(context.Background())
+	scorer.resetScores()
+	immuneScore, immuneFound := scorer.AppScore("/immune")
+	penalized, penalizedFound := scorer.AppScore("/penalized")
+	_, unknownFound := scorer.AppScore("/unknown")
+	// then
+	require.NoError(t, err)
+	assert.Len(t, scorer.Scores(), 1, "penalty survives scores reset")
+	assert.False(t, immuneFound)
+	assert.Equal(t, AppScore{AppID: "/immune"}, immuneScore)
+	assert.False(t, unknownFound)
+	require.True(t, penalizedFound)
+	require.NotNil(t, penalized.LastPenalty)
+	assert.Equal(t, "scaleDown", penalized.LastPenalty.Action)
+	assert.Equal(t, audit.Actor, penalized.LastPenalty.Actor)
+	assert.Equal(t, 3, penalized.LastPenalty.Score)
+	assert.Empty(t, penalized.LastPenalty.Err)
+	assert.Equal(t, 1, scorer.Threshold())
+}
+
+func TestPenaltyExpiresAfterResetInterval(t *testing.T) {
+	t.Parallel()
+	// given
+	scorer, err := New(Config{ScaleDownScore: 1, UpdateInterval: 1, ResetInterval: time.Hour, EvaluateInterval: 2, ScaleLimit: 1},
+		marathon.MStub{})
+	require.NoError(t, err)
+	scorer.penalties["/recent"] = Penalty{Action: "scaleDown", At: time.Now().Add(-time.Minute)}
+	scorer.penalties["/deleted"] = Penalty{Action: "scaleDown", At: time.Now().Add(-2 * time.Hour)}
+	// when
+	scorer.resetScores()
+	// then
+	_, recentFound := scorer.AppScore("/recent")
+	_, deletedFound := scorer.AppScore("/deleted")
+	assert.True(t, recentFound)
+	assert.False(t, deletedFound)
+}
+
+func TestAppScoreReportsImmunity(t *testing.T) {
+	t.Parallel()
+	// given
+	scorer, err := newTestScorer()
+	require.NoError(t, err)
+	immune := &marathon.App{ID: "/immune", Labels: map[string]string{marathon.ApplicationImmunityLabel: "true"}}
+	// when
+	scorer.initOrUpdateScore(Update{App: immune, Update: 1})
+	appScore, found := scorer.AppScore("/immune")
+	// then
+	assert.True(t, found)
+	assert.True(t, appScore.Immune)
+	assert.Equal(t, 1, appScore.Score)
+	assert.False(t, appScore.LastUpdate.IsZero())
+	assert.Nil(t, appScore.LastPenalty)
+}
@@ -0,0 +1,206 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+// Overflow policies, tell what happens with event pushed to full queue
+const (
+	// PolicyBlock waits for free space, but not longer than block timeout,
+	// after that event is dropped
+	PolicyBlock = "block"
+	// PolicyDropOldest drops oldest event waiting in queue
+	PolicyDropOldest = "drop-oldest"
+	// PolicyDropNewest drops pushed event
+	PolicyDropNewest = "drop-newest"
+	// PolicyDropLowPriority drops event of type AppCop does not handle, pushed
+	// one or oldest waiting in queue. When queue holds only handled events
+	// (always the case for filtered subscription) it falls back to
+	// PolicyDropOldest.
+	PolicyDropLowPriority = "drop-low-priority"
+)
+
+// defaultBlockTimeout is used when block timeout is not set
+const defaultBlockTimeout = time.Second
+
+// boundedQueue is events queue with fixed capacity. Pushing never blocks
+// longer than block timeout, so reader of event stream is not stalled and
+// Marathon does not drop AppCop subscription. Queue supports single consumer.
+type boundedQueue struct {
+	mutex        sync.Mutex
+	events       []Event
+	capacity     int
+	policy       string
+	blockTimeout time.Duration
+	// notEmpty and notFull are signalled when event is pushed or popped
+	notEmpty chan struct{}
+	notFull  chan struct{}
+}
+
+func newBoundedQueue(capacity int, policy string, blockTimeout time.Duration) (*boundedQueue, error) {
+	if capacity <= 0 {
+		return nil, fmt.Errorf("queue capacity should be positive, got %d", capacity)
+	}
+	switch policy {
+	case "":
+		policy = PolicyBlock
+	case PolicyBlock, PolicyDropOldest, PolicyDropNewest, PolicyDropLowPriority:
+	default:
+		return nil, fmt.Errorf("unknown queue overflow policy %q", policy)
+	}
+	if blockTimeout < 0 {
+		return nil, fmt.Errorf("queue block timeout should not be negative, got %s", blockTimeout)
+	}
+	if blockTimeout == 0 {
+		blockTimeout = defaultBlockTimeout
+	}
+	return &boundedQueue{
+		events:       make([]Event, 0, capacity),
+		capacity:     capacity,
+		policy:       policy,
+		blockTimeout: blockTimeout,
+		notEmpty:     make(chan struct{}, 1),
+		notFull:      make(chan struct{}, 1),
+	}, nil
+}
+
+// push adds event to queue applying overflow policy when queue is full.
+// Returns false when pushed event was dropped.
+func (q *boundedQueue) push(ctx context.Context, e Event) bool {
+	q.mutex.Lock()
+	if len(q.events) >= q.capacity && q.policy == PolicyBlock {
+		q.mutex.Unlock()
+		if !q.waitNotFull(ctx) {
+			dropped(e)
+			if ctx.Err() == nil {
+				metrics.Mark("events.dropped.block_timeout")
+				log.WithFields(log.Fields{
+					"EventType": e.eventType,
+					"Timeout":   q.blockTimeout,
+				}).Warn("Events queue is full, dropping event after block timeout")
+			}
+			return false
+		}
+		q.mutex.Lock()
+	}
+	defer q.mutex.Unlock()
+
+	if len(q.events) >= q.capacity {
+		switch q.policy {
+		case PolicyDropNewest, PolicyBlock:
+			dropped(e)
+			return false
+		case PolicyDropLowPriority:
+			if isLowPriority(e) {
+				dropped(e)
+				return false
+			}
+			if i := q.lowPriorityIndex(); i >= 0 {
+				q.dropAt(i)
+			} else {
+				q.dropAt(0)
+			}
+		case PolicyDropOldest:
+			q.dropAt(0)
+		}
+	}
+
+	q.events = append(q.events, e)
+	signal(q.notEmpty)
+	return true
+}
+
+// waitNotFull waits until queue has free space, block timeout passes or
+// ctx is done
+func (q *boundedQueue) waitNotFull(ctx context.Context) bool {
+	timeout := time.NewTimer(q.blockTimeout)
+	defer timeout.Stop()
+	for {
+		q.mutex.Lock()
+		full := len(q.events) >= q.capacity
+		q.mutex.Unlock()
+		if !full {
+			return true
+		}
+		select {
+		case <-q.notFull:
+		case <-timeout.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// pop removes oldest event from queue, returns false when queue is empty
+func (q *boundedQueue) pop() (Event, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.events) == 0 {
+		return Event{}, false
+	}
+	e := q.events[0]
+	q.events[0] = Event{}
+	q.events = q.events[1:]
+	signal(q.notFull)
+	return e, true
+}
+
+// ready is signalled when events were pushed to queue
+func (q *boundedQueue) ready() <-chan struct{} {
+	return q.notEmpty
+}
+
+func (q *boundedQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.events)
+}
+
+// lowPriorityIndex returns index of oldest low priority event or -1 when
+// there is none, must be called with mutex held
+func (q *boundedQueue) lowPriorityIndex() int {
+	for i, e := range q.events {
+		if isLowPriority(e) {
+			return i
+		}
+	}
+	return -1
+}
+
+// dropAt removes event at provided index, must be called with mutex held
+func (q *boundedQueue) dropAt(i int) {
+	dropped(q.events[i])
+	q.events = append(q.events[:i], q.events[i+1:]...)
+}
+
+func isLowPriority(e Event) bool {
+	for _, eventType := range handledEventTypes {
+		if e.eventType == eventType {
+			return false
+		}
+	}
+	return true
+}
+
+func dropped(e Event) {
+	eventType := e.eventType
+	if eventType == "" {
+		eventType = "unknown"
+	}
+	metrics.Mark("events.dropped")
+	metrics.Mark("events.dropped." + eventType)
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
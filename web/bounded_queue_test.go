@@ -0,0 +1,161 @@
+package web
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	attached = Event{eventType: "event_stream_attached"}
+	failed1  = Event{eventType: statusUpdateEvent, id: "1"}
+	failed2  = Event{eventType: statusUpdateEvent, id: "2"}
+	failed3  = Event{eventType: statusUpdateEvent, id: "3"}
+)
+
+func TestNewBoundedQueueReturnsErrorForUnknownPolicy(t *testing.T) {
+	t.Parallel()
+	// when
+	queue, err := newBoundedQueue(1, "drop-everything", 0)
+	// then
+	assert.Error(t, err)
+	assert.Nil(t, queue)
+}
+
+var overflowTestCases = []struct {
+	policy   string
+	pushed   []Event
+	accepted []bool
+	expected []Event
+}{
+	{
+		policy:   PolicyDropNewest,
+		pushed:   []Event{failed1, failed2, failed3},
+		accepted: []bool{true, true, false},
+		expected: []Event{failed1, failed2},
+	},
+	{
+		policy:   PolicyDropOldest,
+		pushed:   []Event{failed1, failed2, failed3},
+		accepted: []bool{true, true, true},
+		expected: []Event{failed2, failed3},
+	},
+	{
+		policy:   PolicyDropLowPriority,
+		pushed:   []Event{failed1, attached, failed2},
+		accepted: []bool{true, true, true},
+		expected: []Event{failed1, failed2},
+	},
+	{
+		policy:   PolicyDropLowPriority,
+		pushed:   []Event{failed1, failed2, attached},
+		accepted: []bool{true, true, false},
+		expected: []Event{failed1, failed2},
+	},
+	{
+		policy:   PolicyDropLowPriority,
+		pushed:   []Event{failed1, failed2, failed3},
+		accepted: []bool{true, true, true},
+		expected: []Event{failed2, failed3},
+	},
+	{
+		policy:   PolicyBlock,
+		pushed:   []Event{failed1, failed2, failed3},
+		accepted: []bool{true, true, false},
+		expected: []Event{failed1, failed2},
+	},
+}
+
+func TestBoundedQueueOverflowTestCases(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range overflowTestCases {
+		// given
+		queue, err := newBoundedQueue(2, testCase.policy, 10*time.Millisecond)
+		require.NoError(t, err)
+		// when
+		var accepted []bool
+		for _, e := range testCase.pushed {
+			accepted = append(accepted, queue.push(context.Background(), e))
+		}
+		// then
+		assert.Equal(t, testCase.accepted, accepted, testCase.policy)
+		var popped []Event
+		for e, ok := queue.pop(); ok; e, ok = queue.pop() {
+			popped = append(popped, e)
+		}
+		assert.Equal(t, testCase.expected, popped, testCase.policy)
+	}
+}
+
+func TestNewBoundedQueueReturnsErrorForNegativeBlockTimeout(t *testing.T) {
+	t.Parallel()
+	// when
+	queue, err := newBoundedQueue(1, PolicyBlock, -time.Second)
+	// then
+	assert.Error(t, err)
+	assert.Nil(t, queue)
+}
+
+func TestBoundedQueueBlockPolicyWaitsForFreeSpace(t *testing.T) {
+	t.Parallel()
+	// given
+	queue, err := newBoundedQueue(1, PolicyBlock, time.Second)
+	require.NoError(t, err)
+	queue.push(context.Background(), failed1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		queue.pop()
+	}()
+	// when
+	accepted := queue.push(context.Background(), failed2)
+	// then
+	assert.True(t, accepted)
+	e, _ := queue.pop()
+	assert.Equal(t, failed2, e)
+}
+
+func TestBoundedQueueBlockPolicyDropsEventAfterBlockTimeout(t *testing.T) {
+	t.Parallel()
+	// given
+	queue, err := newBoundedQueue(1, PolicyBlock, 10*time.Millisecond)
+	require.NoError(t, err)
+	queue.push(context.Background(), failed1)
+	// when
+	accepted := queue.push(context.Background(), failed2)
+	// then
+	assert.False(t, accepted)
+	e, _ := queue.pop()
+	assert.Equal(t, failed1, e)
+}
+
+func TestBoundedQueueBlockPolicyStopsWaitingWhenContextIsDone(t *testing.T) {
+	t.Parallel()
+	// given
+	queue, err := newBoundedQueue(1, PolicyBlock, time.Hour)
+	require.NoError(t, err)
+	queue.push(context.Background(), failed1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// when
+	accepted := queue.push(ctx, failed2)
+	// then
+	assert.False(t, accepted)
+}
+
+func TestBoundedQueueSignalsReadyAfterPush(t *testing.T) {
+	t.Parallel()
+	// given
+	queue, err := newBoundedQueue(1, PolicyBlock, 0)
+	require.NoError(t, err)
+	// when
+	queue.push(context.Background(), failed1)
+	// then
+	select {
+	case <-queue.ready():
+	default:
+		t.Fatal("queue not ready")
+	}
+}
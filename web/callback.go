@@ -0,0 +1,138 @@
+package web
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+// DefaultCallbackPath is where AppCop listens for Marathon http_callback events
+const DefaultCallbackPath = "/v2/events/callback"
+
+// maxCallbackBodySize limits size of accepted event
+const maxCallbackBodySize = 10 * 1024 * 1024
+
+// CallbackTokenParam is query parameter carrying shared secret, Marathon
+// does not send custom headers so token is part of subscription URL
+const CallbackTokenParam = "token"
+
+// callbackSource is EventSource receiving events POSTed by Marathon started
+// with http_callback event subscriber. When callback url is configured,
+// source registers itself in Marathon on start and unregisters on stop.
+// Only events carrying configured token are accepted, otherwise anyone
+// reaching listen address could penalize applications with forged events.
+type callbackSource struct {
+	dispatcher  dispatcher
+	marathon    marathon.Marathoner
+	callbackURL string
+	token       string
+	ctx         context.Context
+	close       context.CancelFunc
+}
+
+func newCallbackSource(ctx context.Context, config Config, d dispatcher, m marathon.Marathoner) *callbackSource {
+	ctx, cancel := context.WithCancel(ctx)
+	return &callbackSource{
+		dispatcher:  d,
+		marathon:    m,
+		callbackURL: config.CallbackURL,
+		token:       config.CallbackToken,
+		ctx:         ctx,
+		close:       cancel,
+	}
+}
+
+// Start registers event subscription in Marathon, events are accepted by
+// ServeHTTP
+func (c *callbackSource) Start() chan<- stopEvent {
+	subscription := ""
+	if c.callbackURL != "" {
+		var err error
+		subscription, err = c.subscriptionURL()
+		if err != nil {
+			log.WithError(err).WithField("CallbackURL", c.callbackURL).Error("Invalid callback URL")
+		} else if err := c.marathon.EventSubscriptionRegister(c.ctx, subscription); err != nil {
+			log.WithError(err).WithField("CallbackURL", c.callbackURL).Error("Unable to register event subscription")
+		}
+	}
+
+	stopChan := make(chan stopEvent)
+	go func() {
+		<-stopChan
+		c.close()
+		if subscription == "" {
+			return
+		}
+		// source context is already cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := c.marathon.EventSubscriptionUnregister(ctx, subscription); err != nil {
+			log.WithError(err).WithField("CallbackURL", c.callbackURL).Error("Unable to unregister event subscription")
+		}
+	}()
+	return stopChan
+}
+
+// subscriptionURL is callback URL with token, as registered in Marathon
+func (c *callbackSource) subscriptionURL() (string, error) {
+	u, err := url.Parse(c.callbackURL)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set(CallbackTokenParam, c.token)
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// authorized compares token in constant time, source without token rejects
+// every event
+func (c *callbackSource) authorized(r *http.Request) bool {
+	token := r.URL.Query().Get(CallbackTokenParam)
+	return c.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) == 1
+}
+
+// ServeHTTP accepts single event POSTed by Marathon
+func (c *callbackSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !c.authorized(r) {
+		metrics.Mark("events.callback.unauthorized")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if c.ctx.Err() != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBodySize))
+	if err != nil {
+		metrics.Mark("events.callback.error")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	event := struct {
+		EventType string `json:"eventType"`
+	}{}
+	if err := json.Unmarshal(body, &event); err != nil || event.EventType == "" {
+		metrics.Mark("events.callback.error")
+		log.WithField("Body", string(body)).Error("Could not parse callback event")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	metrics.Mark("events.callback")
+	c.dispatcher.dispatch(c.ctx, Event{eventType: event.EventType, body: body})
+	w.WriteHeader(http.StatusOK)
+}
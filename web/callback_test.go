@@ -0,0 +1,105 @@
+package web
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/marathon/marathontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var callbackTestCases = []struct {
+	method         string
+	body           string
+	expectedStatus int
+	expectedEvents int
+}{
+	{"POST", `{"eventType": "status_update_event", "appId": "/app"}`, http.StatusOK, 1},
+	{"POST", `{"appId": "/app"}`, http.StatusBadRequest, 0},
+	{"POST", `garbage`, http.StatusBadRequest, 0},
+	{"GET", ``, http.StatusMethodNotAllowed, 0},
+}
+
+func TestCallbackSourceTestCases(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range callbackTestCases {
+		// given
+		eventQueue := newTestQueue(t)
+		source := newCallbackSource(context.Background(), Config{CallbackToken: "secret"}, dispatcher{shards: shards{eventQueue}}, marathon.MStub{})
+		request := httptest.NewRequest(testCase.method, DefaultCallbackPath+"?token=secret", strings.NewReader(testCase.body))
+		recorder := httptest.NewRecorder()
+		// when
+		source.ServeHTTP(recorder, request)
+		// then
+		assert.Equal(t, testCase.expectedStatus, recorder.Code, testCase.body)
+		assert.Equal(t, testCase.expectedEvents, eventQueue.len(), testCase.body)
+	}
+}
+
+func TestCallbackSourceRejectsEventsWithoutValidToken(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range []struct {
+		token string
+		path  string
+	}{
+		{"secret", DefaultCallbackPath},
+		{"secret", DefaultCallbackPath + "?token=wrong"},
+		{"", DefaultCallbackPath + "?token="},
+	} {
+		// given
+		eventQueue := newTestQueue(t)
+		source := newCallbackSource(context.Background(), Config{CallbackToken: testCase.token}, dispatcher{shards: shards{eventQueue}}, marathon.MStub{})
+		body := `{"eventType": "status_update_event", "appId": "/app"}`
+		recorder := httptest.NewRecorder()
+		// when
+		source.ServeHTTP(recorder, httptest.NewRequest("POST", testCase.path, strings.NewReader(body)))
+		// then
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, testCase.path)
+		assert.Equal(t, 0, eventQueue.len(), testCase.path)
+	}
+}
+
+func TestCallbackSourceRequiresToken(t *testing.T) {
+	t.Parallel()
+	// when
+	_, err := newEventSource(context.Background(), Config{EventSource: SourceCallback}, dispatcher{}, marathon.MStub{})
+	// then
+	assert.Error(t, err)
+}
+
+func TestCallbackSourceRegistersAndReceivesEventsFromMarathon(t *testing.T) {
+	t.Parallel()
+	// given
+	server := marathontest.NewServer()
+	defer server.Close()
+	server.AddApp(&marathon.App{ID: "/app", Instances: 1})
+	m, err := marathon.New(server.Config())
+	require.NoError(t, err)
+
+	eventQueue := newTestQueue(t)
+	source := newCallbackSource(context.Background(), Config{CallbackToken: "secret"}, dispatcher{shards: shards{eventQueue}}, m)
+	listener := httptest.NewServer(source)
+	defer listener.Close()
+	source.callbackURL = listener.URL + DefaultCallbackPath
+	// when
+	stop := source.Start()
+	server.SendStatusUpdate("/app", "app.1", "TASK_FAILED")
+	// then
+	assert.Equal(t, []string{source.callbackURL + "?token=secret"}, server.EventSubscriptions())
+	e := receiveEvent(t, eventQueue, statusUpdateEvent)
+	assert.Contains(t, string(e.body), `"taskStatus":"TASK_FAILED"`)
+	// when
+	stop <- stopEvent{}
+	// then
+	deadline := time.Now().Add(time.Second)
+	for len(server.EventSubscriptions()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Empty(t, server.EventSubscriptions())
+}
@@ -1,10 +1,58 @@
 package web
 
+import "time"
+
 // Config specific to web package
 type Config struct {
 	Listen       string
 	Location     string
 	QueueSize    int
 	WorkersCount int
-	MyLeader     string
+	// ReconnectBackoff is initial delay before resubscribing to broken
+	// event stream, server may override it with retry field
+	ReconnectBackoff    time.Duration
+	ReconnectMaxBackoff time.Duration
+	// FilterEvents limits subscription to event types AppCop handles
+	FilterEvents bool
+	// StreamSilenceTimeout forces resubscribe when nothing was read from
+	// event stream for that long, zero disables watchdog
+	StreamSilenceTimeout time.Duration
+	// QueuePolicy tells what happens when worker queue is full, see
+	// PolicyBlock, PolicyDropOldest, PolicyDropNewest, PolicyDropLowPriority
+	QueuePolicy string
+	// QueueBlockTimeout limits how long PolicyBlock waits for free space,
+	// after that event is dropped, zero means one second
+	QueueBlockTimeout time.Duration
+	// EventSource is SourceSSE, SourceReplay, SourceCallback or SourceMesos
+	EventSource string
+	// MesosMaster is URL of Mesos master subscribed by SourceMesos, only
+	// tasks of MesosFramework are passed to workers
+	MesosMaster    string
+	MesosFramework string
+	// CallbackPath is where Marathon callback events are accepted,
+	// CallbackURL (if set) is registered in Marathon as event subscription
+	CallbackPath string
+	CallbackURL  string
+	// CallbackToken is shared secret required in token query parameter of
+	// callback events
+	CallbackToken string
+	// AppFaultWeight, InfraFaultWeight and OperatorWeight are scores added
+	// on single task failure of given category, infrastructure faults are
+	// counted in agent health instead of application score
+	AppFaultWeight   int
+	InfraFaultWeight int
+	OperatorWeight   int
+	// RecordFile, when set, receives every event as JSON line
+	RecordFile     string
+	RecordMaxSize  int64
+	RecordMaxFiles int
+	// ReplayFiles is comma separated list of recorded files replayed in order
+	ReplayFiles string
+	ReplaySpeed float64
+	// DrainFile receives events left in queues on shutdown, in format of
+	// RecordFile
+	DrainFile string
+	// ShutdownTimeout limits time spent on draining queues and waiting for
+	// actions in progress
+	ShutdownTimeout time.Duration
 }
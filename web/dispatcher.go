@@ -0,0 +1,23 @@
+package web
+
+import (
+	"context"
+	"time"
+)
+
+// dispatcher passes events from event source to workers, optionally
+// recording them
+type dispatcher struct {
+	shards   shards
+	recorder *asyncRecorder
+}
+
+func (d dispatcher) dispatch(ctx context.Context, e Event) {
+	if e.timestamp.IsZero() {
+		e.timestamp = time.Now()
+	}
+	if d.recorder != nil {
+		d.recorder.record(e)
+	}
+	d.shards.queue(e).push(ctx, e)
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -15,6 +16,8 @@ type Event struct {
 	eventType string
 	body      []byte
 	id        string
+	// retry is reconnection time requested by server, zero if not sent
+	retry time.Duration
 }
 
 func (e *Event) parseLine(line []byte) bool {
@@ -29,7 +32,7 @@ func (e *Event) parseLine(line []byte) bool {
 	//If the line is empty (a blank line)
 	if len(line) == 0 {
 		//Dispatch the event, as defined below.
-		return !e.isEmpty()
+		return !e.isEmpty() || e.retry > 0
 	}
 
 	//If the line starts with a U+003A COLON character (:)
@@ -74,7 +77,11 @@ func (e *Event) parseLine(line []byte) bool {
 		//Set the last event ID buffer to the field value.
 		e.id = stringValue
 	case "retry":
-		// TODO consider reconnection delay
+		//If the field value consists of only ASCII digits, then interpret the field value as an integer in base ten,
+		//and set the event stream's reconnection time to that integer. Otherwise, ignore the field.
+		if ms, err := strconv.ParseUint(stringValue, 10, 32); err == nil {
+			e.retry = time.Duration(ms) * time.Millisecond
+		}
 	}
 
 	return false
@@ -104,7 +111,9 @@ func parseEvent(reader *bufio.Reader) (Event, error) {
 	return e, nil
 }
 
-func subscribeURL(auth *url.Userinfo, location string) string {
+// subscribeURL builds Marathon event stream URL, when eventTypes are provided
+// Marathon sends only events of these types
+func subscribeURL(auth *url.Userinfo, location string, eventTypes []string) string {
 
 	marathonurl := url.URL{
 		Scheme: "http",
@@ -113,6 +122,9 @@ func subscribeURL(auth *url.Userinfo, location string) string {
 		Path:   "/v2/events",
 	}
 	query := marathonurl.Query()
+	for _, eventType := range eventTypes {
+		query.Add("event_type", eventType)
+	}
 
 	marathonurl.RawQuery = query.Encode()
 	return marathonurl.String()
@@ -2,38 +2,67 @@ package web
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/agent"
 	"github.com/allegro/marathon-appcop/marathon"
 	"github.com/allegro/marathon-appcop/metrics"
 	"github.com/allegro/marathon-appcop/score"
 )
 
 type eventHandler struct {
+	ctx         context.Context
 	id          int
 	marathon    marathon.Marathoner
-	eventQueue  <-chan Event
+	eventQueue  *boundedQueue
 	scoreUpdate chan score.Update
+	weights     weights
+	agents      *agent.Registry
+	quit        chan stopEvent
+	// done receives value when worker stopped
+	done chan struct{}
 }
 
 type stopEvent struct{}
 
 const (
-	taskFinished = "TASK_FINISHED"
-	taskFailed   = "TASK_FAILED"
-	taskKilled   = "TASK_KILLED"
-	taskRunning  = "TASK_RUNNING"
+	statusUpdateEvent      = "status_update_event"
+	unhealthyTaskKillEvent = "unhealthy_task_kill_event"
 )
 
-func newEventHandler(id int, marathon marathon.Marathoner, eventQueue <-chan Event,
-	scoreUpdate chan score.Update) *eventHandler {
+// handledEventTypes lists events processed by eventHandler, subscription to
+// Marathon event stream is limited to them
+var handledEventTypes = []string{statusUpdateEvent, unhealthyTaskKillEvent}
+
+const (
+	taskFinished       = "TASK_FINISHED"
+	taskFailed         = "TASK_FAILED"
+	taskKilled         = "TASK_KILLED"
+	taskRunning        = "TASK_RUNNING"
+	taskError          = "TASK_ERROR"
+	taskLost           = "TASK_LOST"
+	taskDropped        = "TASK_DROPPED"
+	taskGone           = "TASK_GONE"
+	taskUnreachable    = "TASK_UNREACHABLE"
+	taskGoneByOperator = "TASK_GONE_BY_OPERATOR"
+)
+
+func newEventHandler(ctx context.Context, id int, marathon marathon.Marathoner, eventQueue *boundedQueue,
+	scoreUpdate chan score.Update, weights weights, agents *agent.Registry) *eventHandler {
 	return &eventHandler{
+		ctx:         ctx,
 		id:          id,
 		marathon:    marathon,
 		eventQueue:  eventQueue,
 		scoreUpdate: scoreUpdate,
+		weights:     weights,
+		agents:      agents,
+		// buffered, so stopping does not wait for event in progress
+		quit: make(chan stopEvent, 1),
+		done: make(chan struct{}, 1),
 	}
 }
 
@@ -49,22 +78,37 @@ func (fh *eventHandler) Start() chan<- stopEvent {
 		}
 	}
 
-	quitChan := make(chan stopEvent)
 	log.WithField("Id", fh.id).Println("Starting worker")
 	go func() {
+		defer func() { fh.done <- struct{}{} }()
 		for {
 			select {
-			case event = <-fh.eventQueue:
-				metrics.Mark(fmt.Sprintf("events.handler.%d", fh.id))
-				metrics.UpdateGauge("events.queue.len", int64(len(fh.eventQueue)))
-				metrics.UpdateGauge("events.queue.delay_ns", time.Since(event.timestamp).Nanoseconds())
-				metrics.Time("events.processing."+event.eventType, process)
-			case <-quitChan:
+			case <-fh.eventQueue.ready():
+				var ok bool
+				for event, ok = fh.eventQueue.pop(); ok; event, ok = fh.eventQueue.pop() {
+					metrics.Mark(fmt.Sprintf("events.handler.%d", fh.id))
+					metrics.UpdateGauge(fmt.Sprintf("events.queue.%d.len", fh.id), int64(fh.eventQueue.len()))
+					metrics.UpdateGauge("events.queue.delay_ns", time.Since(event.timestamp).Nanoseconds())
+					metrics.Time("events.processing."+event.eventType, process)
+				}
+			case <-fh.quit:
 				log.WithField("Id", fh.id).Info("Stopping worker")
+				return
 			}
 		}
 	}()
-	return quitChan
+	return fh.quit
+}
+
+// wait until worker stops or ctx is done, returns false when worker is still
+// running
+func (fh *eventHandler) wait(ctx context.Context) bool {
+	select {
+	case <-fh.done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 func (fh *eventHandler) handleEvent(eventType string, body []byte) error {
@@ -72,11 +116,12 @@ func (fh *eventHandler) handleEvent(eventType string, body []byte) error {
 	body = replaceTaskIDWithID(body)
 
 	switch eventType {
-	case "status_update_event":
+	case statusUpdateEvent:
 		return fh.handleStatusEvent(body)
-	case "unhealthy_task_kill_event":
+	case unhealthyTaskKillEvent:
 		return fh.handleUnhealthyTaskKillEvent(body)
 	default:
+		metrics.Mark("events.ignored")
 		log.WithField("EventType", eventType).Debug("Not handled event type")
 		return nil
 	}
@@ -93,34 +138,46 @@ func (fh *eventHandler) handleStatusEvent(body []byte) error {
 	log.WithFields(log.Fields{
 		"Id":         task.ID,
 		"TaskStatus": task.TaskStatus,
+		"Reason":     task.Reason,
+		"Source":     task.Source,
 	}).Debug("Got StatusEvent")
 
-	appMetric := task.GetMetric(fh.marathon.GetAppIDPrefix())
-	metrics.MarkApp(appMetric)
+	prefix := fh.marathon.GetAppIDPrefix()
+	metrics.MarkAppStatus(task.GetMetric(prefix), task.GetMetricApp(prefix), task.TaskStatus)
 
-	switch task.TaskStatus {
-	case taskFinished, taskFailed, taskKilled:
-		appID := task.AppID
-		app, err := fh.marathon.AppGet(appID)
-		if err != nil {
-			return err
-		}
-		fh.scoreUpdate <- score.Update{App: app, Update: 1}
-		return nil
-	case taskRunning:
+	if task.TaskStatus == taskRunning {
 		log.WithFields(log.Fields{
 			"Id":    task.AppID,
 			"Host":  task.Host,
 			"Ports": task.Ports,
 		}).Info("Got task running status")
 		return nil
-	default:
+	}
+
+	category, failed := classify(task.TaskStatus, task.Reason)
+	if !failed {
 		log.WithFields(log.Fields{
 			"Id":         task.ID,
 			"taskStatus": task.TaskStatus,
 		}).Debug("Not handled task status")
 		return nil
 	}
+	metrics.Mark("events.task." + category)
+
+	weight := fh.weights[category]
+	if weight == 0 {
+		return nil
+	}
+	if category == infraFault {
+		fh.agents.Fault(task.Host, weight, task.Reason)
+		return nil
+	}
+	app, err := fh.marathon.AppGet(fh.ctx, task.AppID)
+	if err != nil {
+		return err
+	}
+	fh.scoreUpdate <- score.Update{App: app, Update: weight, Task: task.ID, Host: task.Host, Reason: task.Reason}
+	return nil
 }
 
 func (fh *eventHandler) handleUnhealthyTaskKillEvent(body []byte) error {
@@ -137,12 +194,12 @@ func (fh *eventHandler) handleUnhealthyTaskKillEvent(body []byte) error {
 
 	// update score killed app
 	appID := task.AppID
-	app, err := fh.marathon.AppGet(appID)
+	app, err := fh.marathon.AppGet(fh.ctx, appID)
 	if err != nil {
 		log.WithField("appID", appID).Error("Could not get app by id")
 		return err
 	}
-	fh.scoreUpdate <- score.Update{App: app, Update: 1}
+	fh.scoreUpdate <- score.Update{App: app, Update: fh.weights[appFault], Task: task.ID, Host: task.Host}
 	return nil
 }
 
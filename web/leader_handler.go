@@ -0,0 +1,31 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/leader"
+)
+
+// LeaderResponse tells whether AppCop replica leads
+type LeaderResponse struct {
+	Leading bool `json:"leading"`
+	// Since is time of last leadership change, empty when replica never led
+	Since string `json:"since,omitempty"`
+}
+
+// LeaderHandler reports leadership state of this replica
+func LeaderHandler(leadership *leader.Monitor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := LeaderResponse{Leading: leadership.Leading()}
+		if since := leadership.Since(); !since.IsZero() {
+			response.Since = since.UTC().Format(time.RFC3339)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.WithError(err).Error("Unable to write leader response")
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/leader"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func leaderResponse(t *testing.T, leadership *leader.Monitor) LeaderResponse {
+	recorder := httptest.NewRecorder()
+	LeaderHandler(leadership)(recorder, httptest.NewRequest("GET", "/leader", nil))
+	response := LeaderResponse{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	return response
+}
+
+func TestLeaderHandlerReportsLeadership(t *testing.T) {
+	t.Parallel()
+	// given
+	elector, err := leader.New(leader.Config{MyLeader: "marathon:8080"}, marathon.MStub{Leader: "marathon:8080"})
+	require.NoError(t, err)
+	leadership := leader.NewMonitor(elector, time.Millisecond)
+	acquired := make(chan bool, 1)
+	leadership.Notify(func(leading bool) { acquired <- leading })
+	// when
+	before := leaderResponse(t, leadership)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go leadership.Run(ctx)
+	<-acquired
+	after := leaderResponse(t, leadership)
+	// then
+	assert.Equal(t, LeaderResponse{Leading: false}, before)
+	assert.True(t, after.Leading)
+	assert.NotEmpty(t, after.Since)
+}
@@ -0,0 +1,413 @@
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+const (
+	// DefaultMesosFramework is name Marathon registers with in Mesos
+	DefaultMesosFramework = "marathon"
+	// maxRecordSize limits single RecordIO record, SUBSCRIBED event carries
+	// whole cluster state so it may be large
+	maxRecordSize = 256 * 1024 * 1024
+	// appsRefreshInterval limits how often unknown task makes source list
+	// Marathon applications
+	appsRefreshInterval = 5 * time.Second
+)
+
+// mesosSource is EventSource subscribing to Mesos master operator API. Mesos
+// reports task status with reason and source of failure, which Marathon
+// event bus does not pass. TASK_UPDATED events of Marathon framework are
+// converted to Marathon status_update_event, other events only keep track of
+// agents hostnames and framework names.
+type mesosSource struct {
+	dispatcher dispatcher
+	marathon   marathon.Marathoner
+	master     string
+	framework  string
+	// no timeout, subscription is expected to be open for a long time
+	client     *http.Client
+	ctx        context.Context
+	close      context.CancelFunc
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	silence    time.Duration
+	// agents maps agent id to hostname and frameworks maps framework id to
+	// name, both accessed only from subscription goroutine
+	agents     map[string]string
+	frameworks map[string]string
+	// apps maps task id prefix to application id, accessed only from
+	// subscription goroutine
+	apps            map[string]marathon.AppID
+	appsRefreshedAt time.Time
+}
+
+type mesosID struct {
+	Value string `json:"value"`
+}
+
+type mesosAgent struct {
+	AgentInfo struct {
+		ID       mesosID `json:"id"`
+		Hostname string  `json:"hostname"`
+	} `json:"agent_info"`
+}
+
+type mesosFramework struct {
+	FrameworkInfo struct {
+		ID   mesosID `json:"id"`
+		Name string  `json:"name"`
+	} `json:"framework_info"`
+}
+
+type mesosTaskStatus struct {
+	TaskID    mesosID `json:"task_id"`
+	AgentID   mesosID `json:"agent_id"`
+	State     string  `json:"state"`
+	Reason    string  `json:"reason"`
+	Source    string  `json:"source"`
+	Message   string  `json:"message"`
+	Timestamp float64 `json:"timestamp"`
+}
+
+type mesosTaskUpdated struct {
+	FrameworkID mesosID         `json:"framework_id"`
+	State       string          `json:"state"`
+	Status      mesosTaskStatus `json:"status"`
+}
+
+// mesosEvent is event sent by Mesos master to operator API subscriber, only
+// fields used by AppCop are decoded
+type mesosEvent struct {
+	Type       string `json:"type"`
+	Subscribed *struct {
+		GetState struct {
+			GetAgents struct {
+				Agents []mesosAgent `json:"agents"`
+			} `json:"get_agents"`
+			GetFrameworks struct {
+				Frameworks []mesosFramework `json:"frameworks"`
+			} `json:"get_frameworks"`
+		} `json:"get_state"`
+	} `json:"subscribed"`
+	AgentAdded *struct {
+		Agent mesosAgent `json:"agent"`
+	} `json:"agent_added"`
+	AgentRemoved *struct {
+		AgentID mesosID `json:"agent_id"`
+	} `json:"agent_removed"`
+	FrameworkAdded *struct {
+		Framework mesosFramework `json:"framework"`
+	} `json:"framework_added"`
+	FrameworkUpdated *struct {
+		Framework mesosFramework `json:"framework"`
+	} `json:"framework_updated"`
+	TaskUpdated *mesosTaskUpdated `json:"task_updated"`
+}
+
+// mesosStatusUpdate is Marathon status_update_event built from TASK_UPDATED,
+// extended with Mesos failure details
+type mesosStatusUpdate struct {
+	EventType  string `json:"eventType"`
+	Timestamp  string `json:"timestamp,omitempty"`
+	TaskID     string `json:"taskId"`
+	AppID      string `json:"appId"`
+	TaskStatus string `json:"taskStatus"`
+	Host       string `json:"host,omitempty"`
+	SlaveID    string `json:"slaveId,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Source     string `json:"source,omitempty"`
+}
+
+func newMesosSource(ctx context.Context, config Config, d dispatcher, m marathon.Marathoner) (*mesosSource, error) {
+	if config.MesosMaster == "" {
+		return nil, errors.New("no Mesos master to subscribe to")
+	}
+	framework := config.MesosFramework
+	if framework == "" {
+		framework = DefaultMesosFramework
+	}
+	minBackoff, maxBackoff := reconnectBackoff(config)
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &mesosSource{
+		dispatcher: d,
+		marathon:   m,
+		master:     strings.TrimSuffix(config.MesosMaster, "/"),
+		framework:  framework,
+		client:     &http.Client{},
+		ctx:        ctx,
+		close:      cancel,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		silence:    config.StreamSilenceTimeout,
+		agents:     make(map[string]string),
+		frameworks: make(map[string]string),
+		apps:       make(map[string]marathon.AppID),
+	}, nil
+}
+
+// Start subscribes to Mesos master, subscription is reopened until source is
+// stopped
+func (m *mesosSource) Start() chan<- stopEvent {
+	stopChan := make(chan stopEvent)
+	go func() {
+		<-stopChan
+		m.close()
+	}()
+
+	go m.run()
+	return stopChan
+}
+
+func (m *mesosSource) run() {
+	var disconnectedAt time.Time
+	attempt := 0
+	for {
+		// Mesos sends HEARTBEAT events, so watchdog detects dead subscription
+		subCtx, subCancel := context.WithCancel(m.ctx)
+		res, err := m.subscribe(subCtx)
+		if err == nil {
+			if !disconnectedAt.IsZero() {
+				metrics.Mark("events.reconnects")
+				metrics.UpdateTimer("events.disconnected", time.Since(disconnectedAt))
+			}
+			metrics.UpdateGauge("events.connected", 1)
+			attempt = 0
+			watchdog := newWatchdog(m.silence)
+			go watchdog.watch(subCtx, subCancel)
+			err = m.read(watchdog.reader(res.Body))
+			close(res)
+			metrics.UpdateGauge("events.connected", 0)
+			disconnectedAt = time.Now()
+		} else if disconnectedAt.IsZero() {
+			disconnectedAt = time.Now()
+		}
+		subCancel()
+
+		if m.ctx.Err() != nil {
+			log.WithField("Master", m.master).Info("Mesos subscription closed")
+			return
+		}
+
+		metrics.Mark("events.disconnects")
+		delay := backoff(m.minBackoff, m.maxBackoff, attempt)
+		attempt++
+		log.WithFields(log.Fields{
+			"Master":  m.master,
+			"Attempt": attempt,
+			"Delay":   delay,
+		}).WithError(err).Warn("Mesos subscription broken, resubscribing")
+
+		select {
+		case <-m.ctx.Done():
+			log.WithField("Master", m.master).Info("Mesos subscription closed")
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (m *mesosSource) subscribe(ctx context.Context) (*http.Response, error) {
+	// non leading master redirects to leader, body is resent on redirect
+	req, err := http.NewRequest("POST", m.master+"/api/v1", bytes.NewReader([]byte(`{"type":"SUBSCRIBE"}`)))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		close(res)
+		return nil, fmt.Errorf("expected 200 but got %d", res.StatusCode)
+	}
+	log.WithField("Master", m.master).Debug("Mesos subscription success")
+	return res, nil
+}
+
+// read handles events from body until subscription is broken
+func (m *mesosSource) read(body io.Reader) error {
+	reader := bufio.NewReader(body)
+	for {
+		record, err := readRecord(reader)
+		if err != nil {
+			return err
+		}
+		e := mesosEvent{}
+		if err := json.Unmarshal(record, &e); err != nil {
+			metrics.Mark("events.mesos.error")
+			log.WithError(err).Error("Could not parse Mesos event")
+			continue
+		}
+		m.handle(e)
+	}
+}
+
+func (m *mesosSource) handle(e mesosEvent) {
+	switch {
+	case e.Type == "SUBSCRIBED" && e.Subscribed != nil:
+		m.agents = make(map[string]string)
+		for _, agent := range e.Subscribed.GetState.GetAgents.Agents {
+			m.addAgent(agent)
+		}
+		m.frameworks = make(map[string]string)
+		for _, framework := range e.Subscribed.GetState.GetFrameworks.Frameworks {
+			m.addFramework(framework)
+		}
+		log.WithFields(log.Fields{
+			"Agents":     len(m.agents),
+			"Frameworks": len(m.frameworks),
+		}).Info("Subscribed to Mesos master")
+	case e.Type == "AGENT_ADDED" && e.AgentAdded != nil:
+		m.addAgent(e.AgentAdded.Agent)
+	case e.Type == "AGENT_REMOVED" && e.AgentRemoved != nil:
+		delete(m.agents, e.AgentRemoved.AgentID.Value)
+	case e.Type == "FRAMEWORK_ADDED" && e.FrameworkAdded != nil:
+		m.addFramework(e.FrameworkAdded.Framework)
+	case e.Type == "FRAMEWORK_UPDATED" && e.FrameworkUpdated != nil:
+		m.addFramework(e.FrameworkUpdated.Framework)
+	case e.Type == "TASK_UPDATED" && e.TaskUpdated != nil:
+		event, ok := m.statusUpdate(*e.TaskUpdated)
+		if !ok {
+			metrics.Mark("events.mesos.ignored")
+			return
+		}
+		m.dispatcher.dispatch(m.ctx, event)
+	default:
+		log.WithField("Type", e.Type).Debug("Not handled Mesos event type")
+	}
+}
+
+func (m *mesosSource) addAgent(agent mesosAgent) {
+	m.agents[agent.AgentInfo.ID.Value] = agent.AgentInfo.Hostname
+}
+
+func (m *mesosSource) addFramework(framework mesosFramework) {
+	m.frameworks[framework.FrameworkInfo.ID.Value] = framework.FrameworkInfo.Name
+}
+
+// statusUpdate converts TASK_UPDATED to Marathon status_update_event, returns
+// false when task does not belong to Marathon framework
+func (m *mesosSource) statusUpdate(u mesosTaskUpdated) (Event, bool) {
+	if m.frameworks[u.FrameworkID.Value] != m.framework {
+		return Event{}, false
+	}
+	taskID := marathon.TaskID(u.Status.TaskID.Value)
+	if !strings.Contains(taskID.String(), ".") {
+		return Event{}, false
+	}
+	appID := m.appID(taskID)
+
+	state := u.State
+	if state == "" {
+		state = u.Status.State
+	}
+	update := mesosStatusUpdate{
+		EventType:  statusUpdateEvent,
+		TaskID:     taskID.String(),
+		AppID:      appID.String(),
+		TaskStatus: state,
+		Host:       m.agents[u.Status.AgentID.Value],
+		SlaveID:    u.Status.AgentID.Value,
+		Message:    u.Status.Message,
+		Reason:     u.Status.Reason,
+		Source:     u.Status.Source,
+	}
+	if u.Status.Timestamp > 0 {
+		seconds, fraction := math.Modf(u.Status.Timestamp)
+		update.Timestamp = time.Unix(int64(seconds), int64(fraction*1e9)).UTC().Format(time.RFC3339Nano)
+	}
+	body, err := json.Marshal(update)
+	if err != nil {
+		log.WithError(err).Error("Could not convert Mesos task update")
+		return Event{}, false
+	}
+	return Event{eventType: statusUpdateEvent, body: body}, true
+}
+
+// appID resolves application of Marathon task. Task id is application id
+// with slashes replaced by underscores, followed by dot and unique suffix,
+// which is ambiguous when application id contains underscores or dots, so
+// it is matched against applications listed by Marathon. Task id is parsed
+// only when Marathon does not know matching application.
+func (m *mesosSource) appID(taskID marathon.TaskID) marathon.AppID {
+	if appID, ok := m.lookupApp(taskID); ok {
+		return appID
+	}
+	if time.Since(m.appsRefreshedAt) >= appsRefreshInterval {
+		m.appsRefreshedAt = time.Now()
+		apps, err := m.marathon.AppsGet(m.ctx)
+		if err != nil {
+			log.WithError(err).Warn("Unable to list applications of Mesos tasks")
+		} else {
+			m.apps = make(map[string]marathon.AppID, len(apps))
+			for _, app := range apps {
+				m.apps[taskIDPrefix(app.ID)] = app.ID
+			}
+			if appID, ok := m.lookupApp(taskID); ok {
+				return appID
+			}
+		}
+	}
+	metrics.Mark("events.mesos.unknown_app")
+	return taskID.AppID()
+}
+
+// lookupApp matches longest task id prefix ending before dot, so suffixes
+// with dots (e.g. instance id and incarnation) are skipped
+func (m *mesosSource) lookupApp(taskID marathon.TaskID) (marathon.AppID, bool) {
+	id := taskID.String()
+	for i := strings.LastIndex(id, "."); i > 0; i = strings.LastIndex(id[:i], ".") {
+		if appID, ok := m.apps[id[:i]]; ok {
+			return appID, true
+		}
+	}
+	return "", false
+}
+
+// taskIDPrefix returns beginning of task ids of application
+func taskIDPrefix(appID marathon.AppID) string {
+	return strings.Replace(strings.TrimPrefix(appID.String(), "/"), "/", "_", -1)
+}
+
+// readRecord reads single RecordIO record, that is record length in bytes
+// followed by new line and record itself
+func readRecord(reader *bufio.Reader) ([]byte, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid record length %q", line)
+	}
+	if size < 0 || size > maxRecordSize {
+		return nil, fmt.Errorf("record length %d out of range", size)
+	}
+	record := make([]byte, size)
+	if _, err := io.ReadFull(reader, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,180 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var readRecordTestCases = []struct {
+	stream          string
+	expectedRecords []string
+	expectedError   bool
+}{
+	{"", nil, false},
+	{"2\n{}", []string{"{}"}, false},
+	{"2\n{}12\n{\"type\":\"A\"}", []string{"{}", `{"type":"A"}`}, false},
+	{"0\n", []string{""}, false},
+	{"x\n{}", nil, true},
+	{"-1\n", nil, true},
+	{"10\n{}", nil, true},
+}
+
+func TestReadRecordTestCases(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range readRecordTestCases {
+		// given
+		reader := bufio.NewReader(strings.NewReader(testCase.stream))
+		var records []string
+		// when
+		var err error
+		for {
+			var record []byte
+			if record, err = readRecord(reader); err != nil {
+				break
+			}
+			records = append(records, string(record))
+		}
+		// then
+		assert.Equal(t, testCase.expectedRecords, records, testCase.stream)
+		if testCase.expectedError {
+			assert.NotEqual(t, "EOF", err.Error(), testCase.stream)
+		} else {
+			assert.Equal(t, "EOF", err.Error(), testCase.stream)
+		}
+	}
+}
+
+func TestNewMesosSourceRequiresMaster(t *testing.T) {
+	t.Parallel()
+	// when
+	_, err := newMesosSource(context.Background(), Config{EventSource: SourceMesos}, dispatcher{}, marathon.MStub{})
+	// then
+	assert.Error(t, err)
+}
+
+const mesosSubscribed = `{"type": "SUBSCRIBED", "subscribed": {"get_state": {
+	"get_agents": {"agents": [{"agent_info": {"id": {"value": "agent-1"}, "hostname": "host-1"}}]},
+	"get_frameworks": {"frameworks": [
+		{"framework_info": {"id": {"value": "fw-marathon"}, "name": "marathon"}},
+		{"framework_info": {"id": {"value": "fw-other"}, "name": "chronos"}}
+	]}}}}`
+
+func taskUpdatedEvent(framework, taskID, state, reason string) string {
+	return fmt.Sprintf(`{"type": "TASK_UPDATED", "task_updated": {"framework_id": {"value": %q}, "state": %q,
+		"status": {"task_id": {"value": %q}, "agent_id": {"value": "agent-1"}, "state": %q, "reason": %q,
+		"source": "SOURCE_SLAVE", "message": "Memory limit exceeded", "timestamp": 1488369600.5}}}`,
+		framework, state, taskID, state, reason)
+}
+
+// mesosMaster serves provided events as RecordIO stream and keeps
+// subscription open until request is cancelled
+func mesosMaster(events ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.URL.Path != "/api/v1" || !strings.Contains(string(body), "SUBSCRIBE") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		for _, e := range events {
+			fmt.Fprintf(w, "%d\n%s", len(e), e)
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+func TestMesosSourceConvertsTaskUpdatesOfMarathonFramework(t *testing.T) {
+	t.Parallel()
+	// given
+	master := mesosMaster(
+		mesosSubscribed,
+		`{"type": "HEARTBEAT"}`,
+		taskUpdatedEvent("fw-other", "job.1", "TASK_FAILED", "REASON_COMMAND_EXECUTOR_FAILED"),
+		taskUpdatedEvent("fw-marathon", "group_app.1", "TASK_FAILED", "REASON_CONTAINER_LIMITATION_MEMORY"),
+	)
+	defer master.Close()
+	eventQueue := newTestQueue(t)
+	source, err := newMesosSource(context.Background(), Config{MesosMaster: master.URL + "/"},
+		dispatcher{shards: shards{eventQueue}}, marathon.MStub{})
+	require.NoError(t, err)
+	// when
+	stop := source.Start()
+	defer func() { stop <- stopEvent{} }()
+	// then
+	e := receiveEvent(t, eventQueue, statusUpdateEvent)
+	task, err := marathon.ParseTask(replaceTaskIDWithID(e.body))
+	require.NoError(t, err)
+	assert.Equal(t, marathon.TaskID("group_app.1"), task.ID)
+	assert.Equal(t, marathon.AppID("/group/app"), task.AppID)
+	assert.Equal(t, "TASK_FAILED", task.TaskStatus)
+	assert.Equal(t, "host-1", task.Host)
+	assert.Equal(t, "REASON_CONTAINER_LIMITATION_MEMORY", task.Reason)
+	assert.Equal(t, "SOURCE_SLAVE", task.Source)
+	assert.Equal(t, "Memory limit exceeded", task.Message)
+	assert.Contains(t, string(e.body), `"timestamp":"2017-03-01T12:00:00.5Z"`)
+	assert.Equal(t, 0, eventQueue.len())
+}
+
+func TestMesosSourceTakesAppIDOfTaskFromMarathon(t *testing.T) {
+	t.Parallel()
+	// given
+	m := marathon.MStub{Apps: []*marathon.App{
+		{ID: "/group/my_app"},
+		{ID: "/com.example/app"},
+	}}
+	source, err := newMesosSource(context.Background(), Config{MesosMaster: "http://mesos"}, dispatcher{}, m)
+	require.NoError(t, err)
+	for _, testCase := range []struct {
+		taskID   string
+		expected marathon.AppID
+	}{
+		{"group_my_app.7c2a0e41-4b7f-11e7-9b5f-0242ac110002", "/group/my_app"},
+		{"com.example_app.instance-7c2a0e41-4b7f-11e7-9b5f-0242ac110002._app.1", "/com.example/app"},
+		{"unknown_app.1", "/unknown/app"},
+	} {
+		// when
+		appID := source.appID(marathon.TaskID(testCase.taskID))
+		// then
+		assert.Equal(t, testCase.expected, appID, testCase.taskID)
+	}
+}
+
+func TestMesosSourceResubscribesWhenSubscriptionIsRejected(t *testing.T) {
+	t.Parallel()
+	// given
+	attempts := make(chan struct{}, 1)
+	master := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signal(attempts)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer master.Close()
+	source, err := newMesosSource(context.Background(), Config{
+		MesosMaster:         master.URL,
+		ReconnectBackoff:    time.Millisecond,
+		ReconnectMaxBackoff: time.Millisecond,
+	}, dispatcher{shards: shards{newTestQueue(t)}}, marathon.MStub{})
+	require.NoError(t, err)
+	// when
+	stop := source.Start()
+	defer func() { stop <- stopEvent{} }()
+	// then
+	for i := 0; i < 2; i++ {
+		select {
+		case <-attempts:
+		case <-time.After(time.Second):
+			t.Fatal("no subscription attempt")
+		}
+	}
+}
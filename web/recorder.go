@@ -0,0 +1,219 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+const (
+	defaultRecordMaxSize  = 100 * 1024 * 1024
+	defaultRecordMaxFiles = 5
+	// recordBufferSize is number of events waiting to be written to record
+	// file, above that events are not recorded
+	recordBufferSize = 1000
+)
+
+// recordedEvent is JSONL representation of Event
+type recordedEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	ID        string    `json:"id,omitempty"`
+	Body      string    `json:"body"`
+}
+
+func (r recordedEvent) event() Event {
+	return Event{
+		timestamp: r.Timestamp,
+		eventType: r.Type,
+		id:        r.ID,
+		body:      []byte(r.Body),
+	}
+}
+
+// recorder appends events to JSONL file. When file grows over maxSize it is
+// rotated to path.1, path.1 to path.2 and so on, at most maxFiles rotated
+// files are kept.
+type recorder struct {
+	mutex    sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func newRecorder(path string, maxSize int64, maxFiles int) (*recorder, error) {
+	if maxSize <= 0 {
+		maxSize = defaultRecordMaxSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = defaultRecordMaxFiles
+	}
+	r := &recorder{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *recorder) record(e Event) error {
+	line, err := json.Marshal(recordedEvent{
+		Timestamp: e.timestamp,
+		Type:      e.eventType,
+		ID:        e.id,
+		Body:      string(e.body),
+	})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+func (r *recorder) close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
+
+// open opens recording file for appending, must be called with mutex held
+func (r *recorder) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate shifts rotated files and starts new one, must be called with mutex
+// held
+func (r *recorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	for i := r.maxFiles - 1; i > 0; i-- {
+		err := os.Rename(r.rotatedPath(i), r.rotatedPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(r.path, r.rotatedPath(1)); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *recorder) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+// asyncRecorder writes events with recorder on own goroutine, so event
+// source is not held by file I/O. Events are dropped from record when buffer
+// is full.
+type asyncRecorder struct {
+	recorder *recorder
+	events   chan Event
+	// quit stops writing once buffered events are written, done receives
+	// value when writing stopped
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newAsyncRecorder(r *recorder, bufferSize int) *asyncRecorder {
+	a := &asyncRecorder{
+		recorder: r,
+		events:   make(chan Event, bufferSize),
+		quit:     make(chan struct{}, 1),
+		done:     make(chan struct{}, 1),
+	}
+	go a.write()
+	return a
+}
+
+func (a *asyncRecorder) write() {
+	defer func() { a.done <- struct{}{} }()
+	for {
+		select {
+		case e := <-a.events:
+			a.writeEvent(e)
+		case <-a.quit:
+			for {
+				select {
+				case e := <-a.events:
+					a.writeEvent(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *asyncRecorder) writeEvent(e Event) {
+	if err := a.recorder.record(e); err != nil {
+		metrics.Mark("events.record.error")
+		log.WithError(err).Error("Unable to record event")
+	}
+}
+
+// record queues event for writing without blocking, must not be called
+// after close
+func (a *asyncRecorder) record(e Event) {
+	select {
+	case a.events <- e:
+	default:
+		metrics.Mark("events.record.dropped")
+	}
+}
+
+// close writes buffered events and closes record file, must be called once
+func (a *asyncRecorder) close() error {
+	a.quit <- struct{}{}
+	<-a.done
+	return a.recorder.close()
+}
+
+// readRecordedEvents calls fn for every event recorded in reader
+func readRecordedEvents(reader io.Reader, fn func(Event) error) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		recorded := recordedEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), &recorded); err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		if err := fn(recorded.event()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,189 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorderWritesEventsThatCanBeReadBack(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, err := ioutil.TempDir("", "recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	recorder, err := newRecorder(filepath.Join(dir, "events.jsonl"), 0, 0)
+	require.NoError(t, err)
+	timestamp := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	expected := []Event{
+		{timestamp: timestamp, eventType: statusUpdateEvent, id: "1", body: []byte(`{"appId": "/app"}` + "\n")},
+		{timestamp: timestamp.Add(time.Second), eventType: "event_stream_attached", body: []byte("not json\n")},
+	}
+	// when
+	for _, e := range expected {
+		require.NoError(t, recorder.record(e))
+	}
+	require.NoError(t, recorder.close())
+	// then
+	file, err := os.Open(filepath.Join(dir, "events.jsonl"))
+	require.NoError(t, err)
+	defer file.Close()
+	var actual []Event
+	err = readRecordedEvents(file, func(e Event) error {
+		actual = append(actual, e)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, actual, 2)
+	for i := range expected {
+		assert.True(t, expected[i].timestamp.Equal(actual[i].timestamp))
+		assert.Equal(t, expected[i].eventType, actual[i].eventType)
+		assert.Equal(t, expected[i].id, actual[i].id)
+		assert.Equal(t, expected[i].body, actual[i].body)
+	}
+}
+
+func TestRecorderRotatesFileWhenItGrowsOverMaxSize(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, err := ioutil.TempDir("", "recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	recorder, err := newRecorder(path, 100, 2)
+	require.NoError(t, err)
+	// when
+	for i := 0; i < 5; i++ {
+		require.NoError(t, recorder.record(Event{eventType: statusUpdateEvent, body: bytes.Repeat([]byte("x"), 50)}))
+	}
+	require.NoError(t, recorder.close())
+	// then
+	files, err := filepath.Glob(path + "*")
+	require.NoError(t, err)
+	assert.Equal(t, []string{path, path + ".1", path + ".2"}, files)
+}
+
+func TestReadRecordedEventsReturnsErrorForMalformedLine(t *testing.T) {
+	t.Parallel()
+	// when
+	err := readRecordedEvents(bytes.NewBufferString("{}\ngarbage\n"), func(Event) error { return nil })
+	// then
+	assert.EqualError(t, err, "line 2: invalid character 'g' looking for beginning of value")
+}
+
+func TestReplaySourceDeliversRecordedEventsToWorkers(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, err := ioutil.TempDir("", "replay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	recorder, err := newRecorder(path, 0, 0)
+	require.NoError(t, err)
+	timestamp := time.Now()
+	require.NoError(t, recorder.record(Event{timestamp: timestamp, eventType: "event_stream_attached"}))
+	require.NoError(t, recorder.record(Event{timestamp: timestamp.Add(time.Second), eventType: statusUpdateEvent}))
+	require.NoError(t, recorder.close())
+
+	eventQueue := newTestQueue(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source, err := newReplaySource(ctx, Config{ReplayFiles: path, ReplaySpeed: 100},
+		dispatcher{shards: shards{eventQueue}})
+	require.NoError(t, err)
+	// when
+	source.Start()
+	// then
+	receiveEvent(t, eventQueue, "event_stream_attached")
+	e := receiveEvent(t, eventQueue, statusUpdateEvent)
+	assert.True(t, time.Since(e.timestamp) < time.Second)
+	select {
+	case <-source.done:
+	case <-time.After(time.Second):
+		t.Fatal("replay not finished")
+	}
+}
+
+func TestAsyncRecorderWritesBufferedEventsOnClose(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, err := ioutil.TempDir("", "recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	r, err := newRecorder(path, 0, 0)
+	require.NoError(t, err)
+	recorder := newAsyncRecorder(r, 10)
+	// when
+	for i := 0; i < 5; i++ {
+		recorder.record(Event{eventType: statusUpdateEvent, id: strconv.Itoa(i)})
+	}
+	require.NoError(t, recorder.close())
+	// then
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var ids []string
+	require.NoError(t, readRecordedEvents(file, func(e Event) error {
+		ids = append(ids, e.id)
+		return nil
+	}))
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, ids)
+}
+
+func TestReplaySourceResumesFromCheckpoint(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, err := ioutil.TempDir("", "replay")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	recorder, err := newRecorder(path, 0, 0)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, recorder.record(Event{eventType: statusUpdateEvent, id: strconv.Itoa(i)}))
+	}
+	require.NoError(t, recorder.close())
+
+	eventQueue := newTestQueue(t)
+	source, err := newReplaySource(context.Background(), Config{ReplayFiles: path},
+		dispatcher{shards: shards{eventQueue}})
+	require.NoError(t, err)
+	source.checkpoint = &replayCheckpoint{replayed: 2}
+	// when
+	source.Start()
+	// then
+	select {
+	case <-source.done:
+	case <-time.After(time.Second):
+		t.Fatal("replay not finished")
+	}
+	e := receiveEvent(t, eventQueue, statusUpdateEvent)
+	assert.Equal(t, "2", e.id)
+	assert.Equal(t, 0, eventQueue.len())
+	assert.Equal(t, int64(3), source.checkpoint.replayed)
+}
+
+func TestNewReplaySourceReturnsErrorWithoutFiles(t *testing.T) {
+	t.Parallel()
+	// when
+	_, err := newReplaySource(context.Background(), Config{ReplayFiles: " , "}, dispatcher{})
+	// then
+	assert.Error(t, err)
+}
+
+func TestNewEventSourceReturnsErrorForUnknownSource(t *testing.T) {
+	t.Parallel()
+	// when
+	_, err := newEventSource(context.Background(), Config{EventSource: "pigeon"}, dispatcher{}, nil)
+	// then
+	assert.Error(t, err)
+}
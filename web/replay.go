@@ -0,0 +1,126 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+// replaySource is EventSource reading events recorded by recorder. Events
+// are delivered with original pauses between them divided by speed, zero
+// speed replays events as fast as workers accept them.
+type replaySource struct {
+	dispatcher dispatcher
+	files      []string
+	speed      float64
+	ctx        context.Context
+	close      context.CancelFunc
+	// done is closed when all files were replayed
+	done chan struct{}
+	// checkpoint is shared with sources replaying the same files before,
+	// position counts events read by this source
+	checkpoint *replayCheckpoint
+	position   int64
+}
+
+// replayCheckpoint counts replayed events, so source started again when
+// leadership is regained resumes where previous source stopped instead of
+// replaying files from the beginning
+type replayCheckpoint struct {
+	// replayed is accessed atomically, previous source may still finish
+	// dispatching its last event
+	replayed int64
+}
+
+func newReplaySource(ctx context.Context, config Config, d dispatcher) (*replaySource, error) {
+	var files []string
+	for _, file := range strings.Split(config.ReplayFiles, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no files to replay events from")
+	}
+	if config.ReplaySpeed < 0 {
+		return nil, errors.New("replay speed should not be negative")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &replaySource{
+		dispatcher: d,
+		files:      files,
+		speed:      config.ReplaySpeed,
+		ctx:        ctx,
+		close:      cancel,
+		done:       make(chan struct{}, 1),
+		checkpoint: &replayCheckpoint{},
+	}, nil
+}
+
+// Start replaying events in background
+func (r *replaySource) Start() chan<- stopEvent {
+	stopChan := make(chan stopEvent)
+	go func() {
+		<-stopChan
+		r.close()
+	}()
+
+	go func() {
+		defer func() { r.done <- struct{}{} }()
+		for _, file := range r.files {
+			if err := r.replay(file); err != nil {
+				if r.ctx.Err() == nil {
+					log.WithError(err).WithField("File", file).Error("Unable to replay events")
+				}
+				return
+			}
+		}
+		log.WithField("Files", r.files).Info("Replay finished")
+	}()
+	return stopChan
+}
+
+func (r *replaySource) replay(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	log.WithField("File", path).Info("Replaying events")
+	var previous time.Time
+	return readRecordedEvents(file, func(e Event) error {
+		r.position++
+		if r.position <= atomic.LoadInt64(&r.checkpoint.replayed) {
+			return nil
+		}
+		if r.speed > 0 && !previous.IsZero() && e.timestamp.After(previous) {
+			pause := time.Duration(float64(e.timestamp.Sub(previous)) / r.speed)
+			select {
+			case <-time.After(pause):
+			case <-r.ctx.Done():
+			}
+		}
+		if r.ctx.Err() != nil {
+			return r.ctx.Err()
+		}
+		previous = e.timestamp
+		metrics.Mark("events.replayed")
+		// replayed event is processed as received now
+		e.timestamp = time.Time{}
+		r.dispatcher.dispatch(r.ctx, e)
+		// event dispatched while stopping may be dropped, it is replayed
+		// again by next source
+		if r.ctx.Err() == nil {
+			atomic.StoreInt64(&r.checkpoint.replayed, r.position)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+const drainPollInterval = 10 * time.Millisecond
+
+// shards holds per-worker event queues. Events are routed by application id,
+// so all events of one application are processed in order by single worker.
+type shards []*boundedQueue
+
+func newShards(count int, size int, policy string, blockTimeout time.Duration) (shards, error) {
+	s := make(shards, count)
+	for i := range s {
+		queue, err := newBoundedQueue(size, policy, blockTimeout)
+		if err != nil {
+			return nil, err
+		}
+		s[i] = queue
+	}
+	return s, nil
+}
+
+// route returns index of queue for provided event, events not related to any
+// application always go to the same queue
+func (s shards) route(e Event) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(eventAppID(e.body)))
+	return int(h.Sum32() % uint32(len(s)))
+}
+
+// queue returns queue for provided event and reports its depth
+func (s shards) queue(e Event) *boundedQueue {
+	i := s.route(e)
+	metrics.UpdateGauge(fmt.Sprintf("events.queue.%d.len", i), int64(s[i].len()))
+	return s[i]
+}
+
+// drain waits until all queues are empty or ctx is done, returns false when
+// events are still waiting
+func (s shards) drain(ctx context.Context) bool {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		empty := true
+		for _, queue := range s {
+			empty = empty && queue.len() == 0
+		}
+		if empty {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// eventAppID extracts application id from event body, empty if event does
+// not carry one
+func eventAppID(body []byte) string {
+	event := struct {
+		AppID string `json:"appId"`
+	}{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return ""
+	}
+	return event.AppID
+}
@@ -0,0 +1,51 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventAppIDReturnsApplicationIDFromBody(t *testing.T) {
+	t.Parallel()
+	// expect
+	assert.Equal(t, "/app", eventAppID([]byte(`{"appId": "/app", "taskId": "app.1"}`)))
+	assert.Equal(t, "", eventAppID([]byte(`{"eventType": "event_stream_attached"}`)))
+	assert.Equal(t, "", eventAppID([]byte(`garbage`)))
+}
+
+func TestShardsRouteEventsOfOneApplicationToTheSameQueue(t *testing.T) {
+	t.Parallel()
+	// given
+	s, err := newShards(10, 10, PolicyBlock, 0)
+	require.NoError(t, err)
+	running := Event{eventType: statusUpdateEvent, body: []byte(`{"appId": "/app", "taskStatus": "TASK_RUNNING"}`)}
+	failed := Event{eventType: statusUpdateEvent, body: []byte(`{"appId": "/app", "taskStatus": "TASK_FAILED"}`)}
+	// when
+	s.queue(running).push(context.Background(), running)
+	s.queue(failed).push(context.Background(), failed)
+	// then
+	queue := s[s.route(running)]
+	assert.Equal(t, 2, queue.len())
+	e, _ := queue.pop()
+	assert.Equal(t, running, e)
+	e, _ = queue.pop()
+	assert.Equal(t, failed, e)
+}
+
+func TestShardsSpreadApplicationsAcrossQueues(t *testing.T) {
+	t.Parallel()
+	// given
+	s, err := newShards(4, 100, PolicyBlock, 0)
+	require.NoError(t, err)
+	used := make(map[int]bool)
+	// when
+	for i := 0; i < 100; i++ {
+		used[s.route(Event{body: []byte(fmt.Sprintf(`{"appId": "/app%d"}`, i))})] = true
+	}
+	// then
+	assert.Len(t, used, 4)
+}
@@ -0,0 +1,46 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/allegro/marathon-appcop/marathon"
+)
+
+// Event sources
+const (
+	// SourceSSE subscribes to Marathon event stream
+	SourceSSE = "sse"
+	// SourceReplay reads events recorded to files
+	SourceReplay = "replay"
+	// SourceCallback accepts events POSTed by Marathon http_callback
+	// subscriber
+	SourceCallback = "callback"
+	// SourceMesos subscribes to Mesos master operator API, task updates
+	// carry failure reason and source
+	SourceMesos = "mesos"
+)
+
+// EventSource delivers events to workers until stopped
+type EventSource interface {
+	Start() chan<- stopEvent
+}
+
+func newEventSource(ctx context.Context, config Config, d dispatcher, m marathon.Marathoner) (EventSource, error) {
+	switch config.EventSource {
+	case "", SourceSSE:
+		return newSSEHandler(ctx, config, d, m.AuthGet(), m.LocationGet()), nil
+	case SourceReplay:
+		return newReplaySource(ctx, config, d)
+	case SourceCallback:
+		if config.CallbackToken == "" {
+			return nil, errors.New("callback event source requires events-callback-token-file")
+		}
+		return newCallbackSource(ctx, config, d, m), nil
+	case SourceMesos:
+		return newMesosSource(ctx, config, d, m)
+	default:
+		return nil, fmt.Errorf("unknown event source %q", config.EventSource)
+	}
+}
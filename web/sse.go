@@ -1,68 +1,266 @@
 package web
 
 import (
-	"time"
+	"context"
+	"net/http"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/agent"
+	"github.com/allegro/marathon-appcop/leader"
 	"github.com/allegro/marathon-appcop/marathon"
 	"github.com/allegro/marathon-appcop/metrics"
 	"github.com/allegro/marathon-appcop/mgc"
+	"github.com/allegro/marathon-appcop/queue"
 	"github.com/allegro/marathon-appcop/score"
 )
 
-// Stop all channels
-type Stop func()
+// Stop event processing. Events waiting in queues are processed until
+// provided context is done, events left after that are persisted.
+type Stop func(ctx context.Context)
 
-// NewHandler is main initialization function
-func NewHandler(config Config, marathon marathon.Marathoner, gc *mgc.MarathonGC,
-	scoreUpdate chan score.Update) Stop {
+// NewHandler is main initialization function. Events are received and jobs
+// act only while this replica leads.
+// Returned Stop cancels context shared by all started jobs, so calls to
+// Marathon still in flight after draining are aborted.
+func NewHandler(ctx context.Context, config Config, marathon marathon.Marathoner, gc *mgc.MarathonGC,
+	inspector *queue.Inspector, agents *agent.Registry, leadership *leader.Monitor,
+	scoreUpdate chan score.Update) (Stop, error) {
 
-	// TODO implement proper leader election
-	// Right now this part of code highly rely on marathon v2/leader endpoint
-	leaderPoll(marathon, config.MyLeader)
+	weights, err := newWeights(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// every worker has own queue, events are sharded by application id
+	shards, err := newShards(config.WorkersCount, config.QueueSize, config.QueuePolicy, config.QueueBlockTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	d := dispatcher{shards: shards}
+	if config.RecordFile != "" && config.EventSource == SourceReplay {
+		log.Warn("Replayed events are not recorded")
+	} else if config.RecordFile != "" {
+		r, err := newRecorder(config.RecordFile, config.RecordMaxSize, config.RecordMaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		d.recorder = newAsyncRecorder(r, recordBufferSize)
+	}
 
-	stopChannels := make([]chan<- stopEvent, config.WorkersCount)
-	eventQueue := make(chan Event, config.QueueSize)
+	ctx, cancel := context.WithCancel(ctx)
+
+	checkpoint := &replayCheckpoint{}
+	newSource := func() (EventSource, error) {
+		source, err := newEventSource(ctx, config, d, marathon)
+		if replay, ok := source.(*replaySource); ok {
+			replay.checkpoint = checkpoint
+		}
+		return source, err
+	}
+
+	// source is created upfront to validate configuration, it is started
+	// when this replica becomes leader
+	source, err := newSource()
+	if err != nil {
+		cancel()
+		if d.recorder != nil {
+			d.recorder.close()
+		}
+		return nil, err
+	}
+
+	p := &pipeline{
+		cancel:    cancel,
+		current:   source,
+		newSource: newSource,
+		workers:   make([]*eventHandler, config.WorkersCount),
+		shards:    shards,
+		recorder:  d.recorder,
+		drainFile: config.DrainFile,
+		resigned:  make(chan struct{}, 1),
+	}
 
 	for i := 0; i < config.WorkersCount; i++ {
-		handler := newEventHandler(i, marathon, eventQueue, scoreUpdate)
-		stopChannels[i] = handler.Start()
+		p.workers[i] = newEventHandler(ctx, i, marathon, shards[i], scoreUpdate, weights, agents)
+		p.workers[i].Start()
+	}
+
+	// callback source receives events on AppCop listener
+	if _, ok := source.(http.Handler); ok {
+		callbackPath := config.CallbackPath
+		if callbackPath == "" {
+			callbackPath = DefaultCallbackPath
+		}
+		http.Handle(callbackPath, p)
 	}
 
-	// start dispatcher
-	sse := newSSEHandler(eventQueue, marathon.AuthGet(), marathon.LocationGet())
-	dispatcherStop := sse.start()
-	stopChannels = append(stopChannels, dispatcherStop)
+	// jobs act only while this replica leads
+	leadership.Notify(p.lead)
+	leadership.PauseWhileFollowing(gc)
+	leadership.PauseWhileFollowing(inspector)
+	go func() {
+		defer func() { p.resigned <- struct{}{} }()
+		leadership.Run(ctx)
+	}()
 
 	// schedule marathon GC job
-	go gc.StartMarathonGCJob()
+	go gc.StartMarathonGCJob(ctx)
+
+	// schedule launch queue inspection
+	inspector.StartInspectorJob(ctx)
 
-	return stop(stopChannels)
+	return p.stop, nil
 }
 
-func leaderPoll(service marathon.Marathoner, myLeader string) {
-	pollTicker := time.NewTicker(5 * time.Second)
-	for {
+// pipeline holds parts of event processing which are stopped together
+type pipeline struct {
+	cancel context.CancelFunc
+	// mutex guards event source, which runs only while this replica leads
+	mutex sync.Mutex
+	// current is event source, running when source (its stop channel) is
+	// set. Stopped source is replaced with one made by newSource.
+	current   EventSource
+	source    chan<- stopEvent
+	newSource func() (EventSource, error)
+	stopped   bool
+	// resigned receives value when leadership monitor stopped
+	resigned  chan struct{}
+	workers   []*eventHandler
+	shards    shards
+	recorder  *asyncRecorder
+	drainFile string
+}
+
+// lead starts event source when leadership is acquired and stops it when
+// leadership is lost
+func (p *pipeline) lead(leading bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.stopped {
+		return
+	}
+	if leading {
+		p.subscribe()
+	} else {
+		p.unsubscribe()
+	}
+}
 
-		leader, err := service.LeaderGet()
+// subscribe starts event source, must be called with mutex held
+func (p *pipeline) subscribe() {
+	if p.source != nil {
+		return
+	}
+	if p.current == nil {
+		source, err := p.newSource()
 		if err != nil {
-			log.WithError(err).Error("Error while getting leader")
-			continue
+			log.WithError(err).Error("Unable to create event source")
+			return
 		}
-		if leader == myLeader {
-			break
+		p.current = source
+	}
+	log.Info("Starting event source")
+	p.source = p.current.Start()
+}
+
+// unsubscribe stops event source, must be called with mutex held
+func (p *pipeline) unsubscribe() {
+	if p.source == nil {
+		return
+	}
+	log.Info("Stopping event source")
+	p.source <- stopEvent{}
+	p.source = nil
+	p.current = nil
+}
+
+// ServeHTTP passes callback events to event source while it is running
+func (p *pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mutex.Lock()
+	handler, ok := p.current.(http.Handler)
+	running := p.source != nil
+	p.mutex.Unlock()
+	if !ok || !running {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+func (p *pipeline) stop(ctx context.Context) {
+	log.Info("Stopping event processing")
+	// no new events
+	p.mutex.Lock()
+	p.stopped = true
+	p.unsubscribe()
+	p.mutex.Unlock()
+
+	if !p.shards.drain(ctx) {
+		log.Warn("Events queue not drained before deadline")
+	}
+	for _, worker := range p.workers {
+		worker.quit <- stopEvent{}
+	}
+	for _, worker := range p.workers {
+		if !worker.wait(ctx) {
+			log.WithField("Id", worker.id).Warn("Worker not stopped before deadline")
 		}
-		metrics.UpdateGauge("leader", int64(0))
-		<-pollTicker.C
 	}
-	metrics.UpdateGauge("leader", int64(1))
+	p.persist()
 
+	// cancelled context makes leadership monitor resign
+	p.cancel()
+	if p.resigned != nil {
+		select {
+		case <-p.resigned:
+		case <-ctx.Done():
+			log.Warn("Leadership not resigned before deadline")
+		}
+	}
+	if p.recorder != nil {
+		if err := p.recorder.close(); err != nil {
+			log.WithError(err).Error("Unable to close events record")
+		}
+	}
 }
 
-func stop(channels []chan<- stopEvent) Stop {
-	return func() {
-		for _, channel := range channels {
-			channel <- stopEvent{}
+// persist events left in queues to drain file, so they can be replayed
+func (p *pipeline) persist() {
+	var left []Event
+	for _, queue := range p.shards {
+		for e, ok := queue.pop(); ok; e, ok = queue.pop() {
+			left = append(left, e)
+		}
+	}
+	if len(left) == 0 {
+		return
+	}
+	if p.drainFile == "" {
+		log.WithField("Events", len(left)).Warn("Events left in queue are lost")
+		for _, e := range left {
+			dropped(e)
+		}
+		return
+	}
+
+	drain, err := newRecorder(p.drainFile, 0, 0)
+	if err != nil {
+		log.WithError(err).WithField("Events", len(left)).Error("Unable to persist events left in queue")
+		return
+	}
+	defer drain.close()
+	for _, e := range left {
+		if err := drain.record(e); err != nil {
+			log.WithError(err).Error("Unable to persist event")
+			dropped(e)
+			continue
 		}
+		metrics.Mark("events.persisted")
 	}
+	log.WithFields(log.Fields{
+		"Events": len(left),
+		"File":   p.drainFile,
+	}).Info("Events left in queue persisted")
 }
@@ -3,24 +3,44 @@ package web
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
-
-	"github.com/sethgrid/pester"
-
 	"net/url"
+	"strconv"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+const (
+	defaultReconnectBackoff    = time.Second
+	defaultReconnectMaxBackoff = time.Minute
 )
 
 // SSEHandler defines handler for marathon event stream, opening and closing
-// subscription
+// subscription. When stream is broken handler resubscribes with jittered
+// exponential backoff, resuming from last received event id.
 type SSEHandler struct {
-	eventQueue chan Event
+	dispatcher dispatcher
 	loc        string
-	client     *pester.Client
+	subURL     string
+	client     *http.Client
+	ctx        context.Context
 	close      context.CancelFunc
-	req        *http.Request
+	// filtered is set when subscription is limited to some event types, then
+	// gaps in event ids are expected
+	filtered bool
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	// silence after which stream is considered dead and resubscribed
+	silence time.Duration
+	// retry is reconnection time requested by server with retry field
+	retry       time.Duration
+	lastEventID string
 }
 
 func close(r *http.Response) {
@@ -30,75 +50,226 @@ func close(r *http.Response) {
 	}
 }
 
-func newSSEHandler(eventQueue chan Event, auth *url.Userinfo, loc string) *SSEHandler {
+func newSSEHandler(ctx context.Context, config Config, d dispatcher, auth *url.Userinfo,
+	loc string) *SSEHandler {
 
-	subURL := subscribeURL(auth, loc)
-	req, err := http.NewRequest("GET", subURL, nil)
-	if err != nil {
-		log.WithError(err).Fatalf("Unable to generate sse request %v", err)
-		return nil
-	}
+	minBackoff, maxBackoff := reconnectBackoff(config)
 
-	req.Header.Set("Accept", "text/event-stream")
-	ctx, cancel := context.WithCancel(context.Background())
-	req = req.WithContext(ctx)
+	var eventTypes []string
+	if config.FilterEvents {
+		eventTypes = handledEventTypes
+	}
 
-	client := pester.New()
-	client.Concurrency = 1
-	client.MaxRetries = 3
-	client.Backoff = pester.ExponentialBackoff
-	client.KeepLog = true
+	ctx, cancel := context.WithCancel(ctx)
 
 	return &SSEHandler{
-		eventQueue: eventQueue,
+		dispatcher: d,
 		loc:        loc,
-		client:     client,
+		subURL:     subscribeURL(auth, loc, eventTypes),
+		filtered:   len(eventTypes) > 0,
+		// no overall timeout, event stream is expected to be open for a long
+		// time, but subscription hanging before response is not
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: config.StreamSilenceTimeout,
+		}},
+		ctx:        ctx,
 		close:      cancel,
-		req:        req,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		silence:    config.StreamSilenceTimeout,
 	}
 }
 
-// Open connection to marathon v2/events
-func (h *SSEHandler) start() chan<- stopEvent {
-	res, err := h.client.Do(h.req)
-	if err != nil {
-		log.WithFields(log.Fields{
-			"Location": h.loc,
-			"Method":   "GET",
-		}).Fatalf("error performing request : %v", err)
+// reconnectBackoff returns configured bounds of delay between subscription
+// attempts
+func reconnectBackoff(config Config) (time.Duration, time.Duration) {
+	minBackoff := config.ReconnectBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultReconnectBackoff
 	}
-	if res.StatusCode != http.StatusOK {
-		log.WithFields(log.Fields{
-			"Location": h.loc,
-			"Method":   "GET",
-		}).Errorf("Got status code : %d", res.StatusCode)
+	maxBackoff := config.ReconnectMaxBackoff
+	if maxBackoff < minBackoff {
+		maxBackoff = defaultReconnectMaxBackoff
+		if maxBackoff < minBackoff {
+			maxBackoff = minBackoff
+		}
 	}
-	log.WithFields(log.Fields{
-		"Location": h.loc,
-		"Method":   "GET",
-	}).Debug("Subsciption success")
+	return minBackoff, maxBackoff
+}
+
+// Start opens connection to marathon v2/events, connection is reopened until
+// handler is stopped
+func (h *SSEHandler) Start() chan<- stopEvent {
 	stopChan := make(chan stopEvent)
 	go func() {
 		<-stopChan
 		h.stop()
 	}()
 
-	go func() {
-		defer close(res)
-
-		reader := bufio.NewReader(res.Body)
-		for {
-			e, err := parseEvent(reader)
-			if err != nil {
-				if err == io.EOF {
-					h.eventQueue <- e
-				}
-				log.Fatalf("Error processing parsing event %s", err)
+	go h.run()
+	return stopChan
+}
+
+func (h *SSEHandler) run() {
+	var disconnectedAt time.Time
+	attempt := 0
+	watchdog := newWatchdog(h.silence)
+	go watchdog.report(h.ctx)
+	for {
+		// subscription context is cancelled by watchdog when stream is silent
+		subCtx, subCancel := context.WithCancel(h.ctx)
+		res, err := h.subscribe(subCtx)
+		if err == nil {
+			if !disconnectedAt.IsZero() {
+				metrics.Mark("events.reconnects")
+				metrics.UpdateTimer("events.disconnected", time.Since(disconnectedAt))
 			}
-			h.eventQueue <- e
+			metrics.UpdateGauge("events.connected", 1)
+			attempt = 0
+			go watchdog.watch(subCtx, subCancel)
+			err = h.read(watchdog.reader(res.Body), res)
+			metrics.UpdateGauge("events.connected", 0)
+			disconnectedAt = time.Now()
+		} else if disconnectedAt.IsZero() {
+			disconnectedAt = time.Now()
 		}
-	}()
-	return stopChan
+		subCancel()
+
+		if h.ctx.Err() != nil {
+			log.WithField("Location", h.loc).Info("Subscription closed")
+			return
+		}
+
+		metrics.Mark("events.disconnects")
+		delay := h.backoff(attempt)
+		attempt++
+		log.WithFields(log.Fields{
+			"Location":    h.loc,
+			"Attempt":     attempt,
+			"Delay":       delay,
+			"LastEventID": h.lastEventID,
+		}).WithError(err).Warn("Event stream broken, resubscribing")
+
+		select {
+		case <-h.ctx.Done():
+			log.WithField("Location", h.loc).Info("Subscription closed")
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (h *SSEHandler) subscribe(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequest("GET", h.subURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	if h.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", h.lastEventID)
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		close(res)
+		return nil, fmt.Errorf("expected 200 but got %d", res.StatusCode)
+	}
+	log.WithFields(log.Fields{
+		"Location":    h.loc,
+		"Method":      "GET",
+		"LastEventID": h.lastEventID,
+	}).Debug("Subsciption success")
+	return res, nil
+}
+
+// read passes events from body to queue until stream is broken
+func (h *SSEHandler) read(body io.Reader, res *http.Response) error {
+	defer close(res)
+
+	reader := bufio.NewReader(body)
+	for {
+		e, err := parseEvent(reader)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		h.dispatch(e)
+		if err == io.EOF {
+			return err
+		}
+	}
+}
+
+func (h *SSEHandler) dispatch(e Event) {
+	if e.retry > 0 {
+		h.retry = e.retry
+	}
+	if e.id != "" {
+		if !h.filtered {
+			h.checkGap(e.id)
+		}
+		h.lastEventID = e.id
+	}
+	if e.isEmpty() {
+		return
+	}
+	h.dispatcher.dispatch(h.ctx, e)
+}
+
+// checkGap reports events missed between subscriptions, possible only when
+// server uses numeric event ids
+func (h *SSEHandler) checkGap(id string) {
+	if h.lastEventID == "" {
+		return
+	}
+	last, err := strconv.ParseInt(h.lastEventID, 10, 64)
+	if err != nil {
+		return
+	}
+	current, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return
+	}
+	if missed := current - last - 1; missed > 0 {
+		metrics.Mark("events.gaps")
+		log.WithFields(log.Fields{
+			"LastEventID": h.lastEventID,
+			"EventID":     id,
+			"Missed":      missed,
+		}).Warn("Gap in event stream")
+	}
+}
+
+// backoff returns delay before next subscription attempt. Delay starts with
+// retry value sent by server (or configured minimum) and doubles with every
+// failed attempt.
+func (h *SSEHandler) backoff(attempt int) time.Duration {
+	base := h.minBackoff
+	if h.retry > 0 {
+		base = h.retry
+	}
+	return backoff(base, h.maxBackoff, attempt)
+}
+
+// backoff returns delay doubling base with every attempt, but not longer than
+// max, plus random jitter up to base. Jitter spreads resubscriptions of many
+// AppCop instances and is only added, so delay never drops below base (e.g.
+// retry requested by server).
+func backoff(base, max time.Duration, attempt int) time.Duration {
+	if max < base {
+		max = base
+	}
+	delay := max
+	if attempt < 32 {
+		if d := base << uint(attempt); d > 0 && d < max {
+			delay = d
+		}
+	}
+	return delay + time.Duration(rand.Int63n(int64(base)+1))
 }
 
 // Close connections managed by context
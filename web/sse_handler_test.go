@@ -0,0 +1,159 @@
+package web
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/marathon/marathontest"
+	"github.com/allegro/marathon-appcop/score"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSEHandlerDeliversMarathonEventsToScoreUpdates(t *testing.T) {
+	t.Parallel()
+	// given
+	server := marathontest.NewServer()
+	defer server.Close()
+	server.AddApp(&marathon.App{ID: "/app", Instances: 1})
+	m, err := marathon.New(server.Config())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventQueue := newTestQueue(t)
+	scoreUpdates := make(chan score.Update, 10)
+	newEventHandler(ctx, 0, m, eventQueue, scoreUpdates, testWeights, newTestAgents(t)).Start()
+	newSSEHandler(ctx, Config{}, dispatcher{shards: shards{eventQueue}}, m.AuthGet(), m.LocationGet()).Start()
+	require.True(t, server.WaitForSubscribers(1, time.Second))
+	// when
+	server.SendStatusUpdate("/app", "app.1", "TASK_RUNNING")
+	server.SendStatusUpdate("/app", "app.1", "TASK_FAILED")
+	// then
+	select {
+	case update := <-scoreUpdates:
+		assert.Equal(t, marathon.AppID("/app"), update.App.ID)
+		assert.Equal(t, 1, update.Update)
+	case <-time.After(time.Second):
+		t.Fatal("no score update received")
+	}
+}
+
+func TestSSEHandlerResubscribesAndResumesFromLastEventID(t *testing.T) {
+	t.Parallel()
+	// given
+	server := marathontest.NewServer()
+	defer server.Close()
+	m, err := marathon.New(server.Config())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventQueue := newTestQueue(t)
+	config := Config{ReconnectBackoff: 10 * time.Millisecond, ReconnectMaxBackoff: 20 * time.Millisecond}
+	newSSEHandler(ctx, config, dispatcher{shards: shards{eventQueue}}, m.AuthGet(), m.LocationGet()).Start()
+	require.True(t, server.WaitForSubscribers(1, time.Second))
+	server.SendStatusUpdate("/app", "app.1", "TASK_RUNNING")
+	receiveEvent(t, eventQueue, "event_stream_attached")
+	receiveEvent(t, eventQueue, "status_update_event")
+	// when
+	server.CloseStreams()
+	server.SendStatusUpdate("/app", "app.1", "TASK_FAILED")
+	// then
+	receiveEvent(t, eventQueue, "event_stream_attached")
+	e := receiveEvent(t, eventQueue, "status_update_event")
+	assert.Equal(t, "2", e.id)
+	assert.Equal(t, []string{"", "1"}, server.LastEventIDs())
+}
+
+func TestSSEHandlerHonorsRetrySentByServer(t *testing.T) {
+	t.Parallel()
+	// given
+	server := marathontest.NewServer()
+	defer server.Close()
+	server.SetRetry(5 * time.Second)
+	m, err := marathon.New(server.Config())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventQueue := newTestQueue(t)
+	config := Config{ReconnectBackoff: 10 * time.Millisecond, ReconnectMaxBackoff: 20 * time.Millisecond}
+	handler := newSSEHandler(ctx, config, dispatcher{shards: shards{eventQueue}}, m.AuthGet(), m.LocationGet())
+	handler.Start()
+	require.True(t, server.WaitForSubscribers(1, time.Second))
+	receiveEvent(t, eventQueue, "event_stream_attached")
+	// when
+	server.CloseStreams()
+	// then
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 0, server.Subscribers())
+	assert.Len(t, server.LastEventIDs(), 1)
+}
+
+func TestSSEHandlerBackoffGrowsUpToMaximum(t *testing.T) {
+	t.Parallel()
+	// given
+	handler := &SSEHandler{minBackoff: time.Second, maxBackoff: 10 * time.Second}
+	// when & then
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		10 * time.Second, 10 * time.Second} {
+		delay := handler.backoff(attempt)
+		assert.True(t, delay >= expected && delay <= expected+time.Second, "attempt %d: %s", attempt, delay)
+	}
+	assert.True(t, handler.backoff(100) <= 11*time.Second)
+	// when
+	handler.retry = 30 * time.Second
+	// then
+	for i := 0; i < 100; i++ {
+		delay := handler.backoff(0)
+		assert.True(t, delay >= 30*time.Second && delay <= 60*time.Second, delay.String())
+	}
+}
+
+func receiveEvent(t *testing.T, eventQueue *boundedQueue, eventType string) Event {
+	timeout := time.After(time.Second)
+	for {
+		if e, ok := eventQueue.pop(); ok {
+			require.Equal(t, eventType, e.eventType)
+			return e
+		}
+		select {
+		case <-eventQueue.ready():
+		case <-timeout:
+			t.Fatalf("no %s received", eventType)
+			return Event{}
+		}
+	}
+}
+
+func newTestQueue(t *testing.T) *boundedQueue {
+	queue, err := newBoundedQueue(10, PolicyBlock, 0)
+	require.NoError(t, err)
+	return queue
+}
+
+func TestSSEHandlerWithFilterReceivesOnlyHandledEventTypes(t *testing.T) {
+	t.Parallel()
+	// given
+	server := marathontest.NewServer()
+	defer server.Close()
+	m, err := marathon.New(server.Config())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventQueue := newTestQueue(t)
+	newSSEHandler(ctx, Config{FilterEvents: true}, dispatcher{shards: shards{eventQueue}}, m.AuthGet(), m.LocationGet()).Start()
+	require.True(t, server.WaitForSubscribers(1, time.Second))
+	receiveEvent(t, eventQueue, "event_stream_attached")
+	// when
+	server.SendEvent("api_post_event", `{"eventType": "api_post_event"}`)
+	server.SendEvent("deployment_success", `{"eventType": "deployment_success"}`)
+	server.SendStatusUpdate("/app", "app.1", "TASK_FAILED")
+	// then
+	e := receiveEvent(t, eventQueue, "status_update_event")
+	assert.Equal(t, "3", e.id)
+}
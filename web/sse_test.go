@@ -0,0 +1,143 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/score"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSource() chan<- stopEvent {
+	source := make(chan stopEvent)
+	go func() { <-source }()
+	return source
+}
+
+func TestPipelineStopProcessesQueuedEventsAndStopsWorkers(t *testing.T) {
+	t.Parallel()
+	// given
+	eventQueue := newTestQueue(t)
+	scoreUpdates := make(chan score.Update, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := newEventHandler(ctx, 0, marathon.MStub{}, eventQueue, scoreUpdates, testWeights, newTestAgents(t))
+	p := &pipeline{cancel: cancel, source: newTestSource(), workers: []*eventHandler{worker}, shards: shards{eventQueue}}
+	for i := 0; i < 3; i++ {
+		eventQueue.push(ctx, Event{eventType: statusUpdateEvent, body: []byte(`{"appId": "/app", "taskStatus": "TASK_FAILED"}`)})
+	}
+	worker.Start()
+	deadline, stop := context.WithTimeout(context.Background(), time.Second)
+	defer stop()
+	// when
+	p.stop(deadline)
+	// then
+	assert.Len(t, scoreUpdates, 3)
+	assert.Equal(t, 0, eventQueue.len())
+	assert.Error(t, ctx.Err())
+}
+
+func TestPipelineStopPersistsEventsLeftAfterDeadline(t *testing.T) {
+	t.Parallel()
+	// given
+	dir, err := ioutil.TempDir("", "drain")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	drainFile := filepath.Join(dir, "drain.jsonl")
+
+	eventQueue := newTestQueue(t)
+	_, cancel := context.WithCancel(context.Background())
+	p := &pipeline{cancel: cancel, source: newTestSource(), shards: shards{eventQueue}, drainFile: drainFile}
+	eventQueue.push(context.Background(), Event{eventType: statusUpdateEvent, id: "1"})
+	eventQueue.push(context.Background(), Event{eventType: statusUpdateEvent, id: "2"})
+	deadline, stop := context.WithCancel(context.Background())
+	stop()
+	// when
+	p.stop(deadline)
+	// then
+	file, err := os.Open(drainFile)
+	require.NoError(t, err)
+	defer file.Close()
+	var ids []string
+	require.NoError(t, readRecordedEvents(file, func(e Event) error {
+		ids = append(ids, e.id)
+		return nil
+	}))
+	assert.Equal(t, []string{"1", "2"}, ids)
+	assert.Equal(t, 0, eventQueue.len())
+}
+
+// countingSource counts starts and stops
+type countingSource struct {
+	started chan struct{}
+	stopped chan struct{}
+}
+
+func newCountingSource() *countingSource {
+	return &countingSource{started: make(chan struct{}, 10), stopped: make(chan struct{}, 10)}
+}
+
+func (s *countingSource) Start() chan<- stopEvent {
+	s.started <- struct{}{}
+	stop := make(chan stopEvent)
+	go func() {
+		<-stop
+		s.stopped <- struct{}{}
+	}()
+	return stop
+}
+
+func TestPipelineRunsEventSourceOnlyWhileLeading(t *testing.T) {
+	t.Parallel()
+	// given
+	source := newCountingSource()
+	created := 0
+	p := &pipeline{
+		current: source,
+		newSource: func() (EventSource, error) {
+			created++
+			return source, nil
+		},
+	}
+	// when
+	p.lead(true)
+	p.lead(true)
+	p.lead(false)
+	<-source.stopped
+	p.lead(true)
+	// then
+	assert.Len(t, source.started, 2)
+	assert.Equal(t, 1, created, "stopped source is replaced")
+	// when
+	p.stopped = true
+	p.lead(false)
+	// then
+	assert.Len(t, source.stopped, 0, "stopped pipeline ignores leadership changes")
+}
+
+func TestPipelineRejectsCallbackEventsWhenNotLeading(t *testing.T) {
+	t.Parallel()
+	// given
+	eventQueue := newTestQueue(t)
+	source := newCallbackSource(context.Background(), Config{CallbackToken: "secret"}, dispatcher{shards: shards{eventQueue}}, marathon.MStub{})
+	p := &pipeline{current: source}
+	body := `{"eventType": "status_update_event", "appId": "/app"}`
+	// when
+	notLeading := httptest.NewRecorder()
+	p.ServeHTTP(notLeading, httptest.NewRequest("POST", DefaultCallbackPath+"?token=secret", strings.NewReader(body)))
+	p.lead(true)
+	leading := httptest.NewRecorder()
+	p.ServeHTTP(leading, httptest.NewRequest("POST", DefaultCallbackPath+"?token=secret", strings.NewReader(body)))
+	// then
+	assert.Equal(t, http.StatusServiceUnavailable, notLeading.Code)
+	assert.Equal(t, http.StatusOK, leading.Code)
+	assert.Equal(t, 1, eventQueue.len())
+}
@@ -0,0 +1,74 @@
+package web
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Task failure categories
+const (
+	// appFault is failure caused by application itself, counted in
+	// application score
+	appFault = "app-fault"
+	// infraFault is failure caused by agent or network, counted in agent
+	// health instead of application score
+	infraFault = "infra-fault"
+	// operatorAction is task removed on operator request
+	operatorAction = "operator"
+)
+
+// Mesos reasons of failures caused by agent, older Mesos versions call agent
+// a slave
+const (
+	agentReasonPrefix    = "REASON_AGENT_"
+	oldAgentReasonPrefix = "REASON_SLAVE_"
+)
+
+// taskStateCategories classifies terminal and unreachable task states, other
+// states are not failures
+var taskStateCategories = map[string]string{
+	taskFinished:       appFault,
+	taskFailed:         appFault,
+	taskKilled:         appFault,
+	taskError:          appFault,
+	taskLost:           infraFault,
+	taskDropped:        infraFault,
+	taskGone:           infraFault,
+	taskUnreachable:    infraFault,
+	taskGoneByOperator: operatorAction,
+}
+
+// classify returns failure category of task status, false when status is not
+// a failure. Failures Mesos attributes to agent are infrastructure faults
+// whatever task state is.
+func classify(state, reason string) (string, bool) {
+	category, ok := taskStateCategories[state]
+	if !ok {
+		return "", false
+	}
+	if category == appFault &&
+		(strings.HasPrefix(reason, agentReasonPrefix) || strings.HasPrefix(reason, oldAgentReasonPrefix)) {
+		return infraFault, true
+	}
+	return category, true
+}
+
+// weights maps failure category to score added on single task failure, to
+// application score or agent health for infrastructure faults
+type weights map[string]int
+
+// newWeights returns error when any weight is negative, zero weight means
+// failures of that category are not scored
+func newWeights(config Config) (weights, error) {
+	w := weights{
+		appFault:       config.AppFaultWeight,
+		infraFault:     config.InfraFaultWeight,
+		operatorAction: config.OperatorWeight,
+	}
+	for _, category := range []string{appFault, infraFault, operatorAction} {
+		if w[category] < 0 {
+			return nil, fmt.Errorf("weight of %s should not be negative, got %d", category, w[category])
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,118 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/agent"
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/score"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testWeights = weights{appFault: 1, infraFault: 1, operatorAction: 0}
+
+func newTestAgents(t *testing.T) *agent.Registry {
+	agents, err := agent.New(agent.Config{UnhealthyScore: 10, ResetInterval: time.Hour})
+	require.NoError(t, err)
+	return agents
+}
+
+var classifyTestCases = []struct {
+	state            string
+	reason           string
+	expectedCategory string
+	expectedFailed   bool
+}{
+	{"TASK_RUNNING", "", "", false},
+	{"TASK_STAGING", "", "", false},
+	{"TASK_FINISHED", "", appFault, true},
+	{"TASK_FAILED", "REASON_CONTAINER_LIMITATION_MEMORY", appFault, true},
+	{"TASK_KILLED", "", appFault, true},
+	{"TASK_ERROR", "REASON_TASK_INVALID", appFault, true},
+	{"TASK_FAILED", "REASON_AGENT_RESTARTED", infraFault, true},
+	{"TASK_KILLED", "REASON_SLAVE_DISCONNECTED", infraFault, true},
+	{"TASK_LOST", "", infraFault, true},
+	{"TASK_DROPPED", "", infraFault, true},
+	{"TASK_GONE", "", infraFault, true},
+	{"TASK_UNREACHABLE", "REASON_AGENT_DISCONNECTED", infraFault, true},
+	{"TASK_GONE_BY_OPERATOR", "", operatorAction, true},
+}
+
+func TestClassifyTestCases(t *testing.T) {
+	t.Parallel()
+	for _, testCase := range classifyTestCases {
+		// when
+		category, failed := classify(testCase.state, testCase.reason)
+		// then
+		assert.Equal(t, testCase.expectedCategory, category, testCase.state+" "+testCase.reason)
+		assert.Equal(t, testCase.expectedFailed, failed, testCase.state+" "+testCase.reason)
+	}
+}
+
+func statusEvent(state, reason string) []byte {
+	return []byte(fmt.Sprintf(`{"appId": "/app", "taskId": "app.1", "host": "agent-1", "taskStatus": %q, "reason": %q}`,
+		state, reason))
+}
+
+func TestHandleStatusEventRoutesFailuresByCategory(t *testing.T) {
+	t.Parallel()
+	// given
+	scoreUpdates := make(chan score.Update, 10)
+	agents := newTestAgents(t)
+	m := marathon.MStub{Apps: []*marathon.App{{ID: "/app"}}}
+	handler := newEventHandler(context.Background(), 0, m, newTestQueue(t), scoreUpdates,
+		weights{appFault: 2, infraFault: 3, operatorAction: 0}, agents)
+	// when
+	require.NoError(t, handler.handleEvent(statusUpdateEvent, statusEvent("TASK_FAILED", "REASON_CONTAINER_LIMITATION_MEMORY")))
+	require.NoError(t, handler.handleEvent(statusUpdateEvent, statusEvent("TASK_LOST", "")))
+	require.NoError(t, handler.handleEvent(statusUpdateEvent, statusEvent("TASK_GONE_BY_OPERATOR", "")))
+	require.NoError(t, handler.handleEvent(statusUpdateEvent, statusEvent("TASK_STAGING", "")))
+	// then
+	require.Len(t, scoreUpdates, 1)
+	update := <-scoreUpdates
+	assert.Equal(t, 2, update.Update)
+	assert.Equal(t, "REASON_CONTAINER_LIMITATION_MEMORY", update.Reason)
+	assert.Equal(t, 3, agents.Score("agent-1"))
+}
+
+func TestNewWeightsRejectsNegativeWeights(t *testing.T) {
+	t.Parallel()
+	for _, config := range []Config{
+		{AppFaultWeight: -1, InfraFaultWeight: 1, OperatorWeight: 0},
+		{AppFaultWeight: 1, InfraFaultWeight: -1, OperatorWeight: 0},
+		{AppFaultWeight: 1, InfraFaultWeight: 1, OperatorWeight: -1},
+	} {
+		// when
+		w, err := newWeights(config)
+		// then
+		assert.Error(t, err, "%+v", config)
+		assert.Nil(t, w)
+	}
+}
+
+func TestNewWeightsAcceptsZeroAsNotScored(t *testing.T) {
+	t.Parallel()
+	// when
+	w, err := newWeights(Config{AppFaultWeight: 1, InfraFaultWeight: 2, OperatorWeight: 0})
+	// then
+	require.NoError(t, err)
+	assert.Equal(t, weights{appFault: 1, infraFault: 2, operatorAction: 0}, w)
+}
+
+func TestHandleStatusEventScoresOperatorRemovalWhenWeighted(t *testing.T) {
+	t.Parallel()
+	// given
+	scoreUpdates := make(chan score.Update, 10)
+	m := marathon.MStub{Apps: []*marathon.App{{ID: "/app"}}}
+	handler := newEventHandler(context.Background(), 0, m, newTestQueue(t), scoreUpdates,
+		weights{appFault: 1, infraFault: 1, operatorAction: 5}, newTestAgents(t))
+	// when
+	require.NoError(t, handler.handleEvent(statusUpdateEvent, statusEvent("TASK_GONE_BY_OPERATOR", "")))
+	// then
+	require.Len(t, scoreUpdates, 1)
+	assert.Equal(t, 5, (<-scoreUpdates).Update)
+}
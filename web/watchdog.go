@@ -0,0 +1,116 @@
+package web
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/allegro/marathon-appcop/metrics"
+)
+
+const minWatchdogInterval = 10 * time.Millisecond
+
+// watchdog detects event stream that stays open but delivers nothing. Any
+// data read from stream (events, event_stream_attached and keepalive
+// comments) counts as activity.
+type watchdog struct {
+	silence time.Duration
+	// lastActivity is unix time in nanoseconds, accessed atomically
+	lastActivity int64
+}
+
+func newWatchdog(silence time.Duration) *watchdog {
+	w := &watchdog{silence: silence}
+	w.touch()
+	return w
+}
+
+func (w *watchdog) touch() {
+	atomic.StoreInt64(&w.lastActivity, time.Now().UnixNano())
+}
+
+func (w *watchdog) age() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&w.lastActivity)))
+}
+
+// report updates age of last activity until ctx is done. It runs for whole
+// lifetime of handler, so reported age keeps growing while stream is
+// disconnected.
+func (w *watchdog) report(ctx context.Context) {
+	ticker := time.NewTicker(w.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.UpdateGauge("events.last_event_age_ms", int64(w.age()/time.Millisecond))
+		}
+	}
+}
+
+// watch calls onStale once subscription is silent longer than configured
+// silence, silence before watch started is not counted. Returns when ctx is
+// done or stream is stale. Zero silence disables watch.
+func (w *watchdog) watch(ctx context.Context, onStale func()) {
+	if w.silence <= 0 {
+		return
+	}
+	started := time.Now()
+	ticker := time.NewTicker(w.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			idle := w.age()
+			if watched := time.Since(started); watched < idle {
+				idle = watched
+			}
+			if idle > w.silence {
+				metrics.Mark("events.stale")
+				log.WithFields(log.Fields{
+					"Age":     idle,
+					"Silence": w.silence,
+				}).Warn("Event stream is silent, forcing resubscribe")
+				onStale()
+				return
+			}
+		}
+	}
+}
+
+// interval of checks, fraction of silence so staleness is detected soon
+func (w *watchdog) interval() time.Duration {
+	if w.silence <= 0 {
+		return time.Second
+	}
+	interval := w.silence / 4
+	if interval < minWatchdogInterval {
+		interval = minWatchdogInterval
+	}
+	return interval
+}
+
+// reader marks activity on every successful read from r
+func (w *watchdog) reader(r io.Reader) io.Reader {
+	return &activityReader{reader: r, watchdog: w}
+}
+
+type activityReader struct {
+	reader   io.Reader
+	watchdog *watchdog
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.watchdog.touch()
+	}
+	return n, err
+}
@@ -0,0 +1,153 @@
+package web
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allegro/marathon-appcop/marathon"
+	"github.com/allegro/marathon-appcop/marathon/marathontest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchdogReaderMarksActivity(t *testing.T) {
+	t.Parallel()
+	// given
+	watchdog := newWatchdog(time.Minute)
+	watchdog.lastActivity = time.Now().Add(-time.Hour).UnixNano()
+	// when
+	_, err := ioutil.ReadAll(watchdog.reader(strings.NewReader(":\n")))
+	// then
+	require.NoError(t, err)
+	assert.True(t, watchdog.age() < time.Minute)
+}
+
+func TestWatchdogCallsOnStaleWhenSilent(t *testing.T) {
+	t.Parallel()
+	// given
+	watchdog := newWatchdog(20 * time.Millisecond)
+	stale := make(chan struct{}, 1)
+	// when
+	go watchdog.watch(context.Background(), func() { stale <- struct{}{} })
+	// then
+	select {
+	case <-stale:
+	case <-time.After(time.Second):
+		t.Fatal("stale stream not detected")
+	}
+}
+
+func TestWatchdogStopsWhenContextIsDone(t *testing.T) {
+	t.Parallel()
+	// given
+	watchdog := newWatchdog(20 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	staleCalled := false
+	// when
+	watchdog.watch(ctx, func() { staleCalled = true })
+	// then
+	assert.False(t, staleCalled)
+}
+
+func TestWatchdogDoesNotCountSilenceBeforeWatchStarted(t *testing.T) {
+	t.Parallel()
+	// given
+	watchdog := newWatchdog(50 * time.Millisecond)
+	watchdog.lastActivity = time.Now().Add(-time.Hour).UnixNano()
+	stale := make(chan time.Time, 1)
+	started := time.Now()
+	// when
+	go watchdog.watch(context.Background(), func() { stale <- time.Now() })
+	// then
+	select {
+	case at := <-stale:
+		assert.True(t, at.Sub(started) >= 50*time.Millisecond, at.Sub(started).String())
+	case <-time.After(time.Second):
+		t.Fatal("stale stream not detected")
+	}
+}
+
+func TestSSEHandlerResubscribesWhenSubscriptionHangsBeforeResponse(t *testing.T) {
+	t.Parallel()
+	// given
+	requests := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := Config{
+		ReconnectBackoff:     10 * time.Millisecond,
+		ReconnectMaxBackoff:  20 * time.Millisecond,
+		StreamSilenceTimeout: 50 * time.Millisecond,
+	}
+	// when
+	newSSEHandler(ctx, config, dispatcher{shards: shards{newTestQueue(t)}}, nil, serverURL.Host).Start()
+	// then
+	for i := 0; i < 2; i++ {
+		select {
+		case <-requests:
+		case <-time.After(time.Second):
+			t.Fatal("hanging subscription not retried")
+		}
+	}
+}
+
+func TestSSEHandlerResubscribesWhenStreamIsSilent(t *testing.T) {
+	t.Parallel()
+	// given
+	server := marathontest.NewServer()
+	defer server.Close()
+	m, err := marathon.New(server.Config())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventQueue := newTestQueue(t)
+	config := Config{
+		ReconnectBackoff:     10 * time.Millisecond,
+		ReconnectMaxBackoff:  20 * time.Millisecond,
+		StreamSilenceTimeout: 50 * time.Millisecond,
+	}
+	// when
+	newSSEHandler(ctx, config, dispatcher{shards: shards{eventQueue}}, m.AuthGet(), m.LocationGet()).Start()
+	// then
+	receiveEvent(t, eventQueue, "event_stream_attached")
+	receiveEvent(t, eventQueue, "event_stream_attached")
+	assert.True(t, len(server.LastEventIDs()) >= 2)
+}
+
+func TestSSEHandlerKeepsSubscriptionWhenKeepAlivesArrive(t *testing.T) {
+	t.Parallel()
+	// given
+	server := marathontest.NewServer()
+	defer server.Close()
+	m, err := marathon.New(server.Config())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	eventQueue := newTestQueue(t)
+	config := Config{StreamSilenceTimeout: 200 * time.Millisecond}
+	newSSEHandler(ctx, config, dispatcher{shards: shards{eventQueue}}, m.AuthGet(), m.LocationGet()).Start()
+	receiveEvent(t, eventQueue, "event_stream_attached")
+	// when
+	for i := 0; i < 20; i++ {
+		server.SendKeepAlive()
+		time.Sleep(20 * time.Millisecond)
+	}
+	// then
+	assert.Len(t, server.LastEventIDs(), 1)
+}